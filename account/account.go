@@ -1,14 +1,18 @@
 package account
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/JinlongWukong/DevLab/auth"
+	"github.com/JinlongWukong/DevLab/blueprint"
 	"github.com/JinlongWukong/DevLab/config"
 	"github.com/JinlongWukong/DevLab/k8s"
 	"github.com/JinlongWukong/DevLab/notification"
 	"github.com/JinlongWukong/DevLab/saas"
+	"github.com/JinlongWukong/DevLab/sshkey"
 	"github.com/JinlongWukong/DevLab/vm"
 )
 
@@ -22,8 +26,11 @@ func newAccount(accountRequest AccountRequest) (*Account, error) {
 	}
 
 	newAccount := &Account{
-		Name: accountRequest.Name,
-		Role: accountRequest.Role,
+		Name:     accountRequest.Name,
+		Role:     accountRequest.Role,
+		Provider: accountRequest.Provider,
+		//tokens of deleted account with same name not accepted
+		TokensNotBefore: time.Now(),
 	}
 	if config.Notification.Kind == "webex" {
 		newAccount.Contract = newAccount.Name + "@cisco.com"
@@ -33,6 +40,92 @@ func newAccount(accountRequest AccountRequest) (*Account, error) {
 	return newAccount, nil
 }
 
+//Reject all tokens issued to account before now
+func (a *Account) RevokeTokens() {
+
+	a.lockerTokens.Lock()
+	defer a.lockerTokens.Unlock()
+
+	a.TokensNotBefore = time.Now()
+}
+
+//Tokens issued before this time are not accepted
+func (a *Account) GetTokensNotBefore() time.Time {
+
+	a.lockerTokens.Lock()
+	defer a.lockerTokens.Unlock()
+
+	return a.TokensNotBefore
+}
+
+//Public key part
+func (a *Account) AddPublicKey(name, publicKey string) (*PublicKey, error) {
+
+	key, fingerprint, err := sshkey.ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	a.lockerPublicKeys.Lock()
+	defer a.lockerPublicKeys.Unlock()
+
+	for _, k := range a.PublicKeys {
+		if k.Name == name {
+			return nil, fmt.Errorf("public key %v already existed", name)
+		}
+		if k.Fingerprint == fingerprint {
+			return nil, fmt.Errorf("public key already existed as %v", k.Name)
+		}
+	}
+	newKey := &PublicKey{
+		Name:        name,
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now(),
+	}
+	a.PublicKeys = append(a.PublicKeys, newKey)
+
+	return newKey, nil
+}
+
+func (a *Account) GetPublicKeyByName(name string) (*PublicKey, error) {
+
+	a.lockerPublicKeys.Lock()
+	defer a.lockerPublicKeys.Unlock()
+
+	for _, k := range a.PublicKeys {
+		if k.Name == name {
+			return k, nil
+		}
+	}
+
+	return nil, fmt.Errorf("public key %v not found", name)
+}
+
+//Copy of key library, oldest first
+func (a *Account) GetPublicKeys() []*PublicKey {
+
+	a.lockerPublicKeys.Lock()
+	defer a.lockerPublicKeys.Unlock()
+
+	return append([]*PublicKey{}, a.PublicKeys...)
+}
+
+func (a *Account) DelPublicKey(name string) error {
+
+	a.lockerPublicKeys.Lock()
+	defer a.lockerPublicKeys.Unlock()
+
+	for i, k := range a.PublicKeys {
+		if k.Name == name {
+			a.PublicKeys = append(a.PublicKeys[:i], a.PublicKeys[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("public key %v not found", name)
+}
+
 //VM part
 func (a *Account) GetNumbersOfVm() int {
 
@@ -276,6 +369,75 @@ func (a *Account) IterSoftware() <-chan *saas.Software {
 	return c
 }
 
+//Environment part
+func (a *Account) GetEnvironmentNameList() []string {
+
+	a.lockerEnvironmentSlice.Lock()
+	defer a.lockerEnvironmentSlice.Unlock()
+
+	environmentNames := make([]string, 0)
+	for _, v := range a.Environments {
+		environmentNames = append(environmentNames, v.Name)
+	}
+
+	return environmentNames
+}
+
+func (a *Account) GetEnvironmentByName(name string) (*blueprint.Environment, error) {
+
+	a.lockerEnvironmentSlice.Lock()
+	defer a.lockerEnvironmentSlice.Unlock()
+
+	for _, v := range a.Environments {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Environment %v not found", name)
+}
+
+func (a *Account) AppendEnvironment(environment *blueprint.Environment) {
+
+	a.lockerEnvironmentSlice.Lock()
+	defer a.lockerEnvironmentSlice.Unlock()
+
+	a.Environments = append(a.Environments, environment)
+}
+
+func (a *Account) RemoveEnvironmentByName(name string) error {
+
+	a.lockerEnvironmentSlice.Lock()
+	defer a.lockerEnvironmentSlice.Unlock()
+
+	for i, v := range a.Environments {
+		if v.Name == name {
+			a.Environments = append(a.Environments[:i], a.Environments[i+1:]...)
+			log.Printf("Environment %v has been removed from account %v", name, a.Name)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Environment %v not found", name)
+}
+
+func (a *Account) IterEnvironment() <-chan *blueprint.Environment {
+	c := make(chan *blueprint.Environment)
+
+	f := func() {
+		a.lockerEnvironmentSlice.Lock()
+		defer a.lockerEnvironmentSlice.Unlock()
+
+		for _, v := range a.Environments {
+			c <- v
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
 //Send notification
 func (a *Account) SendNotification(msg string) {
 	if a.Contract != "" {
@@ -283,13 +445,18 @@ func (a *Account) SendNotification(msg string) {
 	}
 }
 
-//Set one-time password
+//Set one-time password, valid for auth.OneTimePassExpiry
 //flag -> true, set a random password
 //flag -> false, clear this password, set to ""
 func (a *Account) SetOneTimePass(flag bool) {
+
+	a.lockerOneTimePass.Lock()
+	defer a.lockerOneTimePass.Unlock()
+
 	if flag {
 		a.OneTimePass = auth.OneTimePassGen(a.Contract)
-		log.Printf("One-time password %v generated for account %v", a.OneTimePass, a.Name)
+		a.OneTimePassExpiry = time.Now().Add(auth.OneTimePassExpiry)
+		log.Printf("One-time password generated for account %v, valid until %v", a.Name, a.OneTimePassExpiry.Format(time.RFC3339))
 	} else {
 		a.OneTimePass = ""
 	}
@@ -297,5 +464,44 @@ func (a *Account) SetOneTimePass(flag bool) {
 
 //Get one-time password
 func (a *Account) GetOneTimePass() string {
+
+	a.lockerOneTimePass.Lock()
+	defer a.lockerOneTimePass.Unlock()
+
 	return a.OneTimePass
 }
+
+//Time to wait before a new one-time password can be sent, 0 means allowed
+func (a *Account) OneTimePassWait() time.Duration {
+
+	a.lockerOneTimePass.Lock()
+	defer a.lockerOneTimePass.Unlock()
+
+	if a.OneTimePass == "" {
+		return 0
+	}
+	sentAt := a.OneTimePassExpiry.Add(-auth.OneTimePassExpiry)
+	if wait := time.Until(sentAt.Add(auth.OneTimePassInterval)); wait > 0 {
+		return wait
+	}
+
+	return 0
+}
+
+//Verify one-time password, it's cleared once used, expired password never matches
+func (a *Account) VerifyOneTimePass(password string) bool {
+
+	a.lockerOneTimePass.Lock()
+	defer a.lockerOneTimePass.Unlock()
+
+	if a.OneTimePass == "" || time.Now().After(a.OneTimePassExpiry) {
+		a.OneTimePass = ""
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(a.OneTimePass), []byte(password)) != 1 {
+		return false
+	}
+	a.OneTimePass = ""
+
+	return true
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
+	"github.com/JinlongWukong/DevLab/auth"
 	"github.com/JinlongWukong/DevLab/utils"
 )
 
@@ -80,8 +82,10 @@ func (m *AccountMap) InitializeAdmin() {
 			OneTimePass: utils.RandomString(10),
 		}
 	}
+	m.Map["admin"].OneTimePassExpiry = time.Now().Add(auth.OneTimePassExpiry)
 
 	log.Printf("Admin one-time random password: %v", m.Map["admin"].OneTimePass)
+	log.Printf("Admin one-time password valid until %v, restart to get a new one", m.Map["admin"].OneTimePassExpiry.Format(time.RFC3339))
 }
 
 func (m *AccountMap) Get(key string) (account *Account, exists bool) {
@@ -101,6 +105,29 @@ func (m *AccountMap) Del(key string) {
 	delete(m.Map, key)
 }
 
+// Replace whole map, used by db restore
+func (m *AccountMap) Replace(newMap map[string]*Account) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copy of map made under lock, accounts guarded by their own locks are shared
+func (m *AccountMap) Snapshot() map[string]*Account {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*Account, len(m.Map))
+	for k, v := range m.Map {
+		copied[k] = v
+	}
+
+	return copied
+}
+
 // Iter iterates over the items in a concurrent map
 // Each item is sent over a channel, so that
 // we can iterate over the map using the builtin range keyword
@@ -2,7 +2,9 @@ package account
 
 import (
 	"sync"
+	"time"
 
+	"github.com/JinlongWukong/DevLab/blueprint"
 	"github.com/JinlongWukong/DevLab/k8s"
 	"github.com/JinlongWukong/DevLab/saas"
 	"github.com/JinlongWukong/DevLab/vm"
@@ -16,21 +18,46 @@ const (
 )
 
 type Account struct {
-	Name                string               `json:"name"`
-	OneTimePass         string               `json:"-"`
-	Role                RoleType             `json:"role"`
-	Contract            string               `json:"contract"`
-	VM                  []*vm.VirtualMachine `json:"vm"`
-	K8S                 []*k8s.K8S           `json:"k8s"`
-	Software            []*saas.Software     `json:"software"`
-	lockerVMSlice       sync.Mutex           `json:"-"`
-	lockerK8SSlice      sync.Mutex           `json:"-"`
-	lockerSoftwareSlice sync.Mutex           `json:"-"`
-	sync.Mutex          `json:"-"`
+	Name              string               `json:"name"`
+	OneTimePass       string               `json:"-"`
+	OneTimePassExpiry time.Time            `json:"-"`
+	Role              RoleType             `json:"role"`
+	Contract          string               `json:"contract"`
+	VM                []*vm.VirtualMachine `json:"vm"`
+	K8S               []*k8s.K8S           `json:"k8s"`
+	Software          []*saas.Software     `json:"software"`
+	Provider          string               `json:"provider,omitempty"`
+	TokensNotBefore   time.Time            `json:"tokensNotBefore"`
+	PublicKeys        []*PublicKey         `json:"publicKeys,omitempty"`
+	//environments deployed from blueprints
+	Environments           []*blueprint.Environment `json:"environment,omitempty"`
+	lockerVMSlice          sync.Mutex               `json:"-"`
+	lockerK8SSlice         sync.Mutex               `json:"-"`
+	lockerSoftwareSlice    sync.Mutex               `json:"-"`
+	lockerTokens           sync.Mutex               `json:"-"`
+	lockerOneTimePass      sync.Mutex               `json:"-"`
+	lockerPublicKeys       sync.Mutex               `json:"-"`
+	lockerEnvironmentSlice sync.Mutex               `json:"-"`
+	sync.Mutex             `json:"-"`
 }
 
 type AccountRequest struct {
 	Name     string   `form:"name" json:"name" binding:"required"`
 	Role     RoleType `form:"role" json:"role" binding:"required"`
 	Contract string   `form:"contract,omitempty" json:"contract,omitempty"`
+	//identity provider which provisioned the account, empty for local account
+	Provider string `form:"-" json:"-"`
+}
+
+//SSH public key in key library of account, injected into vm by name
+type PublicKey struct {
+	Name        string    `json:"name"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type PublicKeyRequest struct {
+	Name string `form:"name" json:"name" binding:"required"`
+	Key  string `form:"key" json:"key" binding:"required"`
 }
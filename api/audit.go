@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/JinlongWukong/DevLab/audit"
+	"github.com/JinlongWukong/DevLab/identity"
+	"github.com/gin-gonic/gin"
+)
+
+//request body larger than this is not recorded
+const auditMaxBody = 1 << 20
+
+//Routes whose kind and action can't be derived from path
+var auditRoutes = map[string][2]string{
+	"/login":             {"account", "login"},
+	"/logout":            {"account", "logout"},
+	"/one-time-password": {"account", "one-time-password"},
+	"/token/refresh":     {"token", "refresh"},
+}
+
+//Response writer keeping error response body
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.Status() >= http.StatusBadRequest && w.body.Len() < 4096 {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+//Record every mutating api call(all methods except GET/HEAD/OPTIONS) into audit log
+//Identity headers sent by client are dropped, only the ones set by authorization middlewares are trusted
+func AuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Header.Del("account")
+		c.Request.Header.Del("owner")
+
+		path := c.FullPath()
+		switch {
+		case c.Request.Method == http.MethodGet,
+			c.Request.Method == http.MethodHead,
+			c.Request.Method == http.MethodOptions,
+			path == "",
+			strings.HasPrefix(path, identity.FakeIssuerPath):
+			c.Next()
+			return
+		}
+
+		params := auditParams(c)
+		writer := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		kind, action := auditAction(c)
+		record := audit.Record{
+			Source:   audit.SourceApi,
+			Actor:    c.GetHeader("account"),
+			Action:   action,
+			Kind:     kind,
+			Target:   auditTarget(c, params),
+			Owner:    c.GetHeader("owner"),
+			Params:   params,
+			Result:   audit.ResultSuccess,
+			Status:   writer.Status(),
+			ClientIP: c.ClientIP(),
+		}
+		//not authenticated yet, e.g. login
+		if record.Actor == "" {
+			record.Actor, _ = params["account"].(string)
+		}
+		if writer.Status() >= http.StatusBadRequest {
+			record.Result = audit.ResultFailure
+			var body struct {
+				Error string `json:"error"`
+			}
+			if json.Unmarshal(writer.body.Bytes(), &body) == nil && body.Error != "" {
+				record.Error = body.Error
+			} else {
+				record.Error = http.StatusText(writer.Status())
+			}
+		}
+		audit.Log(record)
+	}
+}
+
+//Kind is the first path segment, action is :action param, or method with static path segments
+//e.g. POST /vm -> vm create, POST /vm/:name/start -> vm start, DELETE /vm/:name/snapshot/:snapshot -> vm delete-snapshot, PUT /project/:name/member/:account -> project set-member
+func auditAction(c *gin.Context) (string, string) {
+
+	path := c.FullPath()
+	if route, exists := auditRoutes[path]; exists {
+		return route[0], route[1]
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	kind := segments[0]
+	statics := []string{}
+	for _, segment := range segments[1:] {
+		if strings.HasPrefix(segment, ":") == false && strings.HasPrefix(segment, "*") == false {
+			statics = append(statics, segment)
+		}
+	}
+	if action := c.Param("action"); action != "" {
+		return kind, strings.Join(append(statics, action), "-")
+	}
+
+	verb := map[string]string{
+		http.MethodPost:   "create",
+		http.MethodPut:    "set",
+		http.MethodPatch:  "modify",
+		http.MethodDelete: "delete",
+	}[c.Request.Method]
+	switch {
+	case len(statics) == 0:
+		return kind, verb
+	case c.Request.Method == http.MethodPost:
+		return kind, strings.Join(statics, "-")
+	default:
+		return kind, verb + "-" + strings.Join(statics, "-")
+	}
+}
+
+//Target resource, path parameter preferred, then name in request params
+//e.g. quota account/bob, project member dev/bob, vm snapshot admin-1/snap1
+func auditTarget(c *gin.Context, params map[string]interface{}) string {
+
+	target, _ := params["name"].(string)
+	for _, key := range []string{"name", "id", "address", "timestamp"} {
+		if value := c.Param(key); value != "" {
+			target = value
+			break
+		}
+	}
+	if scope := c.Param("scope"); scope != "" {
+		target = scope + "/" + target
+	}
+	for _, key := range []string{"account", "snapshot"} {
+		if child := c.Param(key); child != "" {
+			target = target + "/" + child
+		}
+	}
+
+	return target
+}
+
+//Request parameters from query string and body, body is restored for handler
+func auditParams(c *gin.Context) map[string]interface{} {
+
+	params := make(map[string]interface{})
+	addValues := func(values url.Values) {
+		for k, v := range values {
+			if len(v) == 1 {
+				params[k] = v[0]
+			} else {
+				params[k] = v
+			}
+		}
+	}
+	addValues(c.Request.URL.Query())
+
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return params
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, auditMaxBody+1))
+	c.Request.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+	if err != nil || len(body) > auditMaxBody {
+		return params
+	}
+
+	switch c.ContentType() {
+	case gin.MIMEJSON:
+		fields := make(map[string]interface{})
+		if json.Unmarshal(body, &fields) == nil {
+			for k, v := range fields {
+				params[k] = v
+			}
+		}
+	case gin.MIMEPOSTForm:
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			addValues(values)
+		}
+	}
+
+	return params
+}
@@ -1,10 +1,12 @@
 package api
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"log"
 	"net/http"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,12 +15,22 @@ import (
 	"github.com/gorilla/websocket"
 
 	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/audit"
 	"github.com/JinlongWukong/DevLab/auth"
+	"github.com/JinlongWukong/DevLab/blueprint"
 	"github.com/JinlongWukong/DevLab/db"
+	"github.com/JinlongWukong/DevLab/flavor"
+	"github.com/JinlongWukong/DevLab/hostkey"
+	"github.com/JinlongWukong/DevLab/identity"
+	"github.com/JinlongWukong/DevLab/image"
 	"github.com/JinlongWukong/DevLab/k8s"
 	"github.com/JinlongWukong/DevLab/node"
 	"github.com/JinlongWukong/DevLab/notification"
+	"github.com/JinlongWukong/DevLab/project"
+	"github.com/JinlongWukong/DevLab/quota"
 	"github.com/JinlongWukong/DevLab/saas"
+	"github.com/JinlongWukong/DevLab/sshkey"
+	"github.com/JinlongWukong/DevLab/task"
 	"github.com/JinlongWukong/DevLab/terminal"
 	"github.com/JinlongWukong/DevLab/vm"
 	"github.com/JinlongWukong/DevLab/workflow"
@@ -74,9 +86,22 @@ func VmRequestGetAllHandler(c *gin.Context) {
 	ac := c.GetHeader("account")
 	log.Printf("Recevie vm request get all vm: %v", ac)
 
+	if projectName := c.Query("project"); projectName != "" {
+		list := []vmView{}
+		for _, owner := range allAccounts() {
+			for _, myVm := range owner.VM {
+				if myVm.Project == projectName {
+					list = append(list, newVmView(myVm, owner.Name, ac))
+				}
+			}
+		}
+		c.JSON(http.StatusOK, list)
+		return
+	}
+
 	myaccount, exists := account.AccountDB.Get(ac)
 	if exists == true {
-		c.JSON(http.StatusOK, myaccount.VM)
+		c.JSON(http.StatusOK, newVmViews(myaccount.VM, myaccount.Name, ac))
 	} else {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Account not found",
@@ -95,10 +120,10 @@ func VmRequestGetByNameHandler(c *gin.Context) {
 	name := c.Param("name")
 	log.Printf("Recevie vm request get by name: %v, %v", ac, name)
 
-	myaccount, exists := account.AccountDB.Get(ac)
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
 	if exists == true {
 		if myVM, err := myaccount.GetVmByName(name); err == nil {
-			c.JSON(http.StatusOK, myVM)
+			c.JSON(http.StatusOK, newVmView(myVM, myaccount.Name, ac))
 		} else {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error": "VM not found",
@@ -115,7 +140,8 @@ func VmRequestGetByNameHandler(c *gin.Context) {
 // Create VM
 // This is async call
 // Return:
-//   200: success with VM info
+//   200: success with task id
+//   403: fail quota exceeded
 //   404: fail Account/VM not found
 func VmRequestCreateHandler(c *gin.Context) {
 
@@ -127,14 +153,26 @@ func VmRequestCreateHandler(c *gin.Context) {
 		})
 		return
 	}
+	if vmRequest.Flavor == "" && (vmRequest.CPU <= 0 || vmRequest.Memory <= 0 || vmRequest.Disk <= 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "flavor or all of cpu, mem, disk required"})
+		return
+	}
+	vmRequest.Project = c.Query("project")
 	log.Printf("Recevie vm request to create vm: %v, %v, %v, %v, %v", ac, vmRequest.Type, vmRequest.Flavor, vmRequest.Number, vmRequest.Duration)
 
 	myaccount, exists := account.AccountDB.Get(ac)
 	if exists == true {
-		if _, err := workflow.CreateVMs(myaccount, vmRequest); err != nil {
+		if _, myTask, err := workflow.CreateVMs(myaccount, vmRequest); isQuotaExceeded(err) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else if isCatalogInvalid(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else if err != nil {
 			c.JSON(http.StatusInternalServerError, err.Error())
 		} else {
-			c.JSON(http.StatusOK, "VM creation request accepted")
+			c.JSON(http.StatusOK, gin.H{
+				"message": "VM creation request accepted",
+				"task":    myTask.Id,
+			})
 		}
 	} else {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -155,7 +193,27 @@ func VmRequestActionHandler(c *gin.Context) {
 	action := c.Param("action")
 	log.Printf("Receive VM action request: %v, %v, %v ", ac, name, action)
 
-	myaccount, exists := account.AccountDB.Get(ac)
+	//actions having own request parameters and response
+	//they share this route since gin router can't fall back from static segments to :action
+	switch action {
+	case "resize":
+		VmRequestResizeHandler(c)
+		return
+	case "snapshot":
+		VmRequestSnapshotCreateHandler(c)
+		return
+	case "revert":
+		VmRequestSnapshotActionHandler(c)
+		return
+	case "migrate":
+		VmRequestMigrateHandler(c)
+		return
+	case "clone":
+		VmRequestCloneHandler(c)
+		return
+	}
+
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
 	if exists == true {
 		if myVM, err := myaccount.GetVmByName(name); err == nil {
 			var action_err error
@@ -191,6 +249,237 @@ func VmRequestActionHandler(c *gin.Context) {
 
 }
 
+// Resize VM to new flavor or custom cpu/memory/disk
+// Return:
+//     200     -> success, resized vm returned
+//     403     -> quota exceeded
+//     40x/50x -> failed
+func VmRequestResizeHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	var r vm.VmRequestResize
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if r.Flavor == "" && r.CPU == 0 && r.Memory == 0 && r.Disk == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "flavor or one of cpu, mem, disk required"})
+		return
+	}
+	log.Printf("Receive VM resize request: %v, %v, %+v", c.GetHeader("account"), name, r)
+
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	myVM, err := myaccount.GetVmByName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "VM not found"})
+		return
+	}
+	if err := workflow.ResizeVM(myaccount, myVM, r); isQuotaExceeded(err) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	} else if isCatalogInvalid(err) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newVmView(myVM, myaccount.Name, c.GetHeader("account")))
+}
+
+// Migrate VM to another node, admin only, vm of any account can be migrated
+// Return:
+//     200     -> migration accepted, task returned
+//     40x/50x -> failed
+func VmRequestMigrateHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	if isAdmin(c.GetHeader("account")) == false {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only account with admin role allowed"})
+		return
+	}
+	var r vm.VmRequestMigrate
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive VM migrate request: %v, %v, %+v", c.GetHeader("account"), name, r)
+
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	myVM, err := myaccount.GetVmByName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "VM not found"})
+		return
+	}
+	myTask, err := workflow.MigrateVM(myaccount, myVM, r)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "VM migration request accepted",
+		"task":    myTask.Id,
+	})
+}
+
+// Clone VM into a new vm of caller, same flavor, type, addons and project as source
+// Source is located in owner account, new vm belongs to and is charged against quota of caller
+// This is async call
+// Return:
+//     200     -> clone accepted, new vm and task returned
+//     400     -> source vm can't be cloned, e.g. running without snapshot given
+//     403     -> quota exceeded
+//     40x/50x -> failed
+func VmRequestCloneHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	var r vm.VmRequestClone
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive VM clone request: %v, %v, %+v", c.GetHeader("account"), name, r)
+
+	owner, exists := account.AccountDB.Get(c.GetHeader("owner"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	myVM, err := owner.GetVmByName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "VM not found"})
+		return
+	}
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("account"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	newVm, myTask, err := workflow.CloneVM(myaccount, myVM, r)
+	if isQuotaExceeded(err) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "VM clone request accepted",
+		"vm":      newVmView(newVm, myaccount.Name, myaccount.Name),
+		"task":    myTask.Id,
+	})
+}
+
+// Take a snapshot of VM
+// Return:
+//     201     -> success, snapshot returned
+//     40x/50x -> failed
+func VmRequestSnapshotCreateHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	var r vm.VmRequestSnapshot
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive VM snapshot request: %v, %v, %v", c.GetHeader("account"), name, r.Name)
+
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	myVM, err := myaccount.GetVmByName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "VM not found"})
+		return
+	}
+	snapshot, err := workflow.CreateVMSnapshot(myVM, r)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, snapshot)
+}
+
+// Get snapshots of VM, oldest first
+func VmRequestSnapshotGetAllHandler(c *gin.Context) {
+
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	myVM, err := myaccount.GetVmByName(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "VM not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, myVM.GetSnapshots())
+}
+
+// VM snapshot action, revert(POST /vm/:name/revert) or delete(DELETE /vm/:name/snapshot/:snapshot)
+// Return:
+//     20x     -> success
+//     40x/50x -> failed
+func VmRequestSnapshotActionHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	snapshot := c.Param("snapshot")
+	action := c.Param("action")
+	if c.Request.Method == http.MethodDelete {
+		action = "delete"
+	} else {
+		var r vm.VmRequestSnapshotRevert
+		if err := c.ShouldBind(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		snapshot = r.Snapshot
+	}
+	log.Printf("Receive VM snapshot action request: %v, %v, %v, %v", c.GetHeader("account"), name, snapshot, action)
+
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	myVM, err := myaccount.GetVmByName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "VM not found"})
+		return
+	}
+	if _, err := myVM.GetSnapshotByName(snapshot); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	switch action {
+	case "revert", "delete":
+		if err := workflow.ActionVMSnapshot(myVM, snapshot, action); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Action not support"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
 // VM port expose,
 // Return:
 //     20x     -> success
@@ -207,7 +496,7 @@ func VmRequestPortExposeHandler(c *gin.Context) {
 	log.Printf("Receive VM port expose request: %v, %v, %v, %v ", ac, name,
 		vmRequestPortExpose.Port, vmRequestPortExpose.Protocol)
 
-	myaccount, exists := account.AccountDB.Get(ac)
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
 	if exists == true {
 		if myVM, err := myaccount.GetVmByName(name); err == nil {
 			var action_err error
@@ -242,7 +531,7 @@ func VmRequestWebConsole(c *gin.Context) {
 	}
 
 	tokenString := c.Query("token")
-	err, ac := auth.ValidateToken(tokenString)
+	ac, err := authenticate(tokenString)
 	if err != nil {
 		c.Error(err)
 		return
@@ -251,7 +540,14 @@ func VmRequestWebConsole(c *gin.Context) {
 
 	log.Printf("Receive VM web console request: %v,%v", ac, vmName)
 
-	if ac, exists := account.AccountDB.Get(ac); exists == true {
+	owner, _, err := authorizeResource(ac, kindVm, vmName, project.PermOperate)
+	if err != nil {
+		conn.WriteMessage(1, []byte(err.Error()))
+		conn.Close()
+		return
+	}
+
+	if ac, exists := account.AccountDB.Get(owner); exists == true {
 		if myVm, err := ac.GetVmByName(vmName); err == nil {
 			port, _ := strconv.Atoi(strings.Split(myVm.PortMap[22], ":")[0])
 			host := node.GetNodeByName(myVm.Node)
@@ -259,7 +555,10 @@ func VmRequestWebConsole(c *gin.Context) {
 				return
 			}
 			webTerminal := terminal.NewSSHTerminal("root", myVm.RootPass, host.IpAddress, uint16(port))
+			webTerminal.Name = "vm " + myVm.Name
 			err = webTerminal.Connect()
+			//host key may be recorded on first contact
+			db.NotifyToSave()
 			if err != nil {
 				conn.WriteMessage(1, []byte(err.Error()))
 				conn.Close()
@@ -277,10 +576,11 @@ func VmRequestWebConsole(c *gin.Context) {
 //   404: fail -> Node not found
 func NodeRequestGetAllHandler(c *gin.Context) {
 
+	ac := c.GetHeader("account")
 	log.Println("Receive node request to get all nodes info")
-	allNodesDetails := []*node.Node{}
+	allNodesDetails := []nodeView{}
 	for v := range node.NodeDB.Iter() {
-		allNodesDetails = append(allNodesDetails, v.Value)
+		allNodesDetails = append(allNodesDetails, newNodeView(v.Value, ac))
 	}
 	c.JSON(http.StatusOK, allNodesDetails)
 
@@ -295,7 +595,7 @@ func NodeRequestGetByNameHandler(c *gin.Context) {
 	name := c.Param("name")
 	log.Printf("Receive node request to get node %v info", name)
 	if n, exists := node.NodeDB.Get(name); exists {
-		c.JSON(http.StatusOK, n)
+		c.JSON(http.StatusOK, newNodeView(n, c.GetHeader("account")))
 	} else {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Node not found",
@@ -326,10 +626,12 @@ func NodeRequestCreateHandler(c *gin.Context) {
 			"warn": "node already existed",
 		})
 	} else {
-		go func() {
-			workflow.AddNode(nodeRequest)
-		}()
-		c.JSON(http.StatusOK, nil)
+		myTask, err := workflow.AddNode(nodeRequest)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"task": myTask.Id})
 	}
 
 }
@@ -339,6 +641,7 @@ func NodeRequestCreateHandler(c *gin.Context) {
 //   200: success
 //   400: fail -> bad request
 //   404: fail -> node not found
+//   409: fail -> node already draining
 //   500: fail -> internal error
 func NodeRequestActionHandler(c *gin.Context) {
 
@@ -347,7 +650,27 @@ func NodeRequestActionHandler(c *gin.Context) {
 	log.Printf("Receive node request, node name -> %v action -> %v", name, action)
 
 	switch node.NodeAction(action) {
-	case node.NodeActionRemove, node.NodeActionReboot, node.NodeActionEnable, node.NodeActionDisable:
+	case node.NodeActionDrain:
+		var r node.NodeRequestDrain
+		if err := c.ShouldBind(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, exists := node.NodeDB.Get(name); exists == false {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "node not existed",
+			})
+		} else if myTask, err := workflow.DrainNode(name, r); err != nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			c.JSON(http.StatusOK, gin.H{
+				"message": "Node drain request accepted",
+				"task":    myTask.Id,
+			})
+		}
+	case node.NodeActionRemove, node.NodeActionReboot, node.NodeActionEnable, node.NodeActionDisable, node.NodeActionUncordon:
 		_, exists := node.NodeDB.Get(name)
 		if exists == true {
 			if err := workflow.ActionNode(name, node.NodeAction(action)); err != nil {
@@ -373,6 +696,7 @@ func NodeRequestActionHandler(c *gin.Context) {
 // Create K8S
 // Return:
 //   200: success
+//   403: fail -> quota exceeded
 //   404: fail -> account not found
 //   500: fail -> workflow k8s create failed
 func K8sRequestCreateHandler(c *gin.Context) {
@@ -383,6 +707,7 @@ func K8sRequestCreateHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	k8sRequest.Project = c.Query("project")
 	log.Printf("Recevie k8s create request: %v, %v, %v, %v", ac, k8sRequest.Version,
 		k8sRequest.NumOfContronller, k8sRequest.NumOfWorker)
 
@@ -394,12 +719,20 @@ func K8sRequestCreateHandler(c *gin.Context) {
 		return
 	}
 
-	if err := workflow.CreateK8S(myaccount, k8sRequest); err != nil {
+	myTask, err := workflow.CreateK8S(myaccount, k8sRequest)
+	if isQuotaExceeded(err) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.JSON(http.StatusOK, "K8S creation request accepted")
+	c.JSON(http.StatusOK, gin.H{
+		"message": "K8S creation request accepted",
+		"task":    myTask.Id,
+	})
 
 }
 
@@ -414,7 +747,7 @@ func K8sRequestDeleteHandler(c *gin.Context) {
 	name := c.Param("name")
 	log.Printf("Recevie k8s delete request: %v, %v", ac, name)
 
-	myAccount, exists := account.AccountDB.Get(ac)
+	myAccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
 	if exists == true {
 		if err := workflow.DeleteK8S(myAccount, name); err == nil {
 			c.JSON(http.StatusOK, nil)
@@ -438,6 +771,19 @@ func K8sRequestGetAllHandler(c *gin.Context) {
 	ac := c.GetHeader("account")
 	log.Printf("Recevie k8s get all request: %v", ac)
 
+	if projectName := c.Query("project"); projectName != "" {
+		list := []*k8s.K8S{}
+		for _, owner := range allAccounts() {
+			for _, myK8s := range owner.K8S {
+				if myK8s.Project == projectName {
+					list = append(list, myK8s)
+				}
+			}
+		}
+		c.JSON(http.StatusOK, list)
+		return
+	}
+
 	myaccount, exists := account.AccountDB.Get(ac)
 	if exists == true {
 		c.JSON(http.StatusOK, myaccount.K8S)
@@ -459,7 +805,7 @@ func K8sRequestGetByNameHandler(c *gin.Context) {
 	name := c.Param("name")
 	log.Printf("Recevie k8s get request: %v, %v", ac, name)
 
-	myaccount, exists := account.AccountDB.Get(ac)
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
 	if exists == true {
 		if myk8S, err := myaccount.GetK8sByName(name); err == nil {
 			c.JSON(http.StatusOK, myk8S)
@@ -490,6 +836,7 @@ func SoftwareRequestCreateHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	request.Project = c.Query("project")
 	log.Printf("Recevie software creation request, %v, %v, %v", ac, request.Kind, request.Version)
 
 	myaccount, exists := account.AccountDB.Get(ac)
@@ -498,12 +845,20 @@ func SoftwareRequestCreateHandler(c *gin.Context) {
 		return
 	}
 
-	if err := workflow.CreateSoftware(myaccount, request); err != nil {
+	myTask, err := workflow.CreateSoftware(myaccount, request)
+	if isQuotaExceeded(err) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.JSON(http.StatusOK, "Software creation request accepted")
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Software creation request accepted",
+		"task":    myTask.Id,
+	})
 }
 
 // Software request action handler, start/stop/delete/restart/refresh
@@ -517,7 +872,7 @@ func SoftwareRequestActionHandler(c *gin.Context) {
 	action := c.Param("action")
 	log.Printf("Recevie Software action request: %v, %v, %v ", ac, name, action)
 
-	myAccount, exists := account.AccountDB.Get(ac)
+	myAccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
 	if exists == true {
 		var action_err error
 		switch saas.SoftwareAction(action) {
@@ -560,6 +915,19 @@ func SoftwareRequestGetAllHandler(c *gin.Context) {
 	ac := c.GetHeader("account")
 	log.Printf("Recevie Software get all request: %v", ac)
 
+	if projectName := c.Query("project"); projectName != "" {
+		list := []*saas.Software{}
+		for _, owner := range allAccounts() {
+			for _, mySoftware := range owner.Software {
+				if mySoftware.Project == projectName {
+					list = append(list, mySoftware)
+				}
+			}
+		}
+		c.JSON(http.StatusOK, list)
+		return
+	}
+
 	myaccount, exists := account.AccountDB.Get(ac)
 	if exists == true {
 		c.JSON(http.StatusOK, myaccount.Software)
@@ -580,7 +948,7 @@ func ContainerRequestWebConsole(c *gin.Context) {
 	}
 
 	tokenString := c.Query("token")
-	err, ac := auth.ValidateToken(tokenString)
+	ac, err := authenticate(tokenString)
 	if err != nil {
 		c.Error(err)
 		return
@@ -589,7 +957,14 @@ func ContainerRequestWebConsole(c *gin.Context) {
 
 	log.Printf("Receive saas web console request: %v,%v", ac, containerName)
 
-	if ac, exists := account.AccountDB.Get(ac); exists == true {
+	owner, _, err := authorizeResource(ac, kindSoftware, containerName, project.PermOperate)
+	if err != nil {
+		conn.WriteMessage(1, []byte(err.Error()))
+		conn.Close()
+		return
+	}
+
+	if ac, exists := account.AccountDB.Get(owner); exists == true {
 		if mySoftware, err := ac.GetSoftwareByName(containerName); err == nil {
 			host := node.GetNodeByName(mySoftware.Node)
 			if host == nil {
@@ -601,8 +976,11 @@ func ContainerRequestWebConsole(c *gin.Context) {
 				return
 			}
 			containerTerminal.Start(conn)*/
-			webTerminal := terminal.NewSSHTerminal("root", host.Passwd, host.IpAddress, 22)
+			webTerminal := terminal.NewSSHTerminalWithKey(host.UserName, host.Passwd, host.LoginKey(), host.IpAddress, 22)
+			webTerminal.Name = "node " + host.Name
 			err = webTerminal.Connect()
+			//host key may be recorded on first contact
+			db.NotifyToSave()
 			if err != nil {
 				conn.WriteMessage(1, []byte(err.Error()))
 				conn.Close()
@@ -624,7 +1002,7 @@ func SoftwareRequestGetByNameHandler(c *gin.Context) {
 	name := c.Param("name")
 	log.Printf("Recevie Software get request: %v, %v", ac, name)
 
-	myaccount, exists := account.AccountDB.Get(ac)
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
 	if exists == true {
 		if mySoftware, err := myaccount.GetSoftwareByName(name); err == nil {
 			c.JSON(http.StatusOK, mySoftware)
@@ -646,6 +1024,67 @@ func WorkflowTaskHandler(c *gin.Context) {
 	c.Writer.WriteString(fmt.Sprintf("taskNumber %v", taskNumber))
 }
 
+// Get tasks, admin can see all tasks, others only see own tasks
+// Query:
+//   status -> filter by task status
+//   kind   -> filter by task kind
+// Return:
+//   200: success with task list
+//   404: fail -> account not found
+func TaskRequestGetAllHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	status := c.Query("status")
+	kind := c.Query("kind")
+	log.Printf("Recevie task get all request: %v, %v, %v", ac, status, kind)
+
+	myaccount, exists := account.AccountDB.Get(ac)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+
+	owner := myaccount.Name
+	if myaccount.Role == account.RoleAdmin {
+		owner = ""
+	}
+	tasks := []*task.Task{}
+	for _, t := range task.TaskDB.List(owner) {
+		t = t.Copy()
+		if (status == "" || string(t.Status) == status) &&
+			(kind == "" || string(t.Kind) == kind) {
+			tasks = append(tasks, t)
+		}
+	}
+
+	c.JSON(http.StatusOK, tasks)
+}
+
+// Get task by id
+// Return:
+//   200: success with task info
+//   404: fail -> account/task not found
+func TaskRequestGetByIdHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	id := c.Param("id")
+	log.Printf("Recevie task get request: %v, %v", ac, id)
+
+	myaccount, exists := account.AccountDB.Get(ac)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+
+	myTask, exists := task.TaskDB.Get(id)
+	if exists == false || (myaccount.Role != account.RoleAdmin && myTask.Account != myaccount.Name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, myTask.Copy())
+}
+
 //metrics handler
 func metricsHandler(c *gin.Context) {
 
@@ -665,6 +1104,11 @@ func oneTimePassGenHandler(c *gin.Context) {
 
 	myaccount, exists := account.AccountDB.Get(accountName)
 	if exists == true {
+		//avoid spamming account with notifications
+		if wait := myaccount.OneTimePassWait(); wait > 0 {
+			tooManyRequests(c, "one-time password just sent", wait)
+			return
+		}
 		myaccount.SetOneTimePass(true)
 		c.JSON(http.StatusOK, nil)
 	} else {
@@ -689,18 +1133,51 @@ func accountLoginHandler(c *gin.Context) {
 		return
 	}
 
+	ip := c.ClientIP()
+	if wait := auth.LoginLockedFor(r.Account, ip); wait > 0 {
+		tooManyRequests(c, "too many failed login attempts", wait)
+		return
+	}
+
+	if r.Provider != "" {
+		provider, exists := identity.GetPasswordProvider(r.Provider)
+		if exists == false {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "identity provider not supported"})
+			return
+		}
+		id, err := provider.Authenticate(r.Account, r.Password)
+		if err != nil {
+			log.Printf("account %v login by %v failed: %v", r.Account, r.Provider, err)
+			auth.LoginFailed(r.Account, ip)
+			c.JSON(http.StatusUnauthorized, nil)
+			return
+		}
+		auth.LoginSucceeded(r.Account, ip)
+		myaccount, err := identity.Provision(r.Provider, id)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		db.NotifyToSave()
+		log.Printf("account %v login by %v successfully", myaccount.Name, r.Provider)
+		c.JSON(http.StatusOK, auth.InvokeToken(myaccount.Name))
+		return
+	}
+
 	myaccount, exists := account.AccountDB.Get(r.Account)
 	if exists == true {
-		if r.Password == myaccount.GetOneTimePass() {
+		//one time password is cleared after a success login, or once expired
+		if myaccount.VerifyOneTimePass(r.Password) {
 			log.Printf("account %v login successfully", r.Account)
-			//Clear one time password after a success login
-			myaccount.SetOneTimePass(false)
+			auth.LoginSucceeded(r.Account, ip)
 			tokenInfo := auth.InvokeToken(r.Account)
 			c.JSON(http.StatusOK, tokenInfo)
 		} else {
+			auth.LoginFailed(r.Account, ip)
 			c.JSON(http.StatusUnauthorized, nil)
 		}
 	} else {
+		auth.LoginFailed(r.Account, ip)
 		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
 	}
 }
@@ -714,6 +1191,7 @@ func AccountRequestGetAllHandler(c *gin.Context) {
 			"name":     ac.Value.Name,
 			"role":     string(ac.Value.Role),
 			"contract": ac.Value.Contract,
+			"provider": ac.Value.Provider,
 		}
 		accountSlice = append(accountSlice, account)
 	}
@@ -730,6 +1208,7 @@ func AccountRequestGetByNameHandler(c *gin.Context) {
 			"name":     ac.Name,
 			"role":     string(ac.Role),
 			"contract": ac.Contract,
+			"provider": ac.Provider,
 		})
 	} else {
 		c.JSON(http.StatusNotFound, nil)
@@ -747,6 +1226,9 @@ func AccountRequestDelByNameHandler(c *gin.Context) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "account still have resouces created"})
 		} else {
 			account.AccountDB.Del(name)
+			auth.DelApiTokensOf(name)
+			project.RemoveAccount(name)
+			quota.QuotaDB.Del(quota.Key(quota.ScopeAccount, name))
 			db.NotifyToSave()
 			c.JSON(http.StatusNoContent, nil)
 		}
@@ -802,3 +1284,1068 @@ var upgrader = websocket.Upgrader{
 func WebTerminalHandler(c *gin.Context) {
 	c.HTML(200, "terminal.html", nil)
 }
+
+//List db backups on remote sftp server
+func BackupRequestGetAllHandler(c *gin.Context) {
+
+	backups, err := db.ListBackups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, backups)
+}
+
+//Restore db from remote backup, in-flight workflows will be reconciled after restore
+func BackupRequestRestoreHandler(c *gin.Context) {
+
+	timeStamp := c.Param("timestamp")
+	log.Printf("Receive db restore request, backup timestamp -> %v", timeStamp)
+
+	if workflow.GetTaskCount() > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "workflow still in progress, please try again later",
+		})
+		return
+	}
+
+	if err := db.RestoreBackup(timeStamp); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	go workflow.Reconcile()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "backup " + timeStamp + " restored",
+	})
+}
+
+//Get all ssh keys in key store, private key not returned
+func SshKeyRequestGetAllHandler(c *gin.Context) {
+
+	keys := []sshKeyView{}
+	for k := range sshkey.KeyDB.Iter() {
+		keys = append(keys, sshKeyView{Key: k.Value})
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+//Upload ssh private key into key store, node can reference it by name
+func SshKeyRequestCreateHandler(c *gin.Context) {
+
+	var r sshkey.KeyRequest
+	if err := c.Bind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive ssh key upload request: %v", r.Name)
+
+	if _, exists := sshkey.KeyDB.Get(r.Name); exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "ssh key already existed"})
+		return
+	}
+	newKey, err := sshkey.NewKey(r)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sshkey.KeyDB.Set(newKey.Name, newKey)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusOK, sshKeyView{Key: newKey})
+}
+
+//Delete ssh key, key still referenced by node can't be deleted
+func SshKeyRequestDelByNameHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	log.Printf("Receive ssh key delete request: %v", name)
+
+	if _, exists := sshkey.KeyDB.Get(name); exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ssh key not found"})
+		return
+	}
+	for n := range node.NodeDB.Iter() {
+		if n.Value.KeyName == name {
+			c.JSON(http.StatusConflict, gin.H{"error": "ssh key still used by node " + n.Value.Name})
+			return
+		}
+	}
+	sshkey.KeyDB.Del(name)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+//Get public keys in key library of caller
+func PublicKeyRequestGetAllHandler(c *gin.Context) {
+
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("account"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, myaccount.GetPublicKeys())
+}
+
+//Add public key into key library of caller, vm request can reference it by name
+func PublicKeyRequestCreateHandler(c *gin.Context) {
+
+	var r account.PublicKeyRequest
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive public key upload request: %v, %v", c.GetHeader("account"), r.Name)
+
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("account"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	newKey, err := myaccount.AddPublicKey(r.Name, r.Key)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+
+	c.JSON(http.StatusCreated, newKey)
+}
+
+//Delete public key from key library of caller, vms created with it keep the key
+func PublicKeyRequestDelByNameHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	log.Printf("Receive public key delete request: %v, %v", c.GetHeader("account"), name)
+
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("account"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	if err := myaccount.DelPublicKey(name); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+//Get all known ssh host keys of nodes, vms and backup target
+func HostKeyRequestGetAllHandler(c *gin.Context) {
+
+	keys := []*hostkey.HostKey{}
+	for k := range hostkey.HostKeyDB.Iter() {
+		keys = append(keys, k.Value)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Address < keys[j].Address
+	})
+
+	c.JSON(http.StatusOK, keys)
+}
+
+//Pin host key fingerprint provided by admin, host presenting other key will be rejected
+func HostKeyRequestCreateHandler(c *gin.Context) {
+
+	var r hostkey.HostKeyRequest
+	if err := c.Bind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive host key pin request: %v, %v", r.Address, r.Fingerprint)
+
+	newKey, err := hostkey.Pin(r)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+
+	c.JSON(http.StatusOK, newKey)
+}
+
+//Reset known host key, e.g. host reinstalled, key will be trusted on next contact
+//Address is host:port, port 22 if not given
+func HostKeyRequestDelByAddressHandler(c *gin.Context) {
+
+	address, err := hostkey.NormalizeAddress(c.Param("address"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive host key reset request: %v", address)
+
+	if _, exists := hostkey.HostKeyDB.Get(address); exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "host key not found"})
+		return
+	}
+	hostkey.HostKeyDB.Del(address)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+//Get all images in catalog, vm request type must be one of them
+func ImageRequestGetAllHandler(c *gin.Context) {
+
+	images := []*image.Image{}
+	for i := range image.ImageDB.Iter() {
+		images = append(images, i.Value)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Name < images[j].Name
+	})
+
+	c.JSON(http.StatusOK, images)
+}
+
+//Register image into catalog, or update existing one, e.g. nodes holding it in cache
+func ImageRequestCreateHandler(c *gin.Context) {
+
+	var r image.ImageRequest
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive image register request: %v", r.Name)
+
+	newImage, err := image.Register(r)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+
+	c.JSON(http.StatusOK, newImage)
+}
+
+//Delete image from catalog, existing vms created from it are not affected
+func ImageRequestDelByNameHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	log.Printf("Receive image delete request: %v", name)
+
+	if _, exists := image.ImageDB.Get(name); exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	}
+	image.ImageDB.Del(name)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+//Get flavors visible to caller, project query parameter narrows visibility as vm request does
+func FlavorRequestGetAllHandler(c *gin.Context) {
+
+	myaccount, exists := account.AccountDB.Get(c.GetHeader("account"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	flavors := []*flavor.Flavor{}
+	for f := range flavor.FlavorDB.Iter() {
+		if f.Value.VisibleTo(string(myaccount.Role), c.Query("project")) {
+			flavors = append(flavors, f.Value)
+		}
+	}
+	sort.Slice(flavors, func(i, j int) bool {
+		return flavors[i].Name < flavors[j].Name
+	})
+
+	c.JSON(http.StatusOK, flavors)
+}
+
+//Get flavor by name, admin only
+func FlavorRequestGetByNameHandler(c *gin.Context) {
+
+	myFlavor, exists := flavor.FlavorDB.Get(c.Param("name"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "flavor not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, myFlavor)
+}
+
+//Create flavor, existing vms are not affected by later flavor changes
+func FlavorRequestCreateHandler(c *gin.Context) {
+
+	var r flavor.FlavorRequest
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive flavor create request: %+v", r)
+
+	if _, exists := flavor.FlavorDB.Get(r.Name); exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "flavor already existed"})
+		return
+	}
+	newFlavor, err := flavor.NewFlavor(r)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	flavor.FlavorDB.Set(newFlavor.Name, newFlavor)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusCreated, newFlavor)
+}
+
+//Replace flavor of name in path, name in body must be same if given
+func FlavorRequestUpdateHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	var r flavor.FlavorRequest
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if r.Name == "" {
+		r.Name = name
+	} else if r.Name != name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "flavor name can't be changed"})
+		return
+	}
+	log.Printf("Receive flavor update request: %+v", r)
+
+	oldFlavor, exists := flavor.FlavorDB.Get(name)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "flavor not found"})
+		return
+	}
+	newFlavor, err := flavor.NewFlavor(r)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	newFlavor.CreatedAt = oldFlavor.CreatedAt
+	flavor.FlavorDB.Set(newFlavor.Name, newFlavor)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusOK, newFlavor)
+}
+
+//Delete flavor, existing vms created from it are not affected
+func FlavorRequestDelByNameHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	log.Printf("Receive flavor delete request: %v", name)
+
+	if _, exists := flavor.FlavorDB.Get(name); exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "flavor not found"})
+		return
+	}
+	if dependents := workflow.FlavorDependents(name); len(dependents) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("flavor %v used by %v", name, strings.Join(dependents, ", "))})
+		return
+	}
+	flavor.FlavorDB.Del(name)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+//Get all blueprints, every account can deploy any blueprint
+func BlueprintRequestGetAllHandler(c *gin.Context) {
+
+	blueprints := []*blueprint.Blueprint{}
+	for b := range blueprint.BlueprintDB.Iter() {
+		blueprints = append(blueprints, b.Value)
+	}
+	sort.Slice(blueprints, func(i, j int) bool {
+		return blueprints[i].Name < blueprints[j].Name
+	})
+
+	c.JSON(http.StatusOK, blueprints)
+}
+
+func BlueprintRequestGetByNameHandler(c *gin.Context) {
+
+	myBlueprint, exists := blueprint.BlueprintDB.Get(c.Param("name"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "blueprint not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, myBlueprint)
+}
+
+//Create blueprint from json or yaml body, caller becomes owner
+func BlueprintRequestCreateHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	var r blueprint.BlueprintRequest
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive blueprint create request: %v, %v", ac, r.Name)
+
+	if _, exists := blueprint.BlueprintDB.Get(r.Name); exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "blueprint already existed"})
+		return
+	}
+	newBlueprint, err := blueprint.NewBlueprint(r, ac)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	blueprint.BlueprintDB.Set(newBlueprint.Name, newBlueprint)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusCreated, newBlueprint)
+}
+
+//Replace blueprint of name in path, owner or admin only
+//Environments already deployed are not affected
+func BlueprintRequestUpdateHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	name := c.Param("name")
+	var r blueprint.BlueprintRequest
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if r.Name == "" {
+		r.Name = name
+	} else if r.Name != name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "blueprint name can't be changed"})
+		return
+	}
+	log.Printf("Receive blueprint update request: %v, %v", ac, name)
+
+	oldBlueprint, exists := blueprint.BlueprintDB.Get(name)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "blueprint not found"})
+		return
+	}
+	if oldBlueprint.Owner != ac && isAdmin(ac) == false {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only owner of blueprint can update it"})
+		return
+	}
+	newBlueprint, err := blueprint.NewBlueprint(r, oldBlueprint.Owner)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	newBlueprint.CreatedAt = oldBlueprint.CreatedAt
+	blueprint.BlueprintDB.Set(newBlueprint.Name, newBlueprint)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusOK, newBlueprint)
+}
+
+//Delete blueprint, owner or admin only, environments deployed from it are kept
+func BlueprintRequestDelByNameHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	name := c.Param("name")
+	log.Printf("Receive blueprint delete request: %v, %v", ac, name)
+
+	myBlueprint, exists := blueprint.BlueprintDB.Get(name)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "blueprint not found"})
+		return
+	}
+	if myBlueprint.Owner != ac && isAdmin(ac) == false {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only owner of blueprint can delete it"})
+		return
+	}
+	blueprint.BlueprintDB.Del(name)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// Deploy blueprint as environment of caller, project query parameter places all members into project
+// Return:
+//   200: deployment accepted, with environment name and task id
+//   400: fail -> request invalid or not satisfied by image/flavor catalog
+//   403: fail -> quota exceeded or no permission on project
+//   404: fail -> account/blueprint/project not found
+func BlueprintRequestDeployHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	name := c.Param("name")
+	var r blueprint.DeployRequest
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r.Project = c.Query("project")
+	log.Printf("Receive blueprint deploy request: %v, %v, %v", ac, name, r.Duration)
+
+	if r.Project != "" {
+		if status, err := authorizeProject(ac, r.Project, project.PermManage); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	myBlueprint, exists := blueprint.BlueprintDB.Get(name)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "blueprint not found"})
+		return
+	}
+	myAccount, exists := account.AccountDB.Get(ac)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+
+	myEnv, myTask, err := workflow.DeployBlueprint(myAccount, myBlueprint, r)
+	if isQuotaExceeded(err) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if isCatalogInvalid(err) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Blueprint deployment request accepted",
+		"environment": myEnv.Name,
+		"task":        myTask.Id,
+	})
+}
+
+// Get all environments, project query parameter lists environments of project
+// Return:
+//   200: success with environment info
+//   404: fail -> account not found
+func EnvironmentRequestGetAllHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	log.Printf("Receive environment get all request: %v", ac)
+
+	list := []environmentView{}
+	if projectName := c.Query("project"); projectName != "" {
+		for _, owner := range allAccounts() {
+			for myEnv := range owner.IterEnvironment() {
+				if myEnv.Project == projectName {
+					list = append(list, newEnvironmentView(myEnv, owner))
+				}
+			}
+		}
+		c.JSON(http.StatusOK, list)
+		return
+	}
+
+	myAccount, exists := account.AccountDB.Get(ac)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	for myEnv := range myAccount.IterEnvironment() {
+		list = append(list, newEnvironmentView(myEnv, myAccount))
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func EnvironmentRequestGetByNameHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	myAccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	myEnv, err := myAccount.GetEnvironmentByName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "environment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newEnvironmentView(myEnv, myAccount))
+}
+
+// Tear down environment, all members deleted together
+// Return:
+//   200: success
+//   404: fail -> account/environment not found
+//   409: fail -> environment still deploying
+//   500: fail -> some members can't be deleted, environment kept in failed status
+func EnvironmentRequestDeleteHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	name := c.Param("name")
+	log.Printf("Receive environment delete request: %v, %v", ac, name)
+
+	myAccount, exists := account.AccountDB.Get(c.GetHeader("owner"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	myEnv, err := myAccount.GetEnvironmentByName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "environment not found"})
+		return
+	}
+	if myEnv.GetStatus() == blueprint.EnvironmentStatusDeploying {
+		c.JSON(http.StatusConflict, gin.H{"error": "environment still deploying"})
+		return
+	}
+	if err := workflow.DeleteEnvironment(myAccount, name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+//Get projects, admin sees all, others see projects they are member of
+func ProjectRequestGetAllHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	projects := []*project.Project{}
+	for p := range project.ProjectDB.Iter() {
+		if isAdmin(ac) || p.Value.RoleOf(ac) != "" {
+			projects = append(projects, p.Value)
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].Name < projects[j].Name
+	})
+
+	c.JSON(http.StatusOK, projects)
+}
+
+//Get project by name, members only
+func ProjectRequestGetByNameHandler(c *gin.Context) {
+
+	myProject, exists := project.ProjectDB.Get(c.Param("name"))
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, myProject)
+}
+
+//Create project, creator becomes owner
+func ProjectRequestCreateHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	var r project.ProjectRequest
+	if err := c.Bind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive project create request: %v, %v", ac, r.Name)
+
+	newProject, err := project.ProjectDB.Add(r, ac)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+
+	c.JSON(http.StatusOK, newProject)
+}
+
+//Delete project, project still owning resources can't be deleted
+func ProjectRequestDelByNameHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	log.Printf("Receive project delete request: %v, %v", c.GetHeader("account"), name)
+
+	for _, ac := range allAccounts() {
+		for _, myVm := range ac.VM {
+			if myVm.Project == name {
+				c.JSON(http.StatusForbidden, gin.H{"error": "project still have resources created"})
+				return
+			}
+		}
+		for _, myK8s := range ac.K8S {
+			if myK8s.Project == name {
+				c.JSON(http.StatusForbidden, gin.H{"error": "project still have resources created"})
+				return
+			}
+		}
+		for _, mySoftware := range ac.Software {
+			if mySoftware.Project == name {
+				c.JSON(http.StatusForbidden, gin.H{"error": "project still have resources created"})
+				return
+			}
+		}
+	}
+	project.ProjectDB.Del(name)
+	quota.QuotaDB.Del(quota.Key(quota.ScopeProject, name))
+	db.NotifyToSave()
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+//Add project member or change member role
+func ProjectRequestSetMemberHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	member := c.Param("account")
+	var r project.MemberRequest
+	if err := c.Bind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive project member request: %v, %v, %v", name, member, r.Role)
+
+	if _, exists := account.AccountDB.Get(member); exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	myProject, exists := project.ProjectDB.Get(name)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	if err := myProject.SetMember(member, r.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+
+	c.JSON(http.StatusOK, myProject)
+}
+
+//Remove project member
+func ProjectRequestDelMemberHandler(c *gin.Context) {
+
+	name := c.Param("name")
+	member := c.Param("account")
+	log.Printf("Receive project member remove request: %v, %v", name, member)
+
+	myProject, exists := project.ProjectDB.Get(name)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+	if err := myProject.RemoveMember(member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+//Get all quotas with current usage
+func QuotaRequestGetAllHandler(c *gin.Context) {
+
+	quotas := []*quota.Quota{}
+	for q := range quota.QuotaDB.Iter() {
+		quotas = append(quotas, q.Value)
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		return quota.Key(quotas[i].Scope, quotas[i].Name) < quota.Key(quotas[j].Scope, quotas[j].Name)
+	})
+
+	usages := []quota.Usage{}
+	for _, q := range quotas {
+		usages = append(usages, quota.GetUsage(q.Scope, q.Name))
+	}
+
+	c.JSON(http.StatusOK, usages)
+}
+
+//Get quota and current usage of account or project
+func QuotaRequestGetByNameHandler(c *gin.Context) {
+
+	scope := quota.Scope(c.Param("scope"))
+	name := c.Param("name")
+	if status, err := quotaTargetExists(scope, name); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, quota.GetUsage(scope, name))
+}
+
+//Set quota of account or project, 0 means unlimited
+func QuotaRequestSetHandler(c *gin.Context) {
+
+	scope := quota.Scope(c.Param("scope"))
+	name := c.Param("name")
+	var r quota.Resources
+	if err := c.Bind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Receive quota set request: %v, %v, %+v", scope, name, r)
+
+	if status, err := quotaTargetExists(scope, name); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := quota.SetQuota(scope, name, r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+
+	c.JSON(http.StatusOK, quota.GetUsage(scope, name))
+}
+
+//Delete quota of account or project, resources become unlimited
+func QuotaRequestDelHandler(c *gin.Context) {
+
+	key := quota.Key(quota.Scope(c.Param("scope")), c.Param("name"))
+	log.Printf("Receive quota delete request: %v", key)
+
+	if _, exists := quota.QuotaDB.Get(key); exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "quota not found"})
+		return
+	}
+	quota.QuotaDB.Del(key)
+	db.NotifyToSave()
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+//Get quota and current usage of caller, or of project if project query parameter given
+func UsageRequestGetHandler(c *gin.Context) {
+
+	ac := c.GetHeader("account")
+	if projectName := c.Query("project"); projectName != "" {
+		if status, err := authorizeProject(ac, projectName, project.PermRead); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, quota.GetUsage(quota.ScopeProject, projectName))
+		return
+	}
+
+	c.JSON(http.StatusOK, quota.GetUsage(quota.ScopeAccount, ac))
+}
+
+//Get identity providers enabled besides one-time password
+func IdentityProviderGetAllHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, identity.GetProviders())
+}
+
+//Cookie keeping oidc state between login redirect and callback
+const ssoStateCookie = "devlab_sso_state"
+
+//Redirect browser to login page of identity provider, state is kept in cookie to bind callback to this browser
+func SsoLoginHandler(c *gin.Context) {
+
+	name := c.Param("provider")
+	provider, exists := identity.GetRedirectProvider(name)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "identity provider not supported"})
+		return
+	}
+
+	state := identity.NewState()
+	target, err := provider.AuthCodeURL(state)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     "/sso/" + name,
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	c.Redirect(http.StatusFound, target)
+}
+
+//Callback from identity provider, account provisioned on first login
+//Browser gets a page saving token and going back to index, others get token in json
+func SsoCallbackHandler(c *gin.Context) {
+
+	name := c.Param("provider")
+	provider, exists := identity.GetRedirectProvider(name)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "identity provider not supported"})
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": e + " " + c.Query("error_description")})
+		return
+	}
+
+	state, err := c.Cookie(ssoStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login state not match, please retry"})
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{Name: ssoStateCookie, Path: "/sso/" + name, MaxAge: -1})
+
+	id, err := provider.Exchange(c.Query("code"), state)
+	if err != nil {
+		log.Printf("sso login by %v failed: %v", name, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	myaccount, err := identity.Provision(name, id)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+	log.Printf("account %v login by %v successfully", myaccount.Name, name)
+
+	tokenInfo := auth.InvokeToken(myaccount.Name)
+	switch c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) {
+	case gin.MIMEHTML:
+		c.HTML(http.StatusOK, "sso.html", tokenInfo)
+	default:
+		c.JSON(http.StatusOK, tokenInfo)
+	}
+}
+
+//Query audit log, filtered by actor, action, kind, target, owner, result, since and until(RFC3339)
+func AuditRequestGetHandler(c *gin.Context) {
+	var f audit.Filter
+	if err := c.ShouldBindQuery(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	records, err := audit.Query(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, records)
+}
+
+//Exchange refresh token for new access token and refresh token, refresh token can only be used once
+func TokenRefreshHandler(c *gin.Context) {
+	var r auth.RefreshRequest
+	if err := c.Bind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := auth.UseRefreshToken(r.RefreshToken)
+	if err == nil {
+		err = checkTokenAccount(claims)
+	}
+	if err != nil {
+		log.Printf("token refresh failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+
+	c.JSON(http.StatusOK, auth.InvokeToken(claims.Name))
+}
+
+//Revoke access token of current request, and refresh token if given
+func LogoutHandler(c *gin.Context) {
+	//request body is optional
+	var r auth.LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.Bind(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	caller := c.GetHeader("account")
+	if r.RefreshToken != "" {
+		claims, err := auth.ParseToken(r.RefreshToken)
+		if err != nil || claims.Type != auth.TokenTypeRefresh || claims.Name != caller {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "refresh token not valid"})
+			return
+		}
+		auth.RevocationDB.Revoke(claims)
+	}
+	tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	//api token is not affected, it's revoked by DELETE /token/:id
+	if claims, err := auth.ParseToken(tokenString); err == nil && claims.Type == auth.TokenTypeAccess {
+		auth.RevocationDB.Revoke(claims)
+	}
+	db.NotifyToSave()
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+//Get api tokens of caller, token string not included
+func ApiTokenRequestGetAllHandler(c *gin.Context) {
+
+	c.JSON(http.StatusOK, auth.ApiTokensOf(c.GetHeader("account")))
+}
+
+//Create named api token for caller, token string is only returned here
+func ApiTokenRequestCreateHandler(c *gin.Context) {
+	var r auth.ApiTokenRequest
+	if err := c.Bind(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	apiToken, token, err := auth.NewApiToken(c.GetHeader("account"), r)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	db.NotifyToSave()
+	log.Printf("Api token %v(%v) of account %v created", apiToken.Name, apiToken.Id, apiToken.Account)
+
+	c.JSON(http.StatusCreated, gin.H{"token": token, "apiToken": apiToken})
+}
+
+//Revoke api token, only owner or admin allowed
+func ApiTokenRequestDelHandler(c *gin.Context) {
+
+	id := c.Param("id")
+	caller := c.GetHeader("account")
+	apiToken, exists := auth.ApiTokenDB.Get(id)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "api token not found"})
+		return
+	}
+	if apiToken.Account != caller {
+		if ac, _ := account.AccountDB.Get(caller); ac == nil || ac.Role != account.RoleAdmin {
+			c.JSON(http.StatusNotFound, gin.H{"error": "api token not found"})
+			return
+		}
+	}
+	auth.ApiTokenDB.Del(id)
+	db.NotifyToSave()
+	log.Printf("Api token %v(%v) of account %v revoked by %v", apiToken.Name, apiToken.Id, apiToken.Account, caller)
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+//Revoke all tokens of caller, including api tokens
+func TokenRevokeAllHandler(c *gin.Context) {
+
+	caller := c.GetHeader("account")
+	ac, exists := account.AccountDB.Get(caller)
+	if exists == false {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	ac.RevokeTokens()
+	auth.DelApiTokensOf(caller)
+	db.NotifyToSave()
+	log.Printf("All tokens of account %v revoked", caller)
+
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/JinlongWukong/DevLab/account"
@@ -17,9 +19,11 @@ func AuthorizeToken() gin.HandlerFunc {
 			return
 		}
 		tokenString := authHeader[len(BEARER_SCHEMA):]
-		err, name := auth.ValidateToken(tokenString)
+		name, err := authenticate(tokenString)
 		if err == nil {
-			c.Request.Header.Add("account", name)
+			//overwrite identity headers which may be forged by client
+			c.Request.Header.Set("account", name)
+			c.Request.Header.Del("owner")
 			c.Next()
 		} else {
 			c.AbortWithStatus(http.StatusUnauthorized)
@@ -27,6 +31,38 @@ func AuthorizeToken() gin.HandlerFunc {
 	}
 }
 
+//Verify token used by api call and its account
+//Tokens issued before account revoked all its tokens are rejected
+func authenticate(tokenString string) (string, error) {
+
+	claims, err := auth.ParseToken(tokenString)
+	if err == nil && claims.Type == auth.TokenTypeRefresh {
+		err = fmt.Errorf("refresh token can't be used for api call")
+	}
+	if err == nil {
+		err = checkTokenAccount(claims)
+	}
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+
+	return claims.Name, nil
+}
+
+func checkTokenAccount(claims *auth.CustomClaims) error {
+
+	ac, exists := account.AccountDB.Get(claims.Name)
+	if exists == false {
+		return fmt.Errorf("account %v of token not found", claims.Name)
+	}
+	if claims.IssuedAt < ac.GetTokensNotBefore().Unix() {
+		return fmt.Errorf("token %v of account %v revoked", claims.Id, claims.Name)
+	}
+
+	return nil
+}
+
 //Only account with admin role allowed
 func AdminRoleOnlyAllowed() gin.HandlerFunc {
 	return func(c *gin.Context) {
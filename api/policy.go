@@ -0,0 +1,222 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/flavor"
+	"github.com/JinlongWukong/DevLab/image"
+	"github.com/JinlongWukong/DevLab/project"
+	"github.com/JinlongWukong/DevLab/quota"
+	"github.com/gin-gonic/gin"
+)
+
+//Resource kinds guarded by policy check
+const (
+	kindVm          = "vm"
+	kindK8s         = "k8s"
+	kindSoftware    = "saas"
+	kindEnvironment = "environment"
+)
+
+//Permission required by resource action
+//  delete/extend/resize/clone/snapshot/revert -> manage
+//  get(refresh)                               -> read
+//  others                                     -> operate, e.g. start/shutdown/reboot
+func actionPermission(action string) project.Permission {
+
+	switch action {
+	case "delete", "extend", "resize", "clone", "snapshot", "revert":
+		return project.PermManage
+	case "get":
+		return project.PermRead
+	default:
+		return project.PermOperate
+	}
+}
+
+//Find project of resource in account
+func resourceProject(ac *account.Account, kind, name string) (string, bool) {
+
+	switch kind {
+	case kindVm:
+		if myVm, err := ac.GetVmByName(name); err == nil {
+			return myVm.Project, true
+		}
+	case kindK8s:
+		if myK8s, err := ac.GetK8sByName(name); err == nil {
+			return myK8s.Project, true
+		}
+	case kindSoftware:
+		if mySoftware, err := ac.GetSoftwareByName(name); err == nil {
+			return mySoftware.Project, true
+		}
+	case kindEnvironment:
+		if myEnv, err := ac.GetEnvironmentByName(name); err == nil {
+			return myEnv.Project, true
+		}
+	}
+
+	return "", false
+}
+
+//Snapshot of all accounts, project resources may belong to any of them
+func allAccounts() []*account.Account {
+
+	accounts := []*account.Account{}
+	for ac := range account.AccountDB.Iter() {
+		accounts = append(accounts, ac.Value)
+	}
+
+	return accounts
+}
+
+//Locate resource by name, caller's own resource preferred
+//Only project resources of other accounts are visible, admin sees all
+//Return owner account and project of resource, owner is empty if not found
+func locateResource(caller, kind, name string) (owner, projectName string) {
+
+	if ac, exists := account.AccountDB.Get(caller); exists {
+		if p, found := resourceProject(ac, kind, name); found {
+			return ac.Name, p
+		}
+	}
+
+	admin := isAdmin(caller)
+	for _, ac := range allAccounts() {
+		if p, found := resourceProject(ac, kind, name); found && (p != "" || admin) {
+			return ac.Name, p
+		}
+	}
+
+	return "", ""
+}
+
+//Policy decision of caller on project
+//  admin        -> everything allowed
+//  project role -> permissions granted by role
+func authorizeProject(caller, projectName string, perm project.Permission) (int, error) {
+
+	myProject, exists := project.ProjectDB.Get(projectName)
+	if exists == false {
+		return http.StatusNotFound, fmt.Errorf("project %v not found", projectName)
+	}
+	if isAdmin(caller) || myProject.Allowed(caller, perm) {
+		return http.StatusOK, nil
+	}
+	if myProject.RoleOf(caller) == "" {
+		return http.StatusNotFound, fmt.Errorf("project %v not found", projectName)
+	}
+
+	return http.StatusForbidden, fmt.Errorf("role %v of project %v has no %v permission", myProject.RoleOf(caller), projectName, perm)
+}
+
+//Policy decision of caller on resource
+//  admin        -> everything allowed
+//  owner        -> everything allowed on own resources
+//  project role -> permissions granted by role in project which resource belongs to
+//Resource is reported as not found if caller can't even read it
+func authorizeResource(caller, kind, name string, perm project.Permission) (owner string, status int, err error) {
+
+	owner, projectName := locateResource(caller, kind, name)
+	if owner == "" {
+		return "", http.StatusNotFound, fmt.Errorf("%v %v not found", kind, name)
+	}
+	if owner == caller || isAdmin(caller) {
+		return owner, http.StatusOK, nil
+	}
+	if projectName == "" {
+		return "", http.StatusNotFound, fmt.Errorf("%v %v not found", kind, name)
+	}
+	if status, err := authorizeProject(caller, projectName, perm); err != nil {
+		if status == http.StatusNotFound {
+			err = fmt.Errorf("%v %v not found", kind, name)
+		}
+		return "", status, err
+	}
+
+	return owner, http.StatusOK, nil
+}
+
+//Policy check of vm/k8s/saas/environment routes
+//Resource is located by :name param, its owner account is passed to handler by "owner" header
+//Routes without :name(list/create) are checked against project query parameter if given
+//Args:
+//  perm -> permission required, empty means derived from :action param
+func ResourceAllowed(kind string, perm project.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller := c.GetHeader("account")
+		required := perm
+		if required == "" {
+			required = actionPermission(c.Param("action"))
+		}
+
+		name := c.Param("name")
+		if name == "" {
+			if projectName := c.Query("project"); projectName != "" {
+				if status, err := authorizeProject(caller, projectName, required); err != nil {
+					c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			c.Request.Header.Set("owner", caller)
+			c.Next()
+			return
+		}
+
+		owner, status, err := authorizeResource(caller, kind, name, required)
+		if err != nil {
+			c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Header.Set("owner", owner)
+		c.Next()
+	}
+}
+
+//Policy check of project routes, project located by :name param
+func ProjectAllowed(perm project.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if status, err := authorizeProject(c.GetHeader("account"), c.Param("name"), perm); err != nil {
+			c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+//Check whether resource creation rejected by quota, answered with 403
+func isQuotaExceeded(err error) bool {
+
+	var exceeded *quota.ExceededError
+	return errors.As(err, &exceeded)
+}
+
+//Check whether error is caused by vm request not satisfied by image or flavor catalog
+func isCatalogInvalid(err error) bool {
+
+	var invalidImage *image.InvalidError
+	var invalidFlavor *flavor.InvalidError
+	return errors.As(err, &invalidImage) || errors.As(err, &invalidFlavor)
+}
+
+//Check whether account or project which quota applies to exists
+func quotaTargetExists(scope quota.Scope, name string) (int, error) {
+
+	switch scope {
+	case quota.ScopeAccount:
+		if _, exists := account.AccountDB.Get(name); exists == false {
+			return http.StatusNotFound, fmt.Errorf("account %v not found", name)
+		}
+	case quota.ScopeProject:
+		if _, exists := project.ProjectDB.Get(name); exists == false {
+			return http.StatusNotFound, fmt.Errorf("project %v not found", name)
+		}
+	default:
+		return http.StatusBadRequest, fmt.Errorf("quota scope %v not valid, must be account or project", scope)
+	}
+
+	return http.StatusOK, nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//Token bucket rate limiter per client ip
+type rateLimiter struct {
+	rate      float64 //tokens added per second
+	burst     float64
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	lock      sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+//Take one token of key, return time to wait if bucket is empty
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	//buckets idle long enough are full again, no need to keep them
+	if now.Sub(l.lastPrune) > time.Minute {
+		full := time.Duration(l.burst / l.rate * float64(time.Second))
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, exists := l.buckets[key]
+	if exists == false {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+//Response 429 with Retry-After header
+func tooManyRequests(c *gin.Context, reason string, wait time.Duration) {
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("%v, retry after %vs", reason, seconds)})
+}
+
+//Reject request with 429 if client ip exceeds rate, nil limiter means unlimited
+func RateLimit(l *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			c.Next()
+			return
+		}
+		if ok, wait := l.allow(c.ClientIP()); ok == false {
+			tooManyRequests(c, "too many requests", wait)
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,14 +1,23 @@
 package api
 
 import (
+	"net/http"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+
+	"github.com/JinlongWukong/DevLab/config"
+	"github.com/JinlongWukong/DevLab/identity"
+	"github.com/JinlongWukong/DevLab/project"
 )
 
 func setupRouter() *gin.Engine {
 
 	r := gin.Default()
+	r.ForwardedByClientIP = config.ApiServer.TrustForwardedFor
 	r.Use(cors.Default())
+	r.Use(RateLimit(apiLimiter))
+	r.Use(AuditLog())
 
 	//static files
 	r.Static("/css", "views/css")
@@ -28,6 +37,8 @@ func setupRouter() *gin.Engine {
 
 	//workflow related api
 	r.GET("/task", WorkflowTaskHandler)
+	r.GET("/tasks", AuthorizeToken(), TaskRequestGetAllHandler)
+	r.GET("/tasks/:id", AuthorizeToken(), TaskRequestGetByIdHandler)
 
 	//home page
 	r.GET("/", IndexHandler)
@@ -44,6 +55,41 @@ func setupRouter() *gin.Engine {
 	r.POST("/node", AuthorizeToken(), AdminRoleOnlyAllowed(), NodeRequestCreateHandler)
 	r.POST("/node/:name/:action", AuthorizeToken(), AdminRoleOnlyAllowed(), NodeRequestActionHandler)
 
+	//ssh key store(node login private keys) related api
+	r.GET("/sshkey", AuthorizeToken(), AdminRoleOnlyAllowed(), SshKeyRequestGetAllHandler)
+	r.POST("/sshkey", AuthorizeToken(), AdminRoleOnlyAllowed(), SshKeyRequestCreateHandler)
+	r.DELETE("/sshkey/:name", AuthorizeToken(), AdminRoleOnlyAllowed(), SshKeyRequestDelByNameHandler)
+
+	//ssh public key library of account, keys injected into vm by name
+	r.GET("/publickey", AuthorizeToken(), PublicKeyRequestGetAllHandler)
+	r.POST("/publickey", AuthorizeToken(), PublicKeyRequestCreateHandler)
+	r.DELETE("/publickey/:name", AuthorizeToken(), PublicKeyRequestDelByNameHandler)
+
+	//known ssh host keys related api
+	r.GET("/hostkey", AuthorizeToken(), AdminRoleOnlyAllowed(), HostKeyRequestGetAllHandler)
+	r.POST("/hostkey", AuthorizeToken(), AdminRoleOnlyAllowed(), HostKeyRequestCreateHandler)
+	r.DELETE("/hostkey/:address", AuthorizeToken(), AdminRoleOnlyAllowed(), HostKeyRequestDelByAddressHandler)
+
+	//vm image catalog related api
+	r.GET("/images", AuthorizeToken(), ImageRequestGetAllHandler)
+	r.POST("/images", AuthorizeToken(), AdminRoleOnlyAllowed(), ImageRequestCreateHandler)
+	r.DELETE("/images/:name", AuthorizeToken(), AdminRoleOnlyAllowed(), ImageRequestDelByNameHandler)
+
+	//vm flavor catalog related api
+	r.GET("/flavors", AuthorizeToken(), FlavorRequestGetAllHandler)
+	r.GET("/flavors/:name", AuthorizeToken(), AdminRoleOnlyAllowed(), FlavorRequestGetByNameHandler)
+	r.POST("/flavors", AuthorizeToken(), AdminRoleOnlyAllowed(), FlavorRequestCreateHandler)
+	r.PUT("/flavors/:name", AuthorizeToken(), AdminRoleOnlyAllowed(), FlavorRequestUpdateHandler)
+	r.DELETE("/flavors/:name", AuthorizeToken(), AdminRoleOnlyAllowed(), FlavorRequestDelByNameHandler)
+
+	//blueprint related api, environment deployed from blueprint
+	r.GET("/blueprint", AuthorizeToken(), BlueprintRequestGetAllHandler)
+	r.GET("/blueprint/:name", AuthorizeToken(), BlueprintRequestGetByNameHandler)
+	r.POST("/blueprint", AuthorizeToken(), BlueprintRequestCreateHandler)
+	r.PUT("/blueprint/:name", AuthorizeToken(), BlueprintRequestUpdateHandler)
+	r.DELETE("/blueprint/:name", AuthorizeToken(), BlueprintRequestDelByNameHandler)
+	r.POST("/blueprint/:name/deploy", AuthorizeToken(), BlueprintRequestDeployHandler)
+
 	//account related api
 	r.POST("/account", AuthorizeToken(), AdminRoleOnlyAllowed(), AccountRequestCreateHandler)
 	r.GET("/account", AuthorizeToken(), AdminRoleOnlyAllowed(), AccountRequestGetAllHandler)
@@ -51,36 +97,78 @@ func setupRouter() *gin.Engine {
 	r.PATCH("/account/:name", AuthorizeToken(), AdminRoleOnlyAllowed(), AccountRequestModifyHandler)
 	r.DELETE("/account/:name", AuthorizeToken(), AdminRoleOnlyAllowed(), AccountRequestDelByNameHandler)
 
+	//project related api
+	r.GET("/project", AuthorizeToken(), ProjectRequestGetAllHandler)
+	r.POST("/project", AuthorizeToken(), ProjectRequestCreateHandler)
+	r.GET("/project/:name", AuthorizeToken(), ProjectAllowed(project.PermRead), ProjectRequestGetByNameHandler)
+	r.DELETE("/project/:name", AuthorizeToken(), ProjectAllowed(project.PermAdmin), ProjectRequestDelByNameHandler)
+	r.PUT("/project/:name/member/:account", AuthorizeToken(), ProjectAllowed(project.PermAdmin), ProjectRequestSetMemberHandler)
+	r.DELETE("/project/:name/member/:account", AuthorizeToken(), ProjectAllowed(project.PermAdmin), ProjectRequestDelMemberHandler)
+
+	//quota related api
+	r.GET("/quota", AuthorizeToken(), AdminRoleOnlyAllowed(), QuotaRequestGetAllHandler)
+	r.GET("/quota/:scope/:name", AuthorizeToken(), AdminRoleOnlyAllowed(), QuotaRequestGetByNameHandler)
+	r.PUT("/quota/:scope/:name", AuthorizeToken(), AdminRoleOnlyAllowed(), QuotaRequestSetHandler)
+	r.DELETE("/quota/:scope/:name", AuthorizeToken(), AdminRoleOnlyAllowed(), QuotaRequestDelHandler)
+	r.GET("/usage", AuthorizeToken(), UsageRequestGetHandler)
+
+	//audit log related api
+	r.GET("/audit", AuthorizeToken(), AdminRoleOnlyAllowed(), AuditRequestGetHandler)
+
+	//db backup related api
+	r.GET("/backup", AuthorizeToken(), AdminRoleOnlyAllowed(), BackupRequestGetAllHandler)
+	r.POST("/backup/:timestamp/restore", AuthorizeToken(), AdminRoleOnlyAllowed(), BackupRequestRestoreHandler)
+
 	//vm related api
 	r.GET("/vm-request", VmRequestIndexHandler)
-	r.GET("/vm", AuthorizeToken(), VmRequestGetAllHandler)
-	r.GET("/vm/:name", AuthorizeToken(), VmRequestGetByNameHandler)
-	r.POST("/vm", AuthorizeToken(), VmRequestCreateHandler)
-	r.POST("/vm/:name/:action", AuthorizeToken(), VmRequestActionHandler)
-	r.POST("/vm/:name/port/expose", AuthorizeToken(), VmRequestPortExposeHandler)
+	r.GET("/vm", AuthorizeToken(), ResourceAllowed(kindVm, project.PermRead), VmRequestGetAllHandler)
+	r.GET("/vm/:name", AuthorizeToken(), ResourceAllowed(kindVm, project.PermRead), VmRequestGetByNameHandler)
+	r.POST("/vm", AuthorizeToken(), ResourceAllowed(kindVm, project.PermManage), VmRequestCreateHandler)
+	r.POST("/vm/:name/:action", AuthorizeToken(), ResourceAllowed(kindVm, ""), VmRequestActionHandler)
+	r.POST("/vm/:name/port/expose", AuthorizeToken(), ResourceAllowed(kindVm, project.PermManage), VmRequestPortExposeHandler)
+	r.GET("/vm/:name/snapshots", AuthorizeToken(), ResourceAllowed(kindVm, project.PermRead), VmRequestSnapshotGetAllHandler)
+	r.DELETE("/vm/:name/snapshot/:snapshot", AuthorizeToken(), ResourceAllowed(kindVm, project.PermManage), VmRequestSnapshotActionHandler)
 	r.GET("/vm/:name/ws", VmRequestWebConsole)
 	r.GET("/vm/:name/web-terminal", WebTerminalHandler)
 
 	//k8s related api
 	r.GET("/k8s-request", K8sRequestIndexHandler)
-	r.POST("/k8s", AuthorizeToken(), K8sRequestCreateHandler)
-	r.DELETE("/k8s/:name", AuthorizeToken(), K8sRequestDeleteHandler)
-	r.GET("/k8s", AuthorizeToken(), K8sRequestGetAllHandler)
-	r.GET("/k8s/:name", AuthorizeToken(), K8sRequestGetByNameHandler)
+	r.POST("/k8s", AuthorizeToken(), ResourceAllowed(kindK8s, project.PermManage), K8sRequestCreateHandler)
+	r.DELETE("/k8s/:name", AuthorizeToken(), ResourceAllowed(kindK8s, project.PermManage), K8sRequestDeleteHandler)
+	r.GET("/k8s", AuthorizeToken(), ResourceAllowed(kindK8s, project.PermRead), K8sRequestGetAllHandler)
+	r.GET("/k8s/:name", AuthorizeToken(), ResourceAllowed(kindK8s, project.PermRead), K8sRequestGetByNameHandler)
+
+	//environment related api
+	r.GET("/environment", AuthorizeToken(), ResourceAllowed(kindEnvironment, project.PermRead), EnvironmentRequestGetAllHandler)
+	r.GET("/environment/:name", AuthorizeToken(), ResourceAllowed(kindEnvironment, project.PermRead), EnvironmentRequestGetByNameHandler)
+	r.DELETE("/environment/:name", AuthorizeToken(), ResourceAllowed(kindEnvironment, project.PermManage), EnvironmentRequestDeleteHandler)
 
 	//SaaS related api
 	r.GET("/saas-request", SoftwareIndexHandler)
 	r.GET("/saas/supported", SoftwareSupportedListHandler)
-	r.GET("/saas", AuthorizeToken(), SoftwareRequestGetAllHandler)
-	r.GET("/saas/:name", AuthorizeToken(), SoftwareRequestGetByNameHandler)
-	r.POST("/saas", AuthorizeToken(), SoftwareRequestCreateHandler)
-	r.POST("/saas/:name/:action", AuthorizeToken(), SoftwareRequestActionHandler)
+	r.GET("/saas", AuthorizeToken(), ResourceAllowed(kindSoftware, project.PermRead), SoftwareRequestGetAllHandler)
+	r.GET("/saas/:name", AuthorizeToken(), ResourceAllowed(kindSoftware, project.PermRead), SoftwareRequestGetByNameHandler)
+	r.POST("/saas", AuthorizeToken(), ResourceAllowed(kindSoftware, project.PermManage), SoftwareRequestCreateHandler)
+	r.POST("/saas/:name/:action", AuthorizeToken(), ResourceAllowed(kindSoftware, ""), SoftwareRequestActionHandler)
 	r.GET("/container/:name/ws", ContainerRequestWebConsole)
 	r.GET("/container/:name/web-terminal", WebTerminalHandler)
 
-	//auth api
-	r.POST("/one-time-password", oneTimePassGenHandler)
-	r.POST("/login", accountLoginHandler)
+	//auth api, stricter rate limit against brute-force
+	authLimit := RateLimit(authLimiter)
+	r.POST("/one-time-password", authLimit, oneTimePassGenHandler)
+	r.POST("/login", authLimit, accountLoginHandler)
+	r.GET("/login/providers", IdentityProviderGetAllHandler)
+	r.GET("/sso/:provider/login", authLimit, SsoLoginHandler)
+	r.GET("/sso/:provider/callback", authLimit, SsoCallbackHandler)
+	r.POST("/token/refresh", authLimit, TokenRefreshHandler)
+	r.POST("/logout", AuthorizeToken(), LogoutHandler)
+	r.GET("/token", AuthorizeToken(), ApiTokenRequestGetAllHandler)
+	r.POST("/token", AuthorizeToken(), ApiTokenRequestCreateHandler)
+	r.DELETE("/token/:id", AuthorizeToken(), ApiTokenRequestDelHandler)
+	r.POST("/token/revoke-all", AuthorizeToken(), TokenRevokeAllHandler)
+	if issuer := identity.FakeIssuer(); issuer != nil {
+		r.Any(identity.FakeIssuerPath+"/*path", gin.WrapH(http.StripPrefix(identity.FakeIssuerPath, issuer)))
+	}
 
 	//web ssh terminal
 	//r.GET("/ws/:host/:port/:user/:password", WebConsole)
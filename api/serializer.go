@@ -0,0 +1,119 @@
+package api
+
+import (
+	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/blueprint"
+	"github.com/JinlongWukong/DevLab/node"
+	"github.com/JinlongWukong/DevLab/sshkey"
+	"github.com/JinlongWukong/DevLab/vm"
+)
+
+//Node returned by api, login password only shown to admin who manages nodes, private key never shown
+type nodeView struct {
+	*node.Node
+	Passwd     string `json:"passwd,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"`
+}
+
+//Key returned by api, private key never shown
+type sshKeyView struct {
+	*sshkey.Key
+	PrivateKey string `json:"privateKey,omitempty"`
+}
+
+//VM returned by api, root/vnc password(novnc url contains vnc password) and user-data only shown to owner
+type vmView struct {
+	*vm.VirtualMachine
+	Vnc      vm.VncInfo `json:"vnc"`
+	NoVnc    string     `json:"novnc"`
+	RootPass string     `json:"rootPass,omitempty"`
+	UserData string     `json:"userData,omitempty"`
+}
+
+//Environment returned by api, along with live status of members, members deleted separately reported as deleted
+type environmentView struct {
+	*blueprint.Environment
+	Members []environmentMemberView `json:"members"`
+}
+
+type environmentMemberView struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+//Check whether account has admin role
+func isAdmin(name string) bool {
+
+	ac, exists := account.AccountDB.Get(name)
+	return exists && ac.Role == account.RoleAdmin
+}
+
+//Serialize node for caller, secrets redacted unless caller is admin
+func newNodeView(myNode *node.Node, caller string) nodeView {
+
+	view := nodeView{Node: myNode}
+	if isAdmin(caller) {
+		view.Passwd = myNode.Passwd
+	}
+
+	return view
+}
+
+//Serialize vm for caller, secrets redacted unless caller owns the vm
+func newVmView(myVm *vm.VirtualMachine, owner, caller string) vmView {
+
+	view := vmView{
+		VirtualMachine: myVm,
+		Vnc:            vm.VncInfo{Port: myVm.Vnc.Port},
+	}
+	if owner == caller {
+		view.Vnc.Pass = myVm.Vnc.Pass
+		view.NoVnc = myVm.NoVnc
+		view.RootPass = myVm.RootPass
+		view.UserData = myVm.UserData
+	}
+
+	return view
+}
+
+//Serialize vms of owner for caller
+func newVmViews(vms []*vm.VirtualMachine, owner, caller string) []vmView {
+
+	views := []vmView{}
+	for _, myVm := range vms {
+		views = append(views, newVmView(myVm, owner, caller))
+	}
+
+	return views
+}
+
+//Serialize environment of owner with member status
+func newEnvironmentView(myEnv *blueprint.Environment, owner *account.Account) environmentView {
+
+	view := environmentView{Environment: myEnv, Members: []environmentMemberView{}}
+	vms, k8sList, softwareList := myEnv.Members()
+	for _, name := range vms {
+		status := "deleted"
+		if myVm, err := owner.GetVmByName(name); err == nil {
+			status = myVm.Status
+		}
+		view.Members = append(view.Members, environmentMemberView{Kind: kindVm, Name: name, Status: status})
+	}
+	for _, name := range k8sList {
+		status := "deleted"
+		if myK8s, err := owner.GetK8sByName(name); err == nil {
+			status = string(myK8s.GetStatus())
+		}
+		view.Members = append(view.Members, environmentMemberView{Kind: kindK8s, Name: name, Status: status})
+	}
+	for _, name := range softwareList {
+		status := "deleted"
+		if mySoftware, err := owner.GetSoftwareByName(name); err == nil {
+			status = string(mySoftware.GetStatus())
+		}
+		view.Members = append(view.Members, environmentMemberView{Kind: kindSoftware, Name: name, Status: status})
+	}
+
+	return view
+}
@@ -11,6 +11,9 @@ import (
 var apiHost = ""
 var apiPort = 8088
 
+//rate limiters of all requests and authentication requests, nil means unlimited
+var apiLimiter, authLimiter *rateLimiter
+
 func init() {
 	if config.ApiServer.Host != "" {
 		apiHost = config.ApiServer.Host
@@ -18,6 +21,12 @@ func init() {
 	if config.ApiServer.Port > 0 {
 		apiPort = config.ApiServer.Port
 	}
+	if config.ApiServer.RateLimit > 0 {
+		apiLimiter = newRateLimiter(config.ApiServer.RateLimit, config.ApiServer.RateBurst)
+	}
+	if config.ApiServer.AuthRateLimit > 0 {
+		authLimiter = newRateLimiter(config.ApiServer.AuthRateLimit/60, config.ApiServer.AuthRateBurst)
+	}
 }
 
 func Server() *http.Server {
@@ -0,0 +1,185 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/config"
+)
+
+var auditFile = ".db/audit.log"
+var queryLimit = 1000
+
+//appended records, opened on first write
+var file *os.File
+var lock sync.Mutex
+
+//Parameter name containing any of these is treated as secret, user-data may carry secrets too
+var secretWords = []string{"pass", "secret", "token", "privatekey", "passphrase", "credential", "userdata"}
+
+const redacted = "******"
+
+//initialize configuration
+func init() {
+
+	if config.Audit.File != "" {
+		auditFile = config.Audit.File
+	}
+	if config.Audit.QueryLimit > 0 {
+		queryLimit = config.Audit.QueryLimit
+	}
+}
+
+//Append record into audit log, records are never modified or deleted by program
+func Log(r Record) {
+
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Params = Redact(r.Params)
+	line, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Audit record encode failed: %v", err)
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if file == nil {
+		if err := os.MkdirAll(filepath.Dir(auditFile), 0700); err != nil {
+			log.Printf("Audit log directory create failed: %v", err)
+			return
+		}
+		file, err = os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Printf("Audit log open failed: %v", err)
+			file = nil
+			return
+		}
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Audit record write failed: %v", err)
+		return
+	}
+	file.Sync()
+}
+
+//Copy of params with secret values replaced, nested objects redacted too
+func Redact(params map[string]interface{}) map[string]interface{} {
+
+	if params == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		if isSecret(k) {
+			result[k] = redacted
+		} else {
+			result[k] = redactValue(v)
+		}
+	}
+
+	return result
+}
+
+func redactValue(v interface{}) interface{} {
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		return Redact(value)
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			list[i] = redactValue(item)
+		}
+		return list
+	default:
+		return v
+	}
+}
+
+func isSecret(name string) bool {
+
+	name = strings.ToLower(name)
+	for _, word := range secretWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *Filter) match(r *Record) bool {
+
+	switch {
+	case f.Actor != "" && f.Actor != r.Actor,
+		f.Action != "" && f.Action != r.Action,
+		f.Kind != "" && f.Kind != r.Kind,
+		f.Target != "" && f.Target != r.Target,
+		f.Owner != "" && f.Owner != r.Owner,
+		f.Result != "" && f.Result != r.Result,
+		f.Since.IsZero() == false && r.Time.Before(f.Since),
+		f.Until.IsZero() == false && r.Time.After(f.Until):
+		return false
+	}
+
+	return true
+}
+
+//Query records matching filter, newest first
+func Query(f Filter) ([]*Record, error) {
+
+	limit := f.Limit
+	if limit == 0 || limit > queryLimit {
+		limit = queryLimit
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	records := []*Record{}
+	in, err := os.Open(auditFile)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		r := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			log.Printf("Audit record at line %v not valid, skipped: %v", lineNo, err)
+			continue
+		}
+		if f.match(r) == false {
+			continue
+		}
+		//only newest records within limit kept
+		records = append(records, r)
+		if len(records) > limit {
+			records = records[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("audit log read failed: %v", err)
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	return records, nil
+}
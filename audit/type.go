@@ -0,0 +1,45 @@
+package audit
+
+import "time"
+
+type ResultType string
+
+const (
+	ResultSuccess ResultType = "success"
+	ResultFailure ResultType = "failure"
+)
+
+//Source of operation
+const (
+	SourceApi       = "api"
+	SourceLifecycle = "lifecycle"
+)
+
+//One audited operation, secrets in params are redacted
+type Record struct {
+	Time     time.Time              `json:"time"`
+	Source   string                 `json:"source"`
+	Actor    string                 `json:"actor"`
+	Action   string                 `json:"action"`
+	Kind     string                 `json:"kind"`
+	Target   string                 `json:"target,omitempty"`
+	Owner    string                 `json:"owner,omitempty"`
+	Params   map[string]interface{} `json:"params,omitempty"`
+	Result   ResultType             `json:"result"`
+	Status   int                    `json:"status,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	ClientIP string                 `json:"clientIp,omitempty"`
+}
+
+//Query filter, empty field matches all
+type Filter struct {
+	Actor  string     `form:"actor"`
+	Action string     `form:"action"`
+	Kind   string     `form:"kind"`
+	Target string     `form:"target"`
+	Owner  string     `form:"owner"`
+	Result ResultType `form:"result"`
+	Since  time.Time  `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until  time.Time  `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit  int        `form:"limit" binding:"min=0"`
+}
@@ -3,16 +3,71 @@ package auth
 import (
 	"fmt"
 	"log"
+	"os"
+	"time"
 
+	"github.com/JinlongWukong/DevLab/config"
 	"github.com/JinlongWukong/DevLab/notification"
 	"github.com/JinlongWukong/DevLab/utils"
+	"github.com/dgrijalva/jwt-go"
 )
 
-var expiresIn int64
+//access/refresh token lifetime(unit seconds), api token maximum lifetime(unit days)
+var expiresIn int64 = 3600
+var refreshExpiresIn int64 = 86400 * 7
+var apiTokenMaxDays = 365
+
+//one-time password lifetime, and minimum interval between two one-time passwords of an account
+var OneTimePassExpiry = 10 * time.Minute
+var OneTimePassInterval = time.Minute
 
 func init() {
-	//86400 = 24hours
-	expiresIn = 86400 * 7
+
+	jwtSecret = os.Getenv("JWT_SECRET")
+
+	if config.Auth.AccessTokenExpiry != "" {
+		if d, err := time.ParseDuration(config.Auth.AccessTokenExpiry); err == nil && d > 0 {
+			expiresIn = int64(d / time.Second)
+		} else {
+			log.Printf("Access token expiry %v not valid, %vs used", config.Auth.AccessTokenExpiry, expiresIn)
+		}
+	}
+	if config.Auth.RefreshTokenExpiry != "" {
+		if d, err := time.ParseDuration(config.Auth.RefreshTokenExpiry); err == nil && d > 0 {
+			refreshExpiresIn = int64(d / time.Second)
+		} else {
+			log.Printf("Refresh token expiry %v not valid, %vs used", config.Auth.RefreshTokenExpiry, refreshExpiresIn)
+		}
+	}
+	if config.Auth.ApiTokenMaxDays > 0 {
+		apiTokenMaxDays = config.Auth.ApiTokenMaxDays
+	}
+
+	OneTimePassExpiry = loadDuration("One-time password expiry", config.Auth.OneTimePassExpiry, OneTimePassExpiry)
+	OneTimePassInterval = loadDuration("One-time password interval", config.Auth.OneTimePassInterval, OneTimePassInterval)
+	if config.Auth.MaxLoginFailures > 0 {
+		maxLoginFailures = config.Auth.MaxLoginFailures
+	}
+	if config.Auth.MaxLoginFailuresPerIP > 0 {
+		maxLoginFailuresPerIP = config.Auth.MaxLoginFailuresPerIP
+	}
+	loginFailureWindow = loadDuration("Login failure window", config.Auth.LoginFailureWindow, loginFailureWindow)
+	lockoutDuration = loadDuration("Lockout duration", config.Auth.LockoutDuration, lockoutDuration)
+}
+
+//Parse duration from config, default used if empty or not valid
+func loadDuration(name, value string, d time.Duration) time.Duration {
+
+	if value == "" {
+		return d
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("%v %v not valid, %v used", name, value, d)
+		return d
+	}
+
+	return parsed
 }
 
 func OneTimePassGen(target string) string {
@@ -23,28 +78,112 @@ func OneTimePassGen(target string) string {
 	return password
 }
 
+//Issue access token with refresh token after login
 func InvokeToken(name string) TokenInfo {
 	author := JWTAuthService()
+	refreshClaims := &CustomClaims{
+		Name:           name,
+		Type:           TokenTypeRefresh,
+		StandardClaims: standardClaims(time.Now().Add(time.Duration(refreshExpiresIn) * time.Second)),
+	}
 
 	tokenInfo := TokenInfo{
 		AccessToken:       author.GenerateToken(name, true, expiresIn),
 		AuthorizationType: "bearer",
 		ExpiresIn:         expiresIn,
+		RefreshToken:      author.(*jwtServices).generate(refreshClaims),
+		RefreshExpiresIn:  refreshExpiresIn,
+		Account:           name,
 	}
 
 	return tokenInfo
 }
 
-func ValidateToken(tokenString string) (error, string) {
+func standardClaims(expiresAt time.Time) jwt.StandardClaims {
+	return jwt.StandardClaims{
+		ExpiresAt: expiresAt.Unix(),
+		Issuer:    "DevLab",
+		IssuedAt:  time.Now().Unix(),
+		Id:        newTokenId(),
+	}
+}
+
+//Parse and verify token of any type, revoked token rejected
+func ParseToken(tokenString string) (*CustomClaims, error) {
 	author := JWTAuthService()
 
 	token, err := author.ValidateToken(tokenString)
-	if token != nil && token.Valid {
-		claims := token.Claims.(*CustomClaims)
-		log.Println(claims)
-		return nil, claims.Name
-	} else {
-		log.Println(err)
-		return err, ""
+	if token == nil || token.Valid == false {
+		if err == nil {
+			err = fmt.Errorf("token not valid")
+		}
+		return nil, err
+	}
+	claims := token.Claims.(*CustomClaims)
+	if claims.Id == "" || claims.Type == "" {
+		return nil, fmt.Errorf("token not valid")
+	}
+	if _, revoked := RevocationDB.Get(claims.Id); revoked {
+		return nil, fmt.Errorf("token revoked")
 	}
+	if claims.Type == TokenTypeApi && ApiTokenDB.Touch(claims.Id) == false {
+		return nil, fmt.Errorf("api token revoked")
+	}
+
+	return claims, nil
+}
+
+//Verify refresh token and revoke it, a refresh token can only be used once
+func UseRefreshToken(tokenString string) (*CustomClaims, error) {
+
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != TokenTypeRefresh {
+		return nil, fmt.Errorf("not a refresh token")
+	}
+	//concurrent uses of same token race past the revoked check of ParseToken, only one wins here
+	if err := RevocationDB.RevokeOnce(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+//Create named api token of account, token string only returned here
+func NewApiToken(accountName string, r ApiTokenRequest) (*ApiToken, string, error) {
+
+	days := r.Days
+	if days == 0 {
+		days = apiTokenMaxDays
+	}
+	if days > apiTokenMaxDays {
+		return nil, "", fmt.Errorf("api token lifetime can't exceed %v days", apiTokenMaxDays)
+	}
+	for _, t := range ApiTokensOf(accountName) {
+		if t.Name == r.Name {
+			return nil, "", fmt.Errorf("api token %v already existed", r.Name)
+		}
+	}
+
+	claims := &CustomClaims{
+		Name:           accountName,
+		Type:           TokenTypeApi,
+		StandardClaims: standardClaims(time.Now().AddDate(0, 0, days)),
+	}
+	apiToken := &ApiToken{
+		Id:        claims.Id,
+		Name:      r.Name,
+		Account:   accountName,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+	token := JWTAuthService().(*jwtServices).generate(claims)
+	if token == "" {
+		return nil, "", fmt.Errorf("api token signing failed")
+	}
+	ApiTokenDB.Set(apiToken.Id, apiToken)
+
+	return apiToken, token, nil
 }
@@ -3,12 +3,16 @@ package auth
 import (
 	"fmt"
 	"log"
-	"os"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
+//Minimum length of jwt secret
+const minSecretLength = 32
+
+var jwtSecret string
+
 //jwt services
 type JWTService interface {
 	GenerateToken(name string, isUser bool, ExpiresAt int64) string
@@ -16,7 +20,8 @@ type JWTService interface {
 }
 
 type CustomClaims struct {
-	Name string `json:"name"`
+	Name string    `json:"name"`
+	Type TokenType `json:"type"`
 	jwt.StandardClaims
 }
 
@@ -25,31 +30,43 @@ type jwtServices struct {
 	issuer    string
 }
 
+//Check jwt secret loaded from env JWT_SECRET, program should refuse to start if missing or weak
+//so tokens can't be forged by a well-known default secret
+func CheckSecret() error {
+
+	switch {
+	case jwtSecret == "":
+		return fmt.Errorf("JWT_SECRET not set")
+	case len(jwtSecret) < minSecretLength:
+		return fmt.Errorf("JWT_SECRET too weak, at least %v characters required", minSecretLength)
+	}
+
+	return nil
+}
+
 //auth-jwt
 func JWTAuthService() JWTService {
-	key := func() string {
-		secret := os.Getenv("JWT_SECRET")
-		if secret == "" {
-			secret = "secret"
-		}
-		return secret
-	}()
-
 	return &jwtServices{
-		secretKey: key,
+		secretKey: jwtSecret,
 		issuer:    "DevLab",
 	}
 }
 
 func (service *jwtServices) GenerateToken(name string, isUser bool, ExpiresAt int64) string {
-	claims := &CustomClaims{
-		name,
-		jwt.StandardClaims{
+	return service.generate(&CustomClaims{
+		Name: name,
+		Type: TokenTypeAccess,
+		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(time.Duration(ExpiresAt * int64(time.Second))).Unix(),
 			Issuer:    service.issuer,
 			IssuedAt:  time.Now().Unix(),
+			Id:        newTokenId(),
 		},
-	}
+	})
+}
+
+//Sign claims, token of every kind carries an unique id so it can be revoked
+func (service *jwtServices) generate(claims *CustomClaims) string {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	//encoded string
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+//failed logins allowed within window, then locked out for lockout duration
+var maxLoginFailures = 5
+var maxLoginFailuresPerIP = 20
+var loginFailureWindow = 15 * time.Minute
+var lockoutDuration = 15 * time.Minute
+
+//Failed logins of an account from a client ip, or of a client ip
+type loginFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+var failures = make(map[string]*loginFailures)
+var failuresLock sync.Mutex
+
+//Account failures counted per client ip, so failures from elsewhere can't lock out the account(e.g. admin)
+func accountKey(accountName, ip string) string {
+	return "account/" + accountName + "/" + ip
+}
+
+func ipKey(ip string) string {
+	return "ip/" + ip
+}
+
+//Time left of lockout of account from client ip or of client ip, 0 means login allowed
+func LoginLockedFor(accountName, ip string) time.Duration {
+
+	failuresLock.Lock()
+	defer failuresLock.Unlock()
+
+	now := time.Now()
+	left := time.Duration(0)
+	for _, key := range []string{accountKey(accountName, ip), ipKey(ip)} {
+		if f, exists := failures[key]; exists && f.lockedUntil.After(now) {
+			if d := f.lockedUntil.Sub(now); d > left {
+				left = d
+			}
+		}
+	}
+
+	return left
+}
+
+//Record failed login, account from client ip or client ip locked out once failures reach limit within window
+func LoginFailed(accountName, ip string) {
+
+	failuresLock.Lock()
+	defer failuresLock.Unlock()
+
+	now := time.Now()
+	for k, f := range failures {
+		if now.Sub(f.first) > loginFailureWindow && now.After(f.lockedUntil) {
+			delete(failures, k)
+		}
+	}
+
+	limits := map[string]int{
+		accountKey(accountName, ip): maxLoginFailures,
+		ipKey(ip):                   maxLoginFailuresPerIP,
+	}
+	for key, limit := range limits {
+		f, exists := failures[key]
+		if exists == false {
+			f = &loginFailures{first: now}
+			failures[key] = f
+		}
+		f.count++
+		if f.count >= limit {
+			f.lockedUntil = now.Add(lockoutDuration)
+			f.count = 0
+			f.first = now
+			log.Printf("Login of %v locked out until %v after %v failures", key, f.lockedUntil.Format(time.RFC3339), limit)
+		}
+	}
+}
+
+//Clear failures of account from client ip after successful login, failures of client ip are kept
+func LoginSucceeded(accountName, ip string) {
+
+	failuresLock.Lock()
+	defer failuresLock.Unlock()
+
+	delete(failures, accountKey(accountName, ip))
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"testing"
+)
+
+//Failures of an account from one ip don't lock out the account from another ip
+func TestLoginLockoutPerAccountAndIP(t *testing.T) {
+
+	for i := 0; i < maxLoginFailures; i++ {
+		LoginFailed("admin", "10.0.0.66")
+	}
+	if LoginLockedFor("admin", "10.0.0.66") == 0 {
+		t.Errorf("admin not locked out from failing ip")
+	}
+	if LoginLockedFor("admin", "10.0.0.1") != 0 {
+		t.Errorf("admin locked out from another ip")
+	}
+
+	//client ip locked out for any account once its own limit reached
+	for i := 0; i < maxLoginFailuresPerIP; i++ {
+		LoginFailed("user"+string(rune('a'+i%26)), "10.0.0.77")
+	}
+	if LoginLockedFor("admin", "10.0.0.77") == 0 {
+		t.Errorf("client ip not locked out after %v failures", maxLoginFailuresPerIP)
+	}
+
+	LoginSucceeded("admin", "10.0.0.66")
+	if LoginLockedFor("admin", "10.0.0.66") != 0 {
+		t.Errorf("admin still locked out from ip after successful login")
+	}
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ApiTokenDB = ApiTokenMap{Map: make(map[string]*ApiToken)}
+var RevocationDB = RevocationMap{Map: make(map[string]*Revocation)}
+
+type ApiTokenMap struct {
+	Map  map[string]*ApiToken `json:"apitoken"`
+	lock sync.RWMutex         `json:"-"`
+}
+
+type ApiTokenMapItem struct {
+	Key   string
+	Value *ApiToken
+}
+
+func (m *ApiTokenMap) Set(key string, value *ApiToken) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map[key] = value
+
+}
+
+func (m *ApiTokenMap) Get(key string) (value *ApiToken, exists bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	value, exists = m.Map[key]
+	return
+
+}
+
+func (m *ApiTokenMap) Del(key string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.Map, key)
+
+}
+
+// Replace whole map, used by db restore
+func (m *ApiTokenMap) Replace(newMap map[string]*ApiToken) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copy of map and tokens made under lock, used by db to persist map
+func (m *ApiTokenMap) Snapshot() map[string]*ApiToken {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*ApiToken, len(m.Map))
+	for k, v := range m.Map {
+		c := *v
+		copied[k] = &c
+	}
+
+	return copied
+}
+
+// Iter iterates over the items in a concurrent map
+// Each item is sent over a channel, so that
+// we can iterate over the map using the builtin range keyword
+func (m *ApiTokenMap) Iter() <-chan ApiTokenMapItem {
+	c := make(chan ApiTokenMapItem)
+
+	f := func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		for k, v := range m.Map {
+			c <- ApiTokenMapItem{k, v}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+//Record usage time of api token, false returned if token not found
+func (m *ApiTokenMap) Touch(key string) bool {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	value, exists := m.Map[key]
+	if exists {
+		value.LastUsedAt = time.Now()
+	}
+	return exists
+}
+
+//Copies of all api tokens of account, sorted by creation time
+func ApiTokensOf(accountName string) []*ApiToken {
+
+	m := &ApiTokenDB
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	tokens := []*ApiToken{}
+	for _, v := range m.Map {
+		if v.Account == accountName {
+			token := *v
+			tokens = append(tokens, &token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	return tokens
+}
+
+//Delete all api tokens of account, used when account deleted or all tokens revoked
+func DelApiTokensOf(accountName string) {
+
+	m := &ApiTokenDB
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for k, v := range m.Map {
+		if v.Account == accountName {
+			delete(m.Map, k)
+		}
+	}
+}
+
+type RevocationMap struct {
+	Map  map[string]*Revocation `json:"revocation"`
+	lock sync.RWMutex           `json:"-"`
+}
+
+func (m *RevocationMap) Get(key string) (value *Revocation, exists bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	value, exists = m.Map[key]
+	return
+
+}
+
+// Replace whole map, used by db restore
+func (m *RevocationMap) Replace(newMap map[string]*Revocation) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copy of map and revocations made under lock, used by db to persist map
+func (m *RevocationMap) Snapshot() map[string]*Revocation {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*Revocation, len(m.Map))
+	for k, v := range m.Map {
+		c := *v
+		copied[k] = &c
+	}
+
+	return copied
+}
+
+//Add token into revocation list, expired entries are pruned since expired token is rejected anyway
+func (m *RevocationMap) Revoke(claims *CustomClaims) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.revoke(claims)
+}
+
+//Revoke token unless already revoked, checked and added under one lock so a token can only be revoked once
+func (m *RevocationMap) RevokeOnce(claims *CustomClaims) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, revoked := m.Map[claims.Id]; revoked {
+		return fmt.Errorf("token revoked")
+	}
+	m.revoke(claims)
+
+	return nil
+}
+
+//Caller must hold lock
+func (m *RevocationMap) revoke(claims *CustomClaims) {
+
+	now := time.Now()
+	for k, v := range m.Map {
+		if now.After(v.ExpiresAt) {
+			delete(m.Map, k)
+		}
+	}
+	m.Map[claims.Id] = &Revocation{
+		Id:        claims.Id,
+		Account:   claims.Name,
+		RevokedAt: now,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+	log.Printf("Token %v of account %v revoked", claims.Id, claims.Name)
+}
+
+//Unique token id(jti)
+func newTokenId() string {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("token id generation failed: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+//Refresh token used concurrently is revoked by exactly one of the users
+func TestRevokeOnceConcurrent(t *testing.T) {
+
+	claims := &CustomClaims{
+		Name:           "alice",
+		Type:           TokenTypeRefresh,
+		StandardClaims: jwt.StandardClaims{Id: newTokenId(), ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	won := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := RevocationDB.RevokeOnce(claims); err == nil {
+				lock.Lock()
+				won++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if won != 1 {
+		t.Errorf("token revoked %v times, expected once", won)
+	}
+	if _, revoked := RevocationDB.Get(claims.Id); revoked == false {
+		t.Errorf("token not in revocation list")
+	}
+}
@@ -1,12 +1,62 @@
 package auth
 
+import "time"
+
+type TokenType string
+
+const (
+	//short-lived token used by api calls
+	TokenTypeAccess TokenType = "access"
+	//used once to get new access token
+	TokenTypeRefresh TokenType = "refresh"
+	//named long-lived token for scripts, valid until revoked or expired
+	TokenTypeApi TokenType = "api"
+)
+
 type LoginInfo struct {
 	Account  string `form:"account" json:"account" binding:"required"`
 	Password string `form:"password" json:"password" binding:"required"`
+	//identity provider verifying password, one-time password used if empty
+	Provider string `form:"provider" json:"provider"`
 }
 
 type TokenInfo struct {
 	AccessToken       string `json:"access_token,omitempty"`
 	AuthorizationType string `json:"authorization_type,omitempty"`
 	ExpiresIn         int64  `json:"expires_in,omitempty"`
+	RefreshToken      string `json:"refresh_token,omitempty"`
+	RefreshExpiresIn  int64  `json:"refresh_expires_in,omitempty"`
+	Account           string `json:"account,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `form:"refresh_token" json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `form:"refresh_token" json:"refresh_token"`
+}
+
+//Named api token, token string itself is only returned once on creation
+type ApiToken struct {
+	Id         string    `json:"id"`
+	Name       string    `json:"name"`
+	Account    string    `json:"account"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+type ApiTokenRequest struct {
+	Name string `form:"name" json:"name" binding:"required"`
+	//lifetime in days, 0 means maximum lifetime allowed
+	Days int `form:"days" json:"days" binding:"min=0"`
+}
+
+//Revoked token, kept until token expires
+type Revocation struct {
+	Id        string    `json:"id"`
+	Account   string    `json:"account"`
+	RevokedAt time.Time `json:"revokedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
 }
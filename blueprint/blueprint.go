@@ -0,0 +1,235 @@
+package blueprint
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/saas"
+)
+
+var BlueprintDB = BlueprintMap{Map: make(map[string]*Blueprint)}
+
+var blueprintNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+//vm template name is used as hostname
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+type BlueprintMap struct {
+	Map  map[string]*Blueprint `json:"blueprint"`
+	lock sync.RWMutex          `json:"-"`
+}
+
+type BlueprintMapItem struct {
+	Key   string
+	Value *Blueprint
+}
+
+func (m *BlueprintMap) Set(key string, value *Blueprint) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map[key] = value
+
+}
+
+func (m *BlueprintMap) Get(key string) (value *Blueprint, exists bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	value, exists = m.Map[key]
+	return
+
+}
+
+func (m *BlueprintMap) Del(key string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.Map, key)
+
+}
+
+// Replace whole map, used by db restore
+func (m *BlueprintMap) Replace(newMap map[string]*Blueprint) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copy of map made under lock, blueprints are not changed once created
+func (m *BlueprintMap) Snapshot() map[string]*Blueprint {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*Blueprint, len(m.Map))
+	for k, v := range m.Map {
+		copied[k] = v
+	}
+
+	return copied
+}
+
+// Iter iterates over the items in a concurrent map
+// Each item is sent over a channel, so that
+// we can iterate over the map using the builtin range keyword
+func (m *BlueprintMap) Iter() <-chan BlueprintMapItem {
+	c := make(chan BlueprintMapItem)
+
+	f := func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		for k, v := range m.Map {
+			c <- BlueprintMapItem{k, v}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+//Build blueprint from request, sizes and images are checked against catalog on deployment
+func NewBlueprint(blueprintRequest BlueprintRequest, owner string) (*Blueprint, error) {
+
+	if blueprintNameRegexp.MatchString(blueprintRequest.Name) == false {
+		return nil, fmt.Errorf("blueprint name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	if len(blueprintRequest.VMs) == 0 && len(blueprintRequest.K8S) == 0 && len(blueprintRequest.Software) == 0 {
+		return nil, fmt.Errorf("blueprint must have at least one vm, k8s or software")
+	}
+
+	names := make(map[string]bool)
+	for i, t := range blueprintRequest.VMs {
+		if hostnameRegexp.MatchString(t.Name) == false {
+			return nil, fmt.Errorf("vm name %v not valid hostname", t.Name)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("vm name %v duplicated", t.Name)
+		}
+		names[t.Name] = true
+		if t.Flavor == "" && (t.CPU == 0 || t.Memory == 0 || t.Disk == 0) {
+			return nil, fmt.Errorf("vm %v requires flavor or all of cpu, memory, disk", t.Name)
+		}
+		for j, p := range t.Ports {
+			if p.Protocol == "" {
+				blueprintRequest.VMs[i].Ports[j].Protocol = "tcp"
+			}
+		}
+	}
+
+	supported := make(map[string]bool)
+	for _, kind := range saas.GetSupportedSoftware() {
+		supported[kind] = true
+	}
+	for _, t := range blueprintRequest.Software {
+		if supported[t.Kind] == false {
+			return nil, fmt.Errorf("software kind %v not supported", t.Kind)
+		}
+	}
+
+	return &Blueprint{
+		Name:        blueprintRequest.Name,
+		Description: blueprintRequest.Description,
+		Owner:       owner,
+		VMs:         blueprintRequest.VMs,
+		K8S:         blueprintRequest.K8S,
+		Software:    blueprintRequest.Software,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+//New environment in deploying status, members added while deploying
+func NewEnvironment(name, blueprintName, projectName string, lifetime time.Duration) *Environment {
+
+	return &Environment{
+		Name:      name,
+		Blueprint: blueprintName,
+		Status:    EnvironmentStatusDeploying,
+		Lifetime:  lifetime,
+		Project:   projectName,
+		VMs:       []string{},
+		K8S:       []string{},
+		Software:  []string{},
+		CreatedAt: time.Now(),
+	}
+}
+
+//Set environment status, error message kept for failed status only
+func (e *Environment) SetStatus(status EnvironmentStatus, err error) {
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.Status = status
+	e.Error = ""
+	if err != nil {
+		e.Error = err.Error()
+	}
+}
+
+func (e *Environment) GetStatus() EnvironmentStatus {
+
+	e.RLock()
+	defer e.RUnlock()
+
+	return e.Status
+}
+
+func (e *Environment) ChangeLifeTime(delta time.Duration) time.Duration {
+
+	e.lifeMutex.Lock()
+	defer e.lifeMutex.Unlock()
+
+	e.Lifetime += delta
+	return e.Lifetime
+}
+
+func (e *Environment) GetLifeTime() time.Duration {
+
+	e.lifeMutex.RLock()
+	defer e.lifeMutex.RUnlock()
+
+	return e.Lifetime
+}
+
+func (e *Environment) AddVMs(names ...string) {
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.VMs = append(e.VMs, names...)
+}
+
+func (e *Environment) AddK8S(name string) {
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.K8S = append(e.K8S, name)
+}
+
+func (e *Environment) AddSoftware(name string) {
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.Software = append(e.Software, name)
+}
+
+//Copy of member names
+func (e *Environment) Members() (vms, k8s, software []string) {
+
+	e.RLock()
+	defer e.RUnlock()
+
+	return append([]string{}, e.VMs...), append([]string{}, e.K8S...), append([]string{}, e.Software...)
+}
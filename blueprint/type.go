@@ -0,0 +1,93 @@
+package blueprint
+
+import (
+	"sync"
+	"time"
+)
+
+type EnvironmentStatus string
+
+const (
+	EnvironmentStatusDeploying EnvironmentStatus = "deploying"
+	EnvironmentStatusRunning   EnvironmentStatus = "running"
+	EnvironmentStatusFailed    EnvironmentStatus = "failed"
+	EnvironmentStatusDeleting  EnvironmentStatus = "deleting"
+)
+
+//Port exposed on every vm of template once it is running
+type PortTemplate struct {
+	Port     int    `json:"port" yaml:"port" binding:"required,min=1,max=65535"`
+	Protocol string `json:"protocol" yaml:"protocol" binding:"omitempty,oneof=tcp udp"`
+}
+
+//Group of identical vms, name used as hostname, flavor preferred over custom size
+type VmTemplate struct {
+	Name    string         `json:"name" yaml:"name" binding:"required"`
+	Type    string         `json:"type" yaml:"type" binding:"required"`
+	Flavor  string         `json:"flavor,omitempty" yaml:"flavor"`
+	CPU     int32          `json:"cpu,omitempty" yaml:"cpu" binding:"min=0"`
+	Memory  int32          `json:"memory,omitempty" yaml:"memory" binding:"min=0"`
+	Disk    int32          `json:"disk,omitempty" yaml:"disk" binding:"min=0"`
+	Number  int32          `json:"numbers" yaml:"numbers" binding:"required,min=1,max=5"`
+	Addons  []string       `json:"addons,omitempty" yaml:"addons"`
+	SshKeys []string       `json:"sshKeys,omitempty" yaml:"sshKeys"`
+	Ports   []PortTemplate `json:"ports,omitempty" yaml:"ports" binding:"dive"`
+}
+
+type K8sTemplate struct {
+	Version          string `json:"version" yaml:"version" binding:"required"`
+	NumOfContronller uint16 `json:"numOfContronller,omitempty" yaml:"numOfContronller" binding:"omitempty,max=5"`
+	NumOfWorker      uint16 `json:"numOfWorker,omitempty" yaml:"numOfWorker" binding:"omitempty,max=100"`
+}
+
+type SoftwareTemplate struct {
+	Kind    string `json:"kind" yaml:"kind" binding:"required"`
+	Version string `json:"version" yaml:"version" binding:"required"`
+	CPU     uint8  `json:"cpu" yaml:"cpu" binding:"required,min=1,max=20"`
+	Memory  uint32 `json:"memory" yaml:"memory" binding:"required,min=10,max=65536"`
+}
+
+//Group of vms, k8s clusters and software deployed together as one environment
+//Secrets like root password are not kept, generated on each deployment
+type Blueprint struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Owner       string             `json:"owner"`
+	VMs         []VmTemplate       `json:"vms,omitempty"`
+	K8S         []K8sTemplate      `json:"k8s,omitempty"`
+	Software    []SoftwareTemplate `json:"software,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt"`
+}
+
+//Accepted as json or yaml body, name taken from path on update
+type BlueprintRequest struct {
+	Name        string             `json:"name" yaml:"name"`
+	Description string             `json:"description" yaml:"description"`
+	VMs         []VmTemplate       `json:"vms" yaml:"vms" binding:"dive"`
+	K8S         []K8sTemplate      `json:"k8s" yaml:"k8s" binding:"dive"`
+	Software    []SoftwareTemplate `json:"software" yaml:"software" binding:"dive"`
+}
+
+//Duration unit day, shared by all resources of environment
+type DeployRequest struct {
+	Duration int `form:"duration" json:"duration" yaml:"duration" binding:"required,min=1"`
+	//project which environment belongs to, given by api query parameter
+	Project string `form:"-" json:"-" yaml:"-"`
+}
+
+//Resources deployed from blueprint, torn down together when lifetime is over
+type Environment struct {
+	Name         string            `json:"name"`
+	Blueprint    string            `json:"blueprint"`
+	Status       EnvironmentStatus `json:"status"`
+	Error        string            `json:"error,omitempty"`
+	Lifetime     time.Duration     `json:"lifeTime"`
+	Project      string            `json:"project,omitempty"`
+	VMs          []string          `json:"vms"`
+	K8S          []string          `json:"k8s"`
+	Software     []string          `json:"software"`
+	Task         string            `json:"task"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	sync.RWMutex `json:"-"`
+	lifeMutex    sync.RWMutex `json:"-"`
+}
@@ -9,13 +9,25 @@ import (
 type DatabaseConfig struct {
 	//database sync up period
 	SyncPeriod int
-	//database format(json, gob)
+	//database format(json, bolt)
 	Format string
+	//previous generations kept for json format
+	Generations int
 	//database compress and sftp period
 	CompressPeriod int
-	//sftp info
-	SftpPort                                     int
-	SftpHost, SftpUser, SftpPass, SftpRemotePath string
+	//backup target(sftp, local, s3)
+	BackupTarget string
+	//sftp info, key file used if given, password used otherwise
+	SftpPort                                                  int
+	SftpHost, SftpUser, SftpPass, SftpKeyFile, SftpRemotePath string
+	//local directory info
+	BackupLocalPath string
+	//s3 compatible object store info
+	S3Endpoint, S3Region, S3Bucket, S3Prefix, S3AccessKey, S3SecretKey string
+	//backup retention, keep newest N backups and newest one of each day in last D days, 0 means keep all
+	BackupKeepLast, BackupKeepDays int
+	//master key file for secrets encryption, env DEVLAB_MASTER_KEY/DEVLAB_MASTER_KEY_FILE take precedence
+	MasterKeyFile string
 }
 
 type NotificationConfig struct {
@@ -36,6 +48,13 @@ type WorkflowConfig struct {
 	NoVncPort        int
 	NoVncProtocol    string
 	NoVncHost        string
+	//maximum snapshots kept per vm
+	VmMaxSnapshots int
+	//flavors of vm hosting k8s cluster, large one used when workers more than K8sLargeWorkers
+	K8sHostFlavor, K8sLargeHostFlavor string
+	K8sLargeWorkers                   int
+	//finished tasks older than TaskKeepDays pruned, oldest finished ones pruned when more than TaskKeepMax
+	TaskKeepDays, TaskKeepMax int
 }
 
 type LifeCycleConfig struct {
@@ -45,6 +64,8 @@ type LifeCycleConfig struct {
 }
 
 type DeployerConfig struct {
+	//deployer kind(http, fake)
+	Kind     string
 	Protocol string
 	EndPoint string
 }
@@ -52,6 +73,14 @@ type DeployerConfig struct {
 type ApiServerConfig struct {
 	Host string
 	Port int
+	//requests per second and burst allowed per client ip, 0 means unlimited
+	RateLimit float64
+	RateBurst int
+	//requests per minute and burst allowed per client ip on authentication endpoints, 0 means unlimited
+	AuthRateLimit float64
+	AuthRateBurst int
+	//client ip taken from X-Forwarded-For/X-Real-Ip, only enable behind a trusted reverse proxy
+	TrustForwardedFor bool
 }
 
 type SupervisorConfig struct {
@@ -77,6 +106,44 @@ type NetworkConfig struct {
 	NetworkType   string
 }
 
+type IdentityConfig struct {
+	//identity providers enabled besides one-time password, comma separated(ldap, oidc, fake)
+	Providers string
+	//role of account provisioned on first login, members of admin groups(comma separated) become admin
+	DefaultRole, AdminGroups string
+	//ldap server(ldap:// or ldaps://), service account used to search user entry
+	LdapURL, LdapBindDN, LdapBindPassword, LdapBaseDN string
+	//user search filter, %s replaced by escaped user name
+	LdapUserFilter, LdapEmailAttribute, LdapGroupAttribute string
+	LdapInsecureSkipVerify                                 bool
+	//openid connect authorization code flow
+	OidcIssuer, OidcClientID, OidcClientSecret, OidcRedirectURL string
+	//scopes separated by space, claims used as account name and groups
+	OidcScopes, OidcUsernameClaim, OidcGroupsClaim string
+	//local stand-in idp for test, users as name:password:group1|group2, comma separated
+	FakeUsers string
+}
+
+type AuthConfig struct {
+	//access token and refresh token lifetime, e.g. 1h, 168h
+	AccessTokenExpiry  string
+	RefreshTokenExpiry string
+	//maximum lifetime of api token in days
+	ApiTokenMaxDays int
+	//one-time password lifetime, and minimum interval between two one-time passwords of an account
+	OneTimePassExpiry, OneTimePassInterval string
+	//failed logins allowed per account and per client ip within window before locked out
+	MaxLoginFailures, MaxLoginFailuresPerIP int
+	LoginFailureWindow, LockoutDuration     string
+}
+
+type AuditConfig struct {
+	//append-only audit log file, one json record per line
+	File string
+	//maximum records returned by one query
+	QueryLimit int
+}
+
 var DB DatabaseConfig
 var Workflow WorkflowConfig
 var Schedule ScheduleConfig
@@ -87,16 +154,27 @@ var ApiServer ApiServerConfig
 var Supervisor SupervisorConfig
 var Node NodeConfig
 var Network NetworkConfig
+var Identity IdentityConfig
+var Auth AuthConfig
+var Audit AuditConfig
+
+//Not fatal in init, so tests running in package directory can load config.ini by themselves
+var loadErr error
 
 func init() {
 
 	//Load config.ini
-	if err := LoadConfig(); err != nil {
-		log.Fatalf("configuration file loadling failed %v, program exited", err)
+	if loadErr = LoadConfig(); loadErr != nil {
+		log.Printf("configuration file loading failed %v", loadErr)
 	}
 
 }
 
+//Error of loading config.ini at startup, other packages are initialized with defaults if any
+func CheckLoaded() error {
+	return loadErr
+}
+
 func LoadConfig() error {
 
 	log.Println("Start loading config.ini")
@@ -165,6 +243,24 @@ func LoadConfig() error {
 		return err
 	}
 
+	err = cfg.Section("Auth").MapTo(&Auth)
+	if err != nil {
+		log.Printf("Fail to parse section %v: %v", "Auth", err)
+		return err
+	}
+
+	err = cfg.Section("Audit").MapTo(&Audit)
+	if err != nil {
+		log.Printf("Fail to parse section %v: %v", "Audit", err)
+		return err
+	}
+
+	err = cfg.Section("Identity").MapTo(&Identity)
+	if err != nil {
+		log.Printf("Fail to parse section %v: %v", "Identity", err)
+		return err
+	}
+
 	log.Println("All configuration loading done")
 	return nil
 
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+
+	"github.com/JinlongWukong/DevLab/account"
+)
+
+//Remote backup file name: <table>.json.gz-<timestamp>
+const backupSuffix = ".json.gz-"
+
+//Serialize saving to store, since restore may save outside of db manager loop
+var saveLock sync.Mutex
+
+//Remote backup, tables uploaded at same time share the same timestamp
+type Backup struct {
+	Timestamp string   `json:"timestamp"`
+	Tables    []string `json:"tables"`
+	Size      int64    `json:"size"`
+}
+
+//Save all tables into store
+func saveTables() {
+
+	saveLock.Lock()
+	defer saveLock.Unlock()
+
+	for _, t := range tables {
+		snapshot, err := t.snapshot()
+		if err == nil {
+			err = store.Save(t.name, snapshot)
+		}
+		if err != nil {
+			log.Printf("Save table %v failed with error: %v", t.name, err)
+		} else {
+			log.Printf("Saved table %v to %v db", t.name, format)
+		}
+	}
+}
+
+//Compress every table snapshot and upload to remote backup target, expired backups pruned after upload
+func uploadBackup(timeStamp string) error {
+
+	target, err := NewBackupTarget(backupKind)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	for _, t := range tables {
+		snapshot, err := t.snapshot()
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(snapshot, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		if err := target.Put(t.name+backupSuffix+timeStamp, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	log.Printf("Backup %v uploaded to %v backup target", timeStamp, backupKind)
+
+	if err := pruneBackups(target); err != nil {
+		log.Printf("Prune expired backups failed with error: %v", err)
+	}
+
+	return nil
+}
+
+//List backups on remote backup target, newest first
+func ListBackups() ([]Backup, error) {
+
+	target, err := NewBackupTarget(backupKind)
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+
+	return listBackups(target)
+}
+
+//Download backup and validate it, every table must be decodable after migration
+//Args:
+//  timeStamp -> backup timestamp, "latest" means newest backup
+//Return:
+//  snapshots of all tables and functions to swap decoded tables into memory
+func fetchBackup(timeStamp string) (map[string]*Snapshot, []func(), error) {
+
+	target, err := NewBackupTarget(backupKind)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer target.Close()
+	backups, err := listBackups(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	var backup *Backup
+	for i, b := range backups {
+		if b.Timestamp == timeStamp || (timeStamp == "latest" && i == 0) {
+			backup = &backups[i]
+			break
+		}
+	}
+	if backup == nil {
+		available := []string{}
+		for _, b := range backups {
+			available = append(available, b.Timestamp)
+		}
+		return nil, nil, fmt.Errorf("backup %v not found, available backups: %v", timeStamp, available)
+	}
+
+	snapshots := map[string]*Snapshot{}
+	swaps := []func(){}
+	for _, t := range tables {
+		exists := false
+		for _, name := range backup.Tables {
+			exists = exists || name == t.name
+		}
+		if exists == false {
+			if t.required {
+				return nil, nil, fmt.Errorf("backup %v has no %v table", backup.Timestamp, t.name)
+			}
+			log.Printf("Backup %v has no %v table, it will be emptied", backup.Timestamp, t.name)
+			snapshots[t.name] = &Snapshot{Version: SchemaVersion, Data: map[string]json.RawMessage{}}
+		} else {
+			data, err := target.Get(t.name + backupSuffix + backup.Timestamp)
+			if err != nil {
+				return nil, nil, fmt.Errorf("download %v table failed: %v", t.name, err)
+			}
+			snapshot, err := readGzipSnapshot(data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%v table of backup %v is invalid: %v", t.name, backup.Timestamp, err)
+			}
+			if _, err := openSecrets(t.name, t.secrets, snapshot); err != nil {
+				return nil, nil, fmt.Errorf("%v table of backup %v secrets can't be decrypted: %v", t.name, backup.Timestamp, err)
+			}
+			if err := migrate(t.name, snapshot); err != nil {
+				return nil, nil, err
+			}
+			snapshots[t.name] = snapshot
+		}
+
+		swap, err := t.decode(snapshots[t.name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v table of backup %v can't be decoded: %v", t.name, backup.Timestamp, err)
+		}
+		swaps = append(swaps, swap)
+	}
+
+	log.Printf("Backup %v downloaded and validated", backup.Timestamp)
+	return snapshots, swaps, nil
+}
+
+func readGzipSnapshot(gz []byte) (*Snapshot, error) {
+
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSnapshot(data)
+}
+
+//Restore backup into running controller, memory and local db are both replaced
+//Caller should make sure no workflow in progress
+func RestoreBackup(timeStamp string) error {
+
+	_, swaps, err := fetchBackup(timeStamp)
+	if err != nil {
+		return err
+	}
+
+	for _, swap := range swaps {
+		swap()
+	}
+	account.AccountDB.InitializeAdmin()
+	saveTables()
+
+	log.Printf("Backup %v restored", timeStamp)
+	return nil
+}
+
+//Restore backup into local db before controller started, used by --restore startup mode
+func RestoreOnStartup(timeStamp string) error {
+
+	snapshots, _, err := fetchBackup(timeStamp)
+	if err != nil {
+		return err
+	}
+
+	localStore, err := NewStore(format, dbDir)
+	if err != nil {
+		return err
+	}
+	defer localStore.Close()
+
+	for _, t := range tables {
+		if err := sealSecrets(t.name, t.secrets, snapshots[t.name]); err != nil {
+			return fmt.Errorf("encrypt %v table secrets failed: %v", t.name, err)
+		}
+		if err := localStore.Save(t.name, snapshots[t.name]); err != nil {
+			return fmt.Errorf("save %v table failed: %v", t.name, err)
+		}
+	}
+
+	log.Printf("Backup %v restored into local %v db", timeStamp, format)
+	return nil
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Remote storage where db backups uploaded to
+type BackupTarget interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	//List all files under target, name relative to target root
+	List() ([]BackupFile, error)
+	Delete(name string) error
+	//Release connection held by target, target can't be used after closed
+	Close() error
+}
+
+type BackupFile struct {
+	Name string
+	Size int64
+}
+
+//New backup target by kind(sftp, local, s3)
+func NewBackupTarget(kind string) (BackupTarget, error) {
+	switch kind {
+	case "sftp":
+		return newSftpTarget(sftpHost, sftpUser, sftpPass, sftpKeyFile, sftpPort, sftpRemotePath), nil
+	case "local":
+		return newLocalTarget(backupLocalPath)
+	case "s3":
+		return newS3Target(s3Endpoint, s3Region, s3Bucket, s3Prefix, s3AccessKey, s3SecretKey)
+	default:
+		return nil, fmt.Errorf("backup target %v not supported", kind)
+	}
+}
+
+//Select backups to be removed by retention policy
+//  keepLast -> newest N backups always kept
+//  keepDays -> newest backup of each day in last D days kept
+//Nothing removed if both are 0, backup with unknown timestamp format is always kept
+func selectExpiredBackups(backups []Backup, keepLast, keepDays int, now time.Time) []Backup {
+
+	if keepLast <= 0 && keepDays <= 0 {
+		return nil
+	}
+
+	sorted := make([]Backup, len(backups))
+	copy(sorted, backups)
+	sortBackups(sorted)
+
+	expired := []Backup{}
+	days := map[string]bool{}
+	for i, b := range sorted {
+		t, err := time.Parse(time.RFC3339Nano, b.Timestamp)
+		if err != nil {
+			continue
+		}
+		if i < keepLast {
+			days[t.Format("2006-01-02")] = true
+			continue
+		}
+		day := t.Format("2006-01-02")
+		if keepDays > 0 && now.Sub(t) < time.Duration(keepDays)*24*time.Hour && days[day] == false {
+			days[day] = true
+			continue
+		}
+		expired = append(expired, b)
+	}
+
+	return expired
+}
+
+//Remove expired backups from target
+func pruneBackups(target BackupTarget) error {
+
+	backups, err := listBackups(target)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range selectExpiredBackups(backups, backupKeepLast, backupKeepDays, time.Now()) {
+		log.Printf("Backup %v expired, will be removed", b.Timestamp)
+		for _, table := range b.Tables {
+			if err := target.Delete(table + backupSuffix + b.Timestamp); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//Group backup files by timestamp, newest first
+func listBackups(target BackupTarget) ([]Backup, error) {
+
+	files, err := target.List()
+	if err != nil {
+		return nil, err
+	}
+
+	backups := map[string]*Backup{}
+	for _, f := range files {
+		i := strings.Index(f.Name, backupSuffix)
+		if i <= 0 {
+			continue
+		}
+		table, timeStamp := f.Name[:i], f.Name[i+len(backupSuffix):]
+		if _, exists := backups[timeStamp]; exists == false {
+			backups[timeStamp] = &Backup{Timestamp: timeStamp, Tables: []string{}}
+		}
+		backups[timeStamp].Tables = append(backups[timeStamp].Tables, table)
+		backups[timeStamp].Size += f.Size
+	}
+
+	result := []Backup{}
+	for _, b := range backups {
+		sort.Strings(b.Tables)
+		result = append(result, *b)
+	}
+	sortBackups(result)
+
+	return result, nil
+}
+
+//Sort backups newest first, timestamp can't be parsed will be put at the end
+func sortBackups(backups []Backup) {
+	sort.SliceStable(backups, func(i, j int) bool {
+		ti, erri := time.Parse(time.RFC3339Nano, backups[i].Timestamp)
+		tj, errj := time.Parse(time.RFC3339Nano, backups[j].Timestamp)
+		if erri != nil || errj != nil {
+			return erri == nil
+		}
+		return ti.After(tj)
+	})
+}
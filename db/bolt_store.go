@@ -0,0 +1,153 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//Bucket keeping schema version and wrapped data key of each table
+const boltSchemaBucket = "_schema"
+const boltDataKeySuffix = ".dataKey"
+
+//Embedded key/value store, every table is a bucket and every object is a key
+//All changes of one table are written in a single transaction, so a crash can't leave table half written
+type boltStore struct {
+	db *bolt.DB
+	//last saved objects of each table, used to skip writing unchanged objects
+	cache map[string]map[string]json.RawMessage
+	lock  sync.Mutex
+}
+
+var _ Store = &boltStore{}
+
+func newBoltStore(path string) (*boltStore, error) {
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStore{
+		db:    db,
+		cache: make(map[string]map[string]json.RawMessage),
+	}, nil
+}
+
+func (s *boltStore) Save(table string, snapshot *Snapshot) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	last := s.cache[table]
+
+	var put, del int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(boltSchemaBucket))
+		if err != nil {
+			return err
+		}
+		if err := meta.Put([]byte(table), []byte(strconv.Itoa(snapshot.Version))); err != nil {
+			return err
+		}
+		if snapshot.DataKey != "" {
+			err = meta.Put([]byte(table+boltDataKeySuffix), []byte(snapshot.DataKey))
+		} else {
+			err = meta.Delete([]byte(table + boltDataKeySuffix))
+		}
+		if err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte(table))
+		if err != nil {
+			return err
+		}
+		//table never loaded, e.g. restored from backup, existing objects must be compared as well
+		if last == nil {
+			last = make(map[string]json.RawMessage)
+			b.ForEach(func(k, _ []byte) error {
+				last[string(k)] = nil
+				return nil
+			})
+		}
+		for k, data := range snapshot.Data {
+			if old, exists := last[k]; exists && bytes.Equal(old, data) {
+				continue
+			}
+			if err := b.Put([]byte(k), data); err != nil {
+				return err
+			}
+			put++
+		}
+		for k := range last {
+			if _, exists := snapshot.Data[k]; exists == false {
+				if err := b.Delete([]byte(k)); err != nil {
+					return err
+				}
+				del++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.cache[table] = snapshot.Data
+	if put > 0 || del > 0 {
+		log.Printf("Bolt store table %v: %v objects written, %v objects deleted", table, put, del)
+	}
+
+	return nil
+}
+
+func (s *boltStore) Load(table string) (*Snapshot, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var snapshot *Snapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(table))
+		if b == nil {
+			return nil
+		}
+		snapshot = &Snapshot{Data: make(map[string]json.RawMessage)}
+		if meta := tx.Bucket([]byte(boltSchemaBucket)); meta != nil {
+			if v := meta.Get([]byte(table)); v != nil {
+				version, err := strconv.Atoi(string(v))
+				if err != nil {
+					return err
+				}
+				snapshot.Version = version
+			}
+			if v := meta.Get([]byte(table + boltDataKeySuffix)); v != nil {
+				snapshot.DataKey = string(v)
+			}
+		}
+		return b.ForEach(func(k, data []byte) error {
+			snapshot.Data[string(k)] = append(json.RawMessage{}, data...)
+			return nil
+		})
+	})
+	if err != nil || snapshot == nil {
+		return nil, err
+	}
+
+	//copy since snapshot may be changed by migration
+	s.cache[table] = make(map[string]json.RawMessage)
+	for k, v := range snapshot.Data {
+		s.cache[table][k] = v
+	}
+
+	return snapshot, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -1,29 +1,120 @@
 package db
 
 import (
-	"compress/gzip"
 	"context"
-	"encoding/json"
+	"fmt"
 	"log"
-	"os"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/auth"
+	"github.com/JinlongWukong/DevLab/blueprint"
 	"github.com/JinlongWukong/DevLab/config"
+	"github.com/JinlongWukong/DevLab/flavor"
+	"github.com/JinlongWukong/DevLab/hostkey"
+	"github.com/JinlongWukong/DevLab/image"
 	"github.com/JinlongWukong/DevLab/manager"
 	"github.com/JinlongWukong/DevLab/node"
-	"github.com/JinlongWukong/DevLab/utils"
+	"github.com/JinlongWukong/DevLab/project"
+	"github.com/JinlongWukong/DevLab/quota"
+	"github.com/JinlongWukong/DevLab/sshkey"
+	"github.com/JinlongWukong/DevLab/task"
 )
 
 var requestChan = make(chan struct{}, 1)
+var loadedChan = make(chan struct{})
 var dbSyncPeriod = 5
 var dbCompressPeriod = 3600
 var format = "json"
 var sftpHost, sftpUser, sftpPass string
 var sftpRemotePath = "/var/tmp"
 var sftpPort = 22
+var sftpKeyFile string
+var backupKind = "sftp"
+var backupLocalPath = ".backup"
+var s3Endpoint, s3Region, s3Bucket, s3Prefix, s3AccessKey, s3SecretKey string
+var backupKeepLast, backupKeepDays int
+var dbDir = ".db"
+var generations = 3
+var masterKeyFile string
+var store Store
+
+//Table persisted into store
+//  data     -> content of table map to be persisted
+//  required -> backup without this table can't be restored
+//  secrets  -> paths of secret fields encrypted at rest, "*" matches every element of array
+//  decode   -> decode snapshot into a new map, returned function swap it into memory
+type table struct {
+	name     string
+	data     func() interface{}
+	required bool
+	secrets  []string
+	decode   func(s *Snapshot) (func(), error)
+}
+
+var tables = []table{
+	{"account", func() interface{} { return account.AccountDB.Snapshot() }, true, []string{"vm.*.rootPass", "vm.*.vnc.passwd", "vm.*.novnc", "vm.*.userData"}, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*account.Account)
+		err := s.decode(&m)
+		return func() { account.AccountDB.Replace(m) }, err
+	}},
+	{"node", func() interface{} { return node.NodeDB.Snapshot() }, true, []string{"passwd", "privateKey"}, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*node.Node)
+		err := s.decode(&m)
+		return func() { node.NodeDB.Replace(m) }, err
+	}},
+	{"task", func() interface{} { return task.TaskDB.Snapshot() }, false, nil, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*task.Task)
+		err := s.decode(&m)
+		return func() { task.TaskDB.Replace(m) }, err
+	}},
+	{"sshkey", func() interface{} { return sshkey.KeyDB.Snapshot() }, false, []string{"privateKey"}, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*sshkey.Key)
+		err := s.decode(&m)
+		return func() { sshkey.KeyDB.Replace(m) }, err
+	}},
+	{"hostkey", func() interface{} { return hostkey.HostKeyDB.Snapshot() }, false, nil, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*hostkey.HostKey)
+		err := s.decode(&m)
+		return func() { hostkey.HostKeyDB.Replace(m) }, err
+	}},
+	{"image", func() interface{} { return image.ImageDB.Snapshot() }, false, nil, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*image.Image)
+		err := s.decode(&m)
+		return func() { image.ImageDB.Replace(m) }, err
+	}},
+	{"flavor", func() interface{} { return flavor.FlavorDB.Snapshot() }, false, nil, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*flavor.Flavor)
+		err := s.decode(&m)
+		return func() { flavor.FlavorDB.Replace(m) }, err
+	}},
+	{"blueprint", func() interface{} { return blueprint.BlueprintDB.Snapshot() }, false, nil, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*blueprint.Blueprint)
+		err := s.decode(&m)
+		return func() { blueprint.BlueprintDB.Replace(m) }, err
+	}},
+	{"project", func() interface{} { return project.ProjectDB.Snapshot() }, false, nil, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*project.Project)
+		err := s.decode(&m)
+		return func() { project.ProjectDB.Replace(m) }, err
+	}},
+	{"quota", func() interface{} { return quota.QuotaDB.Snapshot() }, false, nil, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*quota.Quota)
+		err := s.decode(&m)
+		return func() { quota.QuotaDB.Replace(m) }, err
+	}},
+	{"apitoken", func() interface{} { return auth.ApiTokenDB.Snapshot() }, false, nil, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*auth.ApiToken)
+		err := s.decode(&m)
+		return func() { auth.ApiTokenDB.Replace(m) }, err
+	}},
+	{"revocation", func() interface{} { return auth.RevocationDB.Snapshot() }, false, nil, func(s *Snapshot) (func(), error) {
+		m := make(map[string]*auth.Revocation)
+		err := s.decode(&m)
+		return func() { auth.RevocationDB.Replace(m) }, err
+	}},
+}
 
 type DB struct {
 }
@@ -39,9 +130,16 @@ func init() {
 	if config.DB.Format != "" {
 		format = config.DB.Format
 	}
+	if format == "gob" {
+		log.Printf("Db format gob replaced by json, gob files migrated on first load")
+		format = "json"
+	}
 	if config.DB.CompressPeriod > 0 {
 		dbCompressPeriod = config.DB.CompressPeriod
 	}
+	if config.DB.Generations > 0 {
+		generations = config.DB.Generations
+	}
 	if config.DB.SftpHost != "" {
 		sftpHost = config.DB.SftpHost
 	}
@@ -57,6 +155,49 @@ func init() {
 	if config.DB.SftpRemotePath != "" {
 		sftpRemotePath = config.DB.SftpRemotePath
 	}
+	if config.DB.SftpKeyFile != "" {
+		sftpKeyFile = config.DB.SftpKeyFile
+	}
+	if config.DB.BackupTarget != "" {
+		backupKind = config.DB.BackupTarget
+	}
+	if config.DB.BackupLocalPath != "" {
+		backupLocalPath = config.DB.BackupLocalPath
+	}
+	s3Endpoint = config.DB.S3Endpoint
+	s3Region = config.DB.S3Region
+	s3Bucket = config.DB.S3Bucket
+	s3Prefix = config.DB.S3Prefix
+	s3AccessKey = config.DB.S3AccessKey
+	s3SecretKey = config.DB.S3SecretKey
+	if config.DB.BackupKeepLast > 0 {
+		backupKeepLast = config.DB.BackupKeepLast
+	}
+	if config.DB.BackupKeepDays > 0 {
+		backupKeepDays = config.DB.BackupKeepDays
+	}
+	if config.DB.MasterKeyFile != "" {
+		masterKeyFile = config.DB.MasterKeyFile
+	}
+}
+
+//Take snapshot of table, secret fields encrypted
+func (t table) snapshot() (*Snapshot, error) {
+
+	snapshot, err := newSnapshot(t.data())
+	if err != nil {
+		return nil, err
+	}
+	if err := sealSecrets(t.name, t.secrets, snapshot); err != nil {
+		return nil, fmt.Errorf("encrypt secrets failed: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+//Closed once data loaded from db, used by who must wait for persisted data
+func Loaded() <-chan struct{} {
+	return loadedChan
 }
 
 //Send notfication to DB chan to sync up
@@ -88,44 +229,17 @@ func SaveToDB(ctx context.Context) {
 				return
 			case <-requestChan:
 				log.Println(time.Now())
-				if format == "json" {
-					err := utils.WriteJsonFile(".db/account.json", account.AccountDB.Map)
-					if err != nil {
-						log.Println(err)
-					} else {
-						log.Println("Saved to file db account.json")
-					}
-					err = utils.WriteJsonFile(".db/node.json", node.NodeDB.Map)
-					if err != nil {
-						log.Println(err)
-					} else {
-						log.Println("Saved to file db node.json")
-					}
-				} else {
-					err := utils.GobStoreToFile(".db/account.db", account.AccountDB.Map)
-					if err != nil {
-						log.Println(err)
-					} else {
-						log.Println("Saved to file db account.db")
-					}
-					err = utils.GobStoreToFile(".db/node.db", node.NodeDB.Map)
-					if err != nil {
-						log.Println(err)
-					} else {
-						log.Println("Saved to file db node.db")
-					}
-				}
-
+				saveTables()
 			}
 			t.Reset(period)
 		}
 	}
 }
 
-//Save db as gzip file then send to remote sftp server periodically
+//Save db as gzip file then send to remote backup target periodically
 func CompressAndSftp(ctx context.Context) {
 
-	log.Println("Be ready to compress db and send to remote sftp server periodically")
+	log.Printf("Be ready to compress db and send to %v backup target periodically", backupKind)
 
 	period := time.Duration(dbCompressPeriod) * time.Second
 	t := time.NewTimer(period)
@@ -138,41 +252,8 @@ func CompressAndSftp(ctx context.Context) {
 			v, _ := time.Now().MarshalText()
 			timeStamp := string(v)
 			log.Println(timeStamp, "will compress db and send to remote")
-			if format == "json" {
-				f, _ := os.Create(".db/account.json.gz")
-				w := gzip.NewWriter(f)
-				data, err := json.MarshalIndent(account.AccountDB.Map, "", "    ")
-				if err != nil {
-					log.Println(err)
-				}
-				_, err = w.Write(data)
-				if err != nil {
-					log.Println(err)
-				}
-				w.Close()
-				f.Close()
-
-				f, _ = os.Create(".db/node.json.gz")
-				w = gzip.NewWriter(f)
-				data, err = json.MarshalIndent(node.NodeDB.Map, "", "    ")
-				if err != nil {
-					log.Println(err)
-				}
-				_, err = w.Write(data)
-				if err != nil {
-					log.Println(err)
-				}
-				w.Close()
-				f.Close()
-
-				sc, err := NewConn(sftpHost, sftpUser, sftpPass, sftpPort)
-				if err != nil {
-					log.Println("sftp connection failed ", err)
-				} else {
-					sc.Put(".db/account.json.gz", sftpRemotePath+"/account.json.gz"+"-"+string(timeStamp))
-					sc.Put(".db/node.json.gz", sftpRemotePath+"/node.json.gz"+"-"+string(timeStamp))
-					sc.Close()
-				}
+			if err := uploadBackup(timeStamp); err != nil {
+				log.Printf("Backup db to remote failed with error: %v", err)
 			}
 			t.Reset(period)
 		}
@@ -181,43 +262,40 @@ func CompressAndSftp(ctx context.Context) {
 
 //Load data from database
 func LoadFromDB() {
-	if format == "json" {
-		accountData, err := utils.ReadJsonFile(".db/account.json")
-		if err == nil {
-			json.Unmarshal(accountData, &account.AccountDB.Map)
-		} else if strings.Contains(err.Error(), "The system cannot find the file specified") ||
-			strings.Contains(err.Error(), "no such file or directory") {
-			log.Println("account.json db file not found, no content will be loaded")
-		} else {
-			log.Fatalf("account.json DB file load failed with error: %v", err)
-		}
 
-		nodeData, err := utils.ReadJsonFile(".db/node.json")
-		if err == nil {
-			json.Unmarshal(nodeData, &node.NodeDB.Map)
-		} else if strings.Contains(err.Error(), "The system cannot find the file specified") ||
-			strings.Contains(err.Error(), "no such file or directory") {
-			log.Println("node.json db file not found, no content will be loaded")
-		} else {
-			log.Fatalf("node.json DB file load failed with error: %v", err)
+	var err error
+	store, err = NewStore(format, dbDir)
+	if err != nil {
+		log.Fatalf("DB store open failed with error: %v", err)
+	}
+
+	for _, t := range tables {
+		snapshot, err := store.Load(t.name)
+		if err == nil && snapshot == nil {
+			snapshot, err = loadGobTable(t)
 		}
-	} else {
-		err := utils.GobLoadFromFile(".db/account.db", &account.AccountDB.Map)
-		if err == nil {
-			log.Println("account.db DB file loaded")
-		} else if strings.Contains(err.Error(), "The system cannot find the file specified") {
-			log.Println("account.db db file not found, no content will be loaded")
-		} else {
-			log.Fatalf("account.db DB file load failed with error: %v", err)
+		if err != nil {
+			log.Fatalf("%v table load failed with error: %v", t.name, err)
+		} else if snapshot == nil {
+			log.Printf("%v table not found in %v db, no content will be loaded", t.name, format)
+			continue
 		}
-
-		err = utils.GobLoadFromFile(".db/node.db", &node.NodeDB.Map)
-		if err == nil {
-			log.Println("node.db DB file loaded")
-		} else if strings.Contains(err.Error(), "The system cannot find the file specified") {
-			log.Println("node.db db file not found, no content will be loaded")
-		} else {
-			log.Fatalf("node.db DB file load failed with error: %v", err)
+		upgraded := snapshot.Version != SchemaVersion
+		plain, err := openSecrets(t.name, t.secrets, snapshot)
+		if err != nil {
+			log.Fatalf("%v table secrets decrypt failed with error: %v", t.name, err)
+		}
+		if err := migrate(t.name, snapshot); err != nil {
+			log.Fatalf("%v table migration failed with error: %v", t.name, err)
+		}
+		swap, err := t.decode(snapshot)
+		if err != nil {
+			log.Fatalf("%v table decode failed with error: %v", t.name, err)
+		}
+		swap()
+		log.Printf("%v table loaded from %v db, schema version %v", t.name, format, snapshot.Version)
+		if upgraded || plain {
+			NotifyToSave()
 		}
 	}
 }
@@ -235,10 +313,15 @@ func (db DB) Control(ctx context.Context, wg *sync.WaitGroup) {
 	LoadFromDB()
 
 	account.AccountDB.InitializeAdmin()
+	close(loadedChan)
 
 	//Compress and send remote to save
 	go CompressAndSftp(ctx)
 
 	//for Loop to sync up db
 	SaveToDB(ctx)
+
+	if err := store.Close(); err != nil {
+		log.Println(err)
+	}
 }
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/JinlongWukong/DevLab/auth"
+	"github.com/JinlongWukong/DevLab/image"
+	"github.com/JinlongWukong/DevLab/task"
+	"github.com/JinlongWukong/DevLab/utils"
+	"github.com/dgrijalva/jwt-go"
+)
+
+//Tables are snapshotted while their maps and objects are changed, run with -race
+func TestSnapshotWhileTablesChanging(t *testing.T) {
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			myTask := task.NewTask(task.TaskKindCreateVm, "alice", "alice-1")
+			myTask.StartStep(task.StepSchedule, "")
+			myTask.EndStep(task.StepSchedule, "", nil)
+			myTask.Finish(nil)
+			auth.RevocationDB.Revoke(&auth.CustomClaims{Name: "alice", StandardClaims: jwt.StandardClaims{Id: utils.RandomString(12)}})
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		for _, table := range tables {
+			if _, err := table.snapshot(); err != nil {
+				t.Fatalf("snapshot of %v failed: %v", table.name, err)
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestLoadGobTable(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "devlab-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { dbDir = d }(dbDir)
+	dbDir = dir
+
+	var imageTable table
+	for _, table := range tables {
+		if table.name == "image" {
+			imageTable = table
+		}
+	}
+
+	legacy := map[string]*image.Image{"rocky8": {Name: "rocky8", OSFamily: "rocky", MinDisk: 20}}
+	if err := utils.GobStoreToFile(dir+"/image.db", legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := loadGobTable(imageTable)
+	if err != nil || snapshot == nil {
+		t.Fatalf("load gob table returned %v %v", snapshot, err)
+	}
+	if snapshot.Version != 0 {
+		t.Errorf("gob table loaded with version %v, expected 0", snapshot.Version)
+	}
+	loaded := make(map[string]*image.Image)
+	if err := snapshot.decode(&loaded); err != nil {
+		t.Fatal(err)
+	}
+	if myImage, exists := loaded["rocky8"]; exists == false || myImage.MinDisk != 20 {
+		t.Errorf("gob table loaded as %v", loaded)
+	}
+
+	//no legacy file
+	os.Remove(dir + "/image.db")
+	if snapshot, err := loadGobTable(imageTable); snapshot != nil || err != nil {
+		t.Errorf("load of missing gob table returned %v %v", snapshot, err)
+	}
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/JinlongWukong/DevLab/utils"
+)
+
+//Json file store, every table saved into <dir>/<table>.json
+//File is replaced atomically, previous generations kept as <table>.json.1 ... <table>.json.N
+type jsonStore struct {
+	dir         string
+	generations int
+}
+
+var _ Store = &jsonStore{}
+
+func newJsonStore(dir string, generations int) *jsonStore {
+	return &jsonStore{dir: dir, generations: generations}
+}
+
+func (s *jsonStore) path(table string) string {
+	return s.dir + "/" + table + ".json"
+}
+
+func (s *jsonStore) Save(table string, snapshot *Snapshot) error {
+
+	data, err := json.MarshalIndent(snapshot, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := s.rotate(s.path(table)); err != nil {
+		log.Printf("Rotate %v failed with error: %v", s.path(table), err)
+	}
+
+	return utils.WriteFileAtomic(s.path(table), data, 0644)
+}
+
+//Shift generations, current file is linked as generation 1 so that it is never missing
+func (s *jsonStore) rotate(path string) error {
+
+	if s.generations <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	for i := s.generations - 1; i >= 1; i-- {
+		older := fmt.Sprintf("%v.%v", path, i)
+		if _, err := os.Stat(older); err == nil {
+			if err := os.Rename(older, fmt.Sprintf("%v.%v", path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	os.Remove(path + ".1")
+	if err := os.Link(path, path+".1"); err != nil {
+		//hard link not supported, fallback to copy
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return utils.WriteFileAtomic(path+".1", data, 0644)
+	}
+
+	return nil
+}
+
+//Load latest readable generation
+func (s *jsonStore) Load(table string) (*Snapshot, error) {
+
+	paths := []string{s.path(table)}
+	for i := 1; i <= s.generations; i++ {
+		paths = append(paths, fmt.Sprintf("%v.%v", s.path(table), i))
+	}
+
+	var lastErr error
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		snapshot, err := readSnapshot(path)
+		if err == nil {
+			if path != s.path(table) {
+				log.Printf("Warning: %v table loaded from previous generation %v", table, path)
+			}
+			return snapshot, nil
+		}
+		log.Printf("Read %v failed with error: %v, try previous generation", path, err)
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+//Read snapshot file
+func readSnapshot(path string) (*Snapshot, error) {
+
+	data, err := utils.ReadJsonFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSnapshot(data)
+}
+
+//Parse snapshot, data without schema version is treated as legacy version 0
+func parseSnapshot(data []byte) (*Snapshot, error) {
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	_, hasVersion := fields["schemaVersion"]
+	_, hasData := fields["data"]
+	_, hasDataKey := fields["dataKey"]
+	snapshot := &Snapshot{Version: 0, Data: fields}
+	if hasVersion && hasData && (len(fields) == 2 || len(fields) == 3 && hasDataKey) {
+		snapshot = &Snapshot{}
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			return nil, err
+		}
+	}
+	if snapshot.Data == nil {
+		snapshot.Data = make(map[string]json.RawMessage)
+	}
+
+	//objects are kept compact, same as newly taken snapshot
+	for k, v := range snapshot.Data {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, v); err != nil {
+			return nil, err
+		}
+		snapshot.Data[k] = buf.Bytes()
+	}
+
+	return snapshot, nil
+}
+
+func (s *jsonStore) Close() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/JinlongWukong/DevLab/utils"
+)
+
+//Local directory backup target, e.g. a mounted nfs or another disk
+type localTarget struct {
+	path string
+}
+
+var _ BackupTarget = &localTarget{}
+
+func newLocalTarget(path string) (*localTarget, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+	return &localTarget{path: path}, nil
+}
+
+func (t *localTarget) Put(name string, data []byte) error {
+	return utils.WriteFileAtomic(filepath.Join(t.path, name), data, 0600)
+}
+
+func (t *localTarget) Get(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(t.path, name))
+}
+
+func (t *localTarget) List() ([]BackupFile, error) {
+	infos, err := ioutil.ReadDir(t.path)
+	if err != nil {
+		return nil, err
+	}
+	files := []BackupFile{}
+	for _, info := range infos {
+		if info.IsDir() == false {
+			files = append(files, BackupFile{Name: info.Name(), Size: info.Size()})
+		}
+	}
+	return files, nil
+}
+
+func (t *localTarget) Delete(name string) error {
+	return os.Remove(filepath.Join(t.path, name))
+}
+
+func (t *localTarget) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+)
+
+//Current schema version of persisted data
+//Increase it whenever persisted fields of account/vm/node... changed, and register a migration
+//to upgrade objects saved by older version, otherwise they will be silently mis-decoded
+//  0 -> legacy data saved without version
+//  1 -> data saved with version
+const SchemaVersion = 1
+
+//Migration upgrades every object of table to given version
+//Object is decoded as generic map, numbers kept as json.Number to avoid losing precision
+type Migration struct {
+	Table       string
+	Version     int
+	Description string
+	Migrate     func(name string, object map[string]interface{}) error
+}
+
+var migrations = []Migration{}
+
+//Register a migration, normally called in init()
+func RegisterMigration(m Migration) {
+
+	if m.Version <= 0 || m.Version > SchemaVersion {
+		log.Fatalf("Migration %v of table %v has invalid version %v", m.Description, m.Table, m.Version)
+	}
+	migrations = append(migrations, m)
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+}
+
+//Upgrade snapshot of table to current schema version
+func migrate(table string, snapshot *Snapshot) error {
+
+	if snapshot.Version > SchemaVersion {
+		return fmt.Errorf("%v table schema version %v is newer than supported version %v", table, snapshot.Version, SchemaVersion)
+	}
+
+	for _, m := range migrations {
+		if m.Table != table || m.Version <= snapshot.Version {
+			continue
+		}
+		log.Printf("Migrate %v table to version %v: %v", table, m.Version, m.Description)
+		for name, data := range snapshot.Data {
+			object := make(map[string]interface{})
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			if err := decoder.Decode(&object); err != nil {
+				return fmt.Errorf("decode %v %v failed: %v", table, name, err)
+			}
+			if err := m.Migrate(name, object); err != nil {
+				return fmt.Errorf("migrate %v %v to version %v failed: %v", table, name, m.Version, err)
+			}
+			newData, err := json.Marshal(object)
+			if err != nil {
+				return err
+			}
+			snapshot.Data[name] = newData
+		}
+	}
+	if snapshot.Version != SchemaVersion {
+		log.Printf("%v table upgraded from schema version %v to %v", table, snapshot.Version, SchemaVersion)
+		snapshot.Version = SchemaVersion
+	}
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Prefix of encrypted field value: enc:v1:<base64(nonce|ciphertext)>
+const secretPrefix = "enc:v1:"
+
+//Envelope encryption of secret fields
+//  master key -> from env DEVLAB_MASTER_KEY or file given by env DEVLAB_MASTER_KEY_FILE/config MasterKeyFile,
+//                base64 of 32 bytes used as is, any other content is treated as passphrase and hashed by sha256
+//  data key   -> random key encrypting secret fields, saved wrapped by master key along with table snapshot
+//Secrets are kept in plain text if no master key given
+var keyring = struct {
+	masterKey   []byte
+	dataKey     []byte
+	wrappedKey  string
+	unwrapped   map[string][]byte
+	sealed      map[string]map[string]string
+	initialized bool
+	sync.Mutex
+}{
+	unwrapped: make(map[string][]byte),
+	sealed:    make(map[string]map[string]string),
+}
+
+//Read master key, empty if not configured
+func readMasterKey() ([]byte, error) {
+
+	content := os.Getenv("DEVLAB_MASTER_KEY")
+	if content == "" {
+		path := os.Getenv("DEVLAB_MASTER_KEY_FILE")
+		if path == "" {
+			path = masterKeyFile
+		}
+		if path == "" {
+			return nil, nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read master key file failed: %v", err)
+		}
+		content = string(data)
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, fmt.Errorf("master key is empty")
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(content); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	key := sha256.Sum256([]byte(content))
+	return key[:], nil
+}
+
+//Load master key once, must be called with keyring locked
+func initSecrets() error {
+
+	if keyring.initialized {
+		return nil
+	}
+	key, err := readMasterKey()
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		log.Println("Warning: no master key configured, secrets will be saved in plain text")
+	}
+	keyring.masterKey = key
+	keyring.initialized = true
+
+	return nil
+}
+
+func aesGcmSeal(key, plain []byte) (string, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func aesGcmOpen(key []byte, sealed string) ([]byte, error) {
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+//Unwrap data key saved along with snapshot, must be called with keyring locked
+func unwrapDataKey(wrapped string) ([]byte, error) {
+
+	if key, exists := keyring.unwrapped[wrapped]; exists {
+		return key, nil
+	}
+	if keyring.masterKey == nil {
+		return nil, fmt.Errorf("data is encrypted but no master key configured")
+	}
+	key, err := aesGcmOpen(keyring.masterKey, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("data key can't be unwrapped, master key mismatch: %v", err)
+	}
+	keyring.unwrapped[wrapped] = key
+
+	return key, nil
+}
+
+//Current data key, generated at first use, must be called with keyring locked
+func currentDataKey() ([]byte, string, error) {
+
+	if keyring.dataKey == nil {
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, "", err
+		}
+		wrapped, err := aesGcmSeal(keyring.masterKey, key)
+		if err != nil {
+			return nil, "", err
+		}
+		keyring.dataKey, keyring.wrappedKey = key, wrapped
+		keyring.unwrapped[wrapped] = key
+		log.Println("New data key generated for secrets encryption")
+	}
+
+	return keyring.dataKey, keyring.wrappedKey, nil
+}
+
+//Walk secret field of object by path, "*" matches every element of array
+//visit returns new value of string field
+func walkSecret(v interface{}, path []string, visit func(string) (string, error)) (interface{}, error) {
+
+	if len(path) == 0 {
+		if s, ok := v.(string); ok && s != "" {
+			return visit(s)
+		}
+		return v, nil
+	}
+
+	var err error
+	switch node := v.(type) {
+	case map[string]interface{}:
+		if child, exists := node[path[0]]; exists {
+			node[path[0]], err = walkSecret(child, path[1:], visit)
+		}
+	case []interface{}:
+		for i := range node {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				if node[i], err = walkSecret(node[i], path[1:], visit); err != nil {
+					break
+				}
+			}
+		}
+	}
+
+	return v, err
+}
+
+//Apply visit to every secret field of every object in snapshot
+func visitSecrets(table string, fields []string, snapshot *Snapshot, visit func(name, value string) (string, error)) error {
+
+	for name, data := range snapshot.Data {
+		var object interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&object); err != nil {
+			return fmt.Errorf("decode %v %v failed: %v", table, name, err)
+		}
+		changed := false
+		for _, field := range fields {
+			_, err := walkSecret(object, strings.Split(field, "."), func(value string) (string, error) {
+				newValue, err := visit(name, value)
+				changed = changed || newValue != value
+				return newValue, err
+			})
+			if err != nil {
+				return fmt.Errorf("%v %v field %v: %v", table, name, field, err)
+			}
+		}
+		if changed {
+			newData, err := json.Marshal(object)
+			if err != nil {
+				return err
+			}
+			snapshot.Data[name] = newData
+		}
+	}
+
+	return nil
+}
+
+//Encrypt secret fields of snapshot
+//Ciphertext of unchanged secret is reused, so that unchanged objects are saved byte by byte same
+func sealSecrets(table string, fields []string, snapshot *Snapshot) error {
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	keyring.Lock()
+	defer keyring.Unlock()
+
+	if err := initSecrets(); err != nil {
+		return err
+	}
+	if keyring.masterKey == nil {
+		return nil
+	}
+	key, wrapped, err := currentDataKey()
+	if err != nil {
+		return err
+	}
+
+	last := keyring.sealed[table]
+	sealed := make(map[string]string)
+	err = visitSecrets(table, fields, snapshot, func(name, value string) (string, error) {
+		cacheKey := name + "\x00" + value
+		ciphertext, exists := last[cacheKey]
+		if exists == false {
+			encrypted, err := aesGcmSeal(key, []byte(value))
+			if err != nil {
+				return "", err
+			}
+			ciphertext = secretPrefix + encrypted
+		}
+		sealed[cacheKey] = ciphertext
+		return ciphertext, nil
+	})
+	if err != nil {
+		return err
+	}
+	keyring.sealed[table] = sealed
+	snapshot.DataKey = wrapped
+
+	return nil
+}
+
+//Decrypt secret fields of snapshot
+//Return:
+//  plain -> true if any secret found in plain text while master key configured, table should be saved again
+func openSecrets(table string, fields []string, snapshot *Snapshot) (bool, error) {
+
+	if len(fields) == 0 {
+		return false, nil
+	}
+
+	keyring.Lock()
+	defer keyring.Unlock()
+
+	if err := initSecrets(); err != nil {
+		return false, err
+	}
+
+	var key []byte
+	if snapshot.DataKey != "" {
+		var err error
+		if key, err = unwrapDataKey(snapshot.DataKey); err != nil {
+			return false, err
+		}
+		//keep using data key of loaded data, so that unchanged secrets needn't be encrypted again
+		if keyring.dataKey == nil {
+			keyring.dataKey, keyring.wrappedKey = key, snapshot.DataKey
+		}
+	}
+	reuse := key != nil && bytes.Equal(key, keyring.dataKey)
+
+	plain := false
+	sealed := make(map[string]string)
+	err := visitSecrets(table, fields, snapshot, func(name, value string) (string, error) {
+		if strings.HasPrefix(value, secretPrefix) == false {
+			plain = plain || keyring.masterKey != nil
+			return value, nil
+		}
+		if key == nil {
+			return "", fmt.Errorf("encrypted value found but snapshot has no data key")
+		}
+		data, err := aesGcmOpen(key, strings.TrimPrefix(value, secretPrefix))
+		if err != nil {
+			return "", fmt.Errorf("decrypt failed: %v", err)
+		}
+		sealed[name+"\x00"+string(data)] = value
+		return string(data), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if reuse {
+		keyring.sealed[table] = sealed
+	}
+	snapshot.DataKey = ""
+
+	return plain, nil
+}
@@ -4,28 +4,36 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/JinlongWukong/DevLab/hostkey"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
 type sftpClient struct {
-	host, user, password string
-	port                 int
+	host, user, password, keyFile string
+	port                          int
+	conn                          *ssh.Client
 	*sftp.Client
 }
 
 // Create a new SFTP connection by given parameters
 func NewConn(host, user, password string, port int) (client *sftpClient, err error) {
+	return NewConnWithKey(host, user, password, "", port)
+}
+
+// Create a new SFTP connection, private key file used to login if given, password used otherwise
+func NewConnWithKey(host, user, password, keyFile string, port int) (client *sftpClient, err error) {
 	switch {
 	case
 		`` == strings.TrimSpace(host),
 		`` == strings.TrimSpace(user),
-		`` == strings.TrimSpace(password),
+		`` == strings.TrimSpace(password) && `` == strings.TrimSpace(keyFile),
 		0 >= port || port > 65535:
 		return nil, errors.New("Invalid parameters")
 	}
@@ -34,6 +42,7 @@ func NewConn(host, user, password string, port int) (client *sftpClient, err err
 		host:     host,
 		user:     user,
 		password: password,
+		keyFile:  keyFile,
 		port:     port,
 	}
 
@@ -44,16 +53,34 @@ func NewConn(host, user, password string, port int) (client *sftpClient, err err
 }
 
 func (sc *sftpClient) connect() (err error) {
+	auth := []ssh.AuthMethod{}
+	if sc.keyFile != "" {
+		key, err := ioutil.ReadFile(sc.keyFile)
+		if err != nil {
+			return err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if sc.password != "" {
+		auth = append(auth, ssh.Password(sc.password))
+	}
+
 	config := &ssh.ClientConfig{
 		User:            sc.user,
-		Auth:            []ssh.AuthMethod{ssh.Password(sc.password)},
+		Auth:            auth,
 		Timeout:         30 * time.Second,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostkey.Callback("sftp backup target"),
 	}
 
 	// connet to ssh
 	addr := fmt.Sprintf("%s:%d", sc.host, sc.port)
 	conn, err := ssh.Dial("tcp", addr, config)
+	//host key may be recorded on first contact
+	NotifyToSave()
 	if err != nil {
 		return err
 	}
@@ -61,13 +88,24 @@ func (sc *sftpClient) connect() (err error) {
 	// create sftp client
 	client, err := sftp.NewClient(conn)
 	if err != nil {
+		conn.Close()
 		return err
 	}
+	sc.conn = conn
 	sc.Client = client
 
 	return nil
 }
 
+// Close sftp session and the underlying ssh connection
+func (sc *sftpClient) Close() error {
+	err := sc.Client.Close()
+	if cerr := sc.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 // Upload file to sftp server
 func (sc *sftpClient) Put(localFile, remoteFile string) (err error) {
 	srcFile, err := os.Open(localFile)
@@ -112,3 +150,104 @@ func (sc *sftpClient) Get(remoteFile, localFile string) (err error) {
 	_, err = io.Copy(dstFile, srcFile)
 	return
 }
+
+//Sftp backup target, connection opened on first operation and reused until target closed
+type sftpTarget struct {
+	host, user, password, keyFile, path string
+	port                                int
+	client                              *sftpClient
+}
+
+var _ BackupTarget = &sftpTarget{}
+
+func newSftpTarget(host, user, password, keyFile string, port int, path string) *sftpTarget {
+	return &sftpTarget{
+		host:     host,
+		user:     user,
+		password: password,
+		keyFile:  keyFile,
+		port:     port,
+		path:     path,
+	}
+}
+
+func (t *sftpTarget) conn() (*sftpClient, error) {
+	if t.client != nil {
+		return t.client, nil
+	}
+	sc, err := NewConnWithKey(t.host, t.user, t.password, t.keyFile, t.port)
+	if err != nil {
+		return nil, fmt.Errorf("sftp connection failed: %v", err)
+	}
+	t.client = sc
+	return sc, nil
+}
+
+func (t *sftpTarget) Close() error {
+	if t.client == nil {
+		return nil
+	}
+	err := t.client.Close()
+	t.client = nil
+	return err
+}
+
+func (t *sftpTarget) Put(name string, data []byte) error {
+	sc, err := t.conn()
+	if err != nil {
+		return err
+	}
+
+	sc.MkdirAll(t.path)
+	f, err := sc.Create(t.path + "/" + name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	return err
+}
+
+func (t *sftpTarget) Get(name string) ([]byte, error) {
+	sc, err := t.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := sc.Open(t.path + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+func (t *sftpTarget) List() ([]BackupFile, error) {
+	sc, err := t.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := sc.ReadDir(t.path)
+	if err != nil {
+		return nil, err
+	}
+	files := []BackupFile{}
+	for _, info := range infos {
+		if info.IsDir() == false {
+			files = append(files, BackupFile{Name: info.Name(), Size: info.Size()})
+		}
+	}
+	return files, nil
+}
+
+func (t *sftpTarget) Delete(name string) error {
+	sc, err := t.conn()
+	if err != nil {
+		return err
+	}
+
+	return sc.Remove(t.path + "/" + name)
+}
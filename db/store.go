@@ -0,0 +1,93 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/JinlongWukong/DevLab/utils"
+)
+
+//Persistent backend of db
+//Data is organized as tables, each table is a map of object name -> object
+type Store interface {
+	//Save snapshot of table, backend may skip objects not changed since last save
+	Save(table string, snapshot *Snapshot) error
+	//Load snapshot of table, nil returned if table never saved
+	Load(table string) (*Snapshot, error)
+	Close() error
+}
+
+//Snapshot of one table, objects are kept encoded so that migrations can be applied before decoding
+//DataKey is the data key wrapped by master key, set if secret fields of objects are encrypted
+type Snapshot struct {
+	Version int                        `json:"schemaVersion"`
+	DataKey string                     `json:"dataKey,omitempty"`
+	Data    map[string]json.RawMessage `json:"data"`
+}
+
+//New store by format
+//  json -> one json file per table, whole table rewritten every save
+//  bolt -> embedded bbolt key/value file, one bucket per table, one key per object
+func NewStore(format, dir string) (Store, error) {
+	switch format {
+	case "json":
+		return newJsonStore(dir, generations), nil
+	case "bolt":
+		return newBoltStore(dir + "/devlab.bolt")
+	default:
+		return nil, fmt.Errorf("db format %v not supported", format)
+	}
+}
+
+//Load table from legacy gob file(<table>.db) written before store introduced, nil returned if file not found
+//Gob data is unversioned, so it goes through all migrations, and saved into store after loaded
+func loadGobTable(t table) (*Snapshot, error) {
+
+	file := filepath.Join(dbDir, t.name+".db")
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil, nil
+	}
+	//gob decoded into a new map of same type as table
+	data := reflect.New(reflect.TypeOf(t.data())).Interface()
+	if err := utils.GobLoadFromFile(file, data); err != nil {
+		return nil, err
+	}
+	snapshot, err := newSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Version = 0
+	log.Printf("%v table loaded from legacy gob file %v, it can be removed once saved to %v db", t.name, file, format)
+
+	return snapshot, nil
+}
+
+//Take snapshot of table with current schema version
+func newSnapshot(v interface{}) (*Snapshot, error) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	objects := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, fmt.Errorf("table is not a map: %v", err)
+	}
+
+	return &Snapshot{Version: SchemaVersion, Data: objects}, nil
+}
+
+//Decode snapshot objects into table
+func (s *Snapshot) decode(v interface{}) error {
+
+	data, err := json.Marshal(s.Data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -9,10 +9,21 @@ import (
 )
 
 var baseUrl string
+var kind = "http"
+var client Client
 
 //Load deployer info from ENV first, if not ok, then load from config.ini
 func init() {
 
+	if config.Deployer.Kind != "" {
+		kind = config.Deployer.Kind
+	}
+	if kind == "fake" {
+		log.Println("Using in-memory fake deployer, no remote call will be sent")
+		client = NewFakeClient()
+		return
+	}
+
 	regUrl, _ := regexp.Compile("^http[s]?://[[:ascii:]]*:\\d*$")
 
 	envUrl := os.Getenv("DEPLOYER_PROTOCOL") + "://" + os.Getenv("DEPLOYER_HOST") + ":" + os.Getenv("DEPLOYER_PORT")
@@ -20,6 +31,7 @@ func init() {
 	if match {
 		log.Printf("Using deployer url from environment %v", envUrl)
 		baseUrl = envUrl
+		client = newHttpClient(baseUrl)
 		return
 	}
 
@@ -28,11 +40,23 @@ func init() {
 	if match {
 		baseUrl = config.Deployer.Protocol + "://" + config.Deployer.EndPoint
 		log.Printf("Using deployer url from config.ini %v", baseUrl)
+		client = newHttpClient(baseUrl)
 		return
 	}
 	log.Println("deployer url load failed")
+	client = newHttpClient(baseUrl)
 }
 
 func GetDeployerBaseUrl() string {
 	return baseUrl
 }
+
+//Get deployer client
+func GetClient() Client {
+	return client
+}
+
+//Replace deployer client, e.g. inject a fake one
+func SetClient(c Client) {
+	client = c
+}
@@ -0,0 +1,596 @@
+package deployer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Resources reported by fake deployer for every installed host
+const (
+	fakeHostCPU    = 32
+	fakeHostMemory = 65536
+	fakeHostDisk   = 1024000
+	fakeHostOS     = "centos7"
+)
+
+type fakeHost struct {
+	host       Host
+	subnet     *net.IPNet
+	lastIp     int
+	vncIndex   int
+	dnat       map[string]DnatRule
+	vms        map[string]*fakeVm
+	containers map[string]*fakeContainer
+}
+
+type fakeVm struct {
+	spec      VmSpec
+	status    string
+	address   string
+	vncPort   string
+	addons    []string
+	snapshots map[string]int32
+}
+
+type fakeContainer struct {
+	spec    ContainerSpec
+	status  string
+	address string
+	ports   []string
+}
+
+//In-memory deployer, keeps hosts/vms/containers state instead of calling remote deployer
+//Used to run whole controller locally or drive workflow in tests
+type FakeClient struct {
+	hosts  map[string]*fakeHost
+	routes []Route
+	k8s    map[string]uint16
+	lock   sync.Mutex
+}
+
+var _ Client = &FakeClient{}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		hosts: make(map[string]*fakeHost),
+		k8s:   make(map[string]uint16),
+	}
+}
+
+func (f *FakeClient) getHost(ip string) (*fakeHost, error) {
+	h, exists := f.hosts[ip]
+	if exists == false {
+		return nil, fmt.Errorf("host %v not installed", ip)
+	}
+	return h, nil
+}
+
+//Allocate next address from host subnet
+func (h *fakeHost) allocateIp() (string, error) {
+	ones, bits := h.subnet.Mask.Size()
+	if h.lastIp+1 >= 1<<uint(bits-ones)-1 {
+		return "", fmt.Errorf("no address left in subnet %v", h.subnet)
+	}
+	h.lastIp++
+
+	ip := make(net.IP, len(h.subnet.IP))
+	copy(ip, h.subnet.IP)
+	carry := h.lastIp
+	for i := len(ip) - 1; i >= 0 && carry > 0; i-- {
+		sum := int(ip[i]) + carry
+		ip[i] = byte(sum % 256)
+		carry = sum / 256
+	}
+	return ip.String(), nil
+}
+
+//Find vm by dnat port on host, used by operations which login vm through node ip:port
+func (f *FakeClient) getVmByTarget(target Host) (*fakeVm, error) {
+	h, err := f.getHost(target.Ip)
+	if err != nil {
+		return nil, err
+	}
+	rule, exists := h.dnat[target.Port]
+	if exists == false || rule.State != DnatStatePresent {
+		return nil, fmt.Errorf("no dnat rule on %v:%v", target.Ip, target.Port)
+	}
+	for _, v := range h.vms {
+		if strings.Split(v.address, "/")[0]+":22" == rule.Destination {
+			if v.status != "running" {
+				return nil, fmt.Errorf("vm %v is %v", v.spec.Name, v.status)
+			}
+			if target.Pass != v.spec.RootPass {
+				return nil, fmt.Errorf("vm %v authentication failed", v.spec.Name)
+			}
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("dnat destination %v not reachable", rule.Destination)
+}
+
+func (f *FakeClient) InstallHost(host Host, subnet string) (HostInfo, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return HostInfo{}, fmt.Errorf("invalid subnet %v: %v", subnet, err)
+	}
+
+	if _, exists := f.hosts[host.Ip]; exists == false {
+		f.hosts[host.Ip] = &fakeHost{
+			subnet:     ipNet,
+			lastIp:     1,
+			dnat:       make(map[string]DnatRule),
+			vms:        make(map[string]*fakeVm),
+			containers: make(map[string]*fakeContainer),
+		}
+	}
+	f.hosts[host.Ip].host = host
+	log.Printf("Fake deployer installed host %v", host.Ip)
+
+	return HostInfo{
+		CPU:    fakeHostCPU,
+		Memory: fakeHostMemory,
+		Disk:   fakeHostDisk,
+		OSType: fakeHostOS,
+	}, nil
+}
+
+func (f *FakeClient) GetHostCondition(host Host) (HostCondition, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	h, err := f.getHost(host.Ip)
+	if err != nil {
+		return HostCondition{}, err
+	}
+
+	var cpu, mem int32
+	for _, v := range h.vms {
+		if v.status == "running" {
+			cpu += v.spec.CPU
+			mem += v.spec.Memory
+		}
+	}
+	for _, c := range h.containers {
+		if c.status == "running" {
+			cpu += int32(c.spec.CPU)
+			mem += int32(c.spec.Memory)
+		}
+	}
+
+	return HostCondition{
+		CpuLoad:   float64(cpu) / 10,
+		MemAvail:  int(fakeHostMemory - mem),
+		DiskUsage: "10%",
+		Engine:    0,
+	}, nil
+}
+
+func (f *FakeClient) UpdateRoutes(hosts []Host, routes []Route) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, h := range hosts {
+		if _, err := f.getHost(h.Ip); err != nil {
+			return err
+		}
+	}
+	f.routes = routes
+
+	return nil
+}
+
+func (f *FakeClient) ActionDnat(host Host, rules []DnatRule) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	h, err := f.getHost(host.Ip)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		switch r.State {
+		case DnatStatePresent:
+			h.dnat[r.Dport] = r
+		case DnatStateAbsent:
+			delete(h.dnat, r.Dport)
+		default:
+			return fmt.Errorf("dnat state %v not supported", r.State)
+		}
+	}
+
+	return nil
+}
+
+func (f *FakeClient) CreateVm(host Host, spec VmSpec) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	h, err := f.getHost(host.Ip)
+	if err != nil {
+		return err
+	}
+	if _, exists := h.vms[spec.Name]; exists {
+		return fmt.Errorf("vm %v already existed", spec.Name)
+	}
+
+	ip, err := h.allocateIp()
+	if err != nil {
+		return err
+	}
+	ones, _ := h.subnet.Mask.Size()
+	h.vncIndex++
+
+	h.vms[spec.Name] = &fakeVm{
+		spec:      spec,
+		status:    "running",
+		address:   ip + "/" + strconv.Itoa(ones),
+		vncPort:   ":" + strconv.Itoa(h.vncIndex),
+		snapshots: make(map[string]int32),
+	}
+	log.Printf("Fake deployer created vm %v on host %v", spec.Name, host.Ip)
+
+	return nil
+}
+
+func (f *FakeClient) ActionVm(host Host, name string, action VmAction) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	h, err := f.getHost(host.Ip)
+	if err != nil {
+		return err
+	}
+	v, exists := h.vms[name]
+	if exists == false {
+		return fmt.Errorf("vm %v not found", name)
+	}
+
+	switch action {
+	case VmActionStart, VmActionReboot:
+		v.status = "running"
+	case VmActionShutdown:
+		v.status = "shutoff"
+	case VmActionDelete:
+		//same as libvirt, vm with snapshots can't be undefined
+		if len(v.snapshots) > 0 {
+			return fmt.Errorf("vm %v still has %v snapshots", name, len(v.snapshots))
+		}
+		delete(h.vms, name)
+	default:
+		return fmt.Errorf("vm action %v not supported", action)
+	}
+
+	return nil
+}
+
+//Same as libvirt, cpu/memory only changeable while vm shut off, disk can only grow
+func (f *FakeClient) ResizeVm(host Host, name string, cpu, memory, disk int32) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	h, err := f.getHost(host.Ip)
+	if err != nil {
+		return err
+	}
+	v, exists := h.vms[name]
+	if exists == false {
+		return fmt.Errorf("vm %v not found", name)
+	}
+
+	if (cpu != v.spec.CPU || memory != v.spec.Memory) && v.status != "shutoff" {
+		return fmt.Errorf("vm %v must be shut off to change cpu/memory", name)
+	}
+	if disk < v.spec.Disk {
+		return fmt.Errorf("disk of vm %v can't be shrunk", name)
+	}
+	if disk != v.spec.Disk && len(v.snapshots) > 0 {
+		return fmt.Errorf("disk of vm %v with snapshots can't be resized", name)
+	}
+	v.spec.CPU, v.spec.Memory, v.spec.Disk = cpu, memory, disk
+	log.Printf("Fake deployer resized vm %v on host %v to cpu %v, memory %v, disk %v", name, host.Ip, cpu, memory, disk)
+
+	return nil
+}
+
+//Vm gets new address and vnc port on target host, live migration keeps it running
+func (f *FakeClient) MigrateVm(source, target Host, name string, live bool) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	src, err := f.getHost(source.Ip)
+	if err != nil {
+		return err
+	}
+	dst, err := f.getHost(target.Ip)
+	if err != nil {
+		return err
+	}
+	v, exists := src.vms[name]
+	if exists == false {
+		return fmt.Errorf("vm %v not found", name)
+	}
+	if _, exists := dst.vms[name]; exists {
+		return fmt.Errorf("vm %v already existed on host %v", name, target.Ip)
+	}
+	if live && v.status != "running" {
+		return fmt.Errorf("vm %v is %v, live migration needs it running", name, v.status)
+	}
+	if live == false && v.status != "shutoff" {
+		return fmt.Errorf("vm %v must be shut off for cold migration", name)
+	}
+	if len(v.snapshots) > 0 {
+		return fmt.Errorf("vm %v with snapshots can't be migrated", name)
+	}
+
+	ip, err := dst.allocateIp()
+	if err != nil {
+		return err
+	}
+	ones, _ := dst.subnet.Mask.Size()
+	dst.vncIndex++
+	v.address = ip + "/" + strconv.Itoa(ones)
+	v.vncPort = ":" + strconv.Itoa(dst.vncIndex)
+	dst.vms[name] = v
+	delete(src.vms, name)
+	log.Printf("Fake deployer migrated vm %v from host %v to %v", name, source.Ip, target.Ip)
+
+	return nil
+}
+
+//Clone gets own address and vnc port, addons installed on source disk are kept
+//Same as libvirt, disk copied only from shut off vm unless snapshot given
+func (f *FakeClient) CloneVm(source, target Host, name, snapshot string, spec VmSpec) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	src, err := f.getHost(source.Ip)
+	if err != nil {
+		return err
+	}
+	dst, err := f.getHost(target.Ip)
+	if err != nil {
+		return err
+	}
+	v, exists := src.vms[name]
+	if exists == false {
+		return fmt.Errorf("vm %v not found", name)
+	}
+	if _, exists := dst.vms[spec.Name]; exists {
+		return fmt.Errorf("vm %v already existed", spec.Name)
+	}
+	if snapshot != "" {
+		if _, exists := v.snapshots[snapshot]; exists == false {
+			return fmt.Errorf("snapshot %v of vm %v not found", snapshot, name)
+		}
+	} else if v.status != "shutoff" {
+		return fmt.Errorf("vm %v must be shut off to copy its disk", name)
+	}
+	if spec.Disk < v.spec.Disk {
+		return fmt.Errorf("disk of clone %v smaller than vm %v", spec.Name, name)
+	}
+
+	ip, err := dst.allocateIp()
+	if err != nil {
+		return err
+	}
+	ones, _ := dst.subnet.Mask.Size()
+	dst.vncIndex++
+	dst.vms[spec.Name] = &fakeVm{
+		spec:      spec,
+		status:    "running",
+		address:   ip + "/" + strconv.Itoa(ones),
+		vncPort:   ":" + strconv.Itoa(dst.vncIndex),
+		addons:    append([]string{}, v.addons...),
+		snapshots: make(map[string]int32),
+	}
+	log.Printf("Fake deployer cloned vm %v from %v on host %v to %v", spec.Name, name, source.Ip, target.Ip)
+
+	return nil
+}
+
+func (f *FakeClient) GetVmStatus(host Host, name string) (VmStatus, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	h, err := f.getHost(host.Ip)
+	if err != nil {
+		return VmStatus{}, err
+	}
+	v, exists := h.vms[name]
+	if exists == false {
+		return VmStatus{Name: name, Status: "deleted"}, nil
+	}
+
+	return VmStatus{
+		Name:    name,
+		Status:  v.status,
+		Address: v.address,
+		VncPort: v.vncPort,
+	}, nil
+}
+
+//Snapshot size is a tenth of vm disk
+func (f *FakeClient) ActionSnapshot(host Host, name, snapshot string, action SnapshotAction) (SnapshotInfo, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	h, err := f.getHost(host.Ip)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	v, exists := h.vms[name]
+	if exists == false {
+		return SnapshotInfo{}, fmt.Errorf("vm %v not found", name)
+	}
+	size, existed := v.snapshots[snapshot]
+
+	switch action {
+	case SnapshotActionCreate:
+		if existed {
+			return SnapshotInfo{}, fmt.Errorf("snapshot %v of vm %v already existed", snapshot, name)
+		}
+		size = v.spec.Disk * 1024 / 10
+		v.snapshots[snapshot] = size
+	case SnapshotActionRevert:
+		if existed == false {
+			return SnapshotInfo{}, fmt.Errorf("snapshot %v of vm %v not found", snapshot, name)
+		}
+		v.status = "running"
+	case SnapshotActionDelete:
+		if existed == false {
+			return SnapshotInfo{}, fmt.Errorf("snapshot %v of vm %v not found", snapshot, name)
+		}
+		delete(v.snapshots, snapshot)
+	default:
+		return SnapshotInfo{}, fmt.Errorf("snapshot action %v not supported", action)
+	}
+
+	return SnapshotInfo{Name: snapshot, Size: size}, nil
+}
+
+func (f *FakeClient) InstallAddons(target Host, addons []string) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, err := f.getVmByTarget(target)
+	if err != nil {
+		return err
+	}
+	v.addons = append(v.addons, addons...)
+
+	return nil
+}
+
+func (f *FakeClient) InstallK8s(target Host, controller, worker uint16) error {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, err := f.getVmByTarget(target)
+	if err != nil {
+		return err
+	}
+	f.k8s[v.spec.Name] = controller + worker
+
+	return nil
+}
+
+func (f *FakeClient) CreateContainer(host Host, spec ContainerSpec) (ContainerInfo, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	h, err := f.getHost(host.Ip)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	if _, exists := h.containers[spec.Name]; exists {
+		return ContainerInfo{}, fmt.Errorf("container %v already existed", spec.Name)
+	}
+
+	ip, err := h.allocateIp()
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	c := &fakeContainer{
+		spec:    spec,
+		status:  "running",
+		address: ip,
+		ports:   []string{fmt.Sprintf("%v/tcp -> 0.0.0.0:%v", 10000+h.lastIp, 40000+h.lastIp)},
+	}
+	h.containers[spec.Name] = c
+
+	return c.info(), nil
+}
+
+func (f *FakeClient) ActionContainer(host Host, name, software, action string) (ContainerInfo, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	h, err := f.getHost(host.Ip)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	c, exists := h.containers[name]
+	if exists == false {
+		return ContainerInfo{Status: "deleted"}, nil
+	}
+
+	switch action {
+	case "start", "restart":
+		c.status = "running"
+	case "stop":
+		c.status = "stopped"
+	case "delete":
+		delete(h.containers, name)
+		return ContainerInfo{Status: "deleted"}, nil
+	case "get":
+	default:
+		return ContainerInfo{}, fmt.Errorf("container action %v not supported", action)
+	}
+
+	return c.info(), nil
+}
+
+func (c *fakeContainer) info() ContainerInfo {
+	info := ContainerInfo{
+		Status:          c.status,
+		AdditionalInfor: map[string]string{},
+	}
+	if c.status == "running" {
+		info.Address = c.address
+		info.PortMapping = c.ports
+	}
+	return info
+}
+
+//Get all vm names on given host, for inspection
+func (f *FakeClient) GetVmNames(hostIp string) []string {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	names := []string{}
+	if h, err := f.getHost(hostIp); err == nil {
+		for k := range h.vms {
+			names = append(names, k)
+		}
+	}
+	return names
+}
+
+//Get all dnat rules on given host, for inspection
+func (f *FakeClient) GetDnatRules(hostIp string) []DnatRule {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	rules := []DnatRule{}
+	if h, err := f.getHost(hostIp); err == nil {
+		for _, r := range h.dnat {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
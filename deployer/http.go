@@ -0,0 +1,347 @@
+package deployer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/JinlongWukong/DevLab/utils"
+)
+
+//Deployer client talking to remote ansible deployer by http
+type httpClient struct {
+	baseUrl string
+}
+
+var _ Client = &httpClient{}
+
+func newHttpClient(baseUrl string) *httpClient {
+	return &httpClient{baseUrl: baseUrl}
+}
+
+//Send json payload, the response body will be appended into error if failed
+func (h *httpClient) post(path string, payload map[string]interface{}) ([]byte, error) {
+
+	data, _ := json.Marshal(payload)
+	err, reponse_data := utils.HttpSendJsonData(h.baseUrl+path, "POST", data)
+	if err != nil {
+		return reponse_data, fmt.Errorf("%v %v", err, string(reponse_data))
+	}
+
+	return reponse_data, nil
+}
+
+func (h *httpClient) InstallHost(host Host, subnet string) (HostInfo, error) {
+
+	var hostInfo HostInfo
+	log.Println("Remote http call to install node")
+	reponse_data, err := h.post("/host", map[string]interface{}{
+		"Ip":           host.Ip,
+		"Pass":         host.Pass,
+		"PrivateKey":   host.PrivateKey,
+		"KnownHostKey": host.KnownHostKey,
+		"User":         host.User,
+		"Role":         host.Role,
+		"Action":       "install",
+		"Subnet":       subnet,
+	})
+	if err != nil {
+		return hostInfo, err
+	}
+
+	err = json.Unmarshal(reponse_data, &hostInfo)
+	return hostInfo, err
+}
+
+func (h *httpClient) GetHostCondition(host Host) (HostCondition, error) {
+
+	var hostCondition HostCondition
+	query := map[string]string{
+		"Ip":           host.Ip,
+		"Pass":         host.Pass,
+		"PrivateKey":   host.PrivateKey,
+		"KnownHostKey": host.KnownHostKey,
+		"User":         host.User,
+		"Role":         host.Role,
+	}
+	err, reponse_data := utils.HttpGetJsonData(h.baseUrl+"/host", query)
+	if err != nil {
+		return hostCondition, err
+	}
+
+	err = json.Unmarshal(reponse_data, &hostCondition)
+	return hostCondition, err
+}
+
+func (h *httpClient) UpdateRoutes(hosts []Host, routes []Route) error {
+
+	logins := [][]string{}
+	for _, n := range hosts {
+		logins = append(logins, []string{n.Ip, n.User, n.Pass, n.Role, n.PrivateKey, n.KnownHostKey})
+	}
+
+	log.Println("Remote http call to update node route table")
+	_, err := h.post("/hosts", map[string]interface{}{
+		"Hosts":  logins,
+		"Routes": routes,
+		"Action": "route",
+	})
+
+	return err
+}
+
+func (h *httpClient) ActionDnat(host Host, rules []DnatRule) error {
+
+	log.Printf("Remote http call to set dnat rule on host %v", host.Ip)
+	_, err := h.post("/host/dnat", map[string]interface{}{
+		"rules":        rules,
+		"Ip":           host.Ip,
+		"Pass":         host.Pass,
+		"PrivateKey":   host.PrivateKey,
+		"KnownHostKey": host.KnownHostKey,
+		"User":         host.User,
+	})
+
+	return err
+}
+
+func (h *httpClient) CreateVm(host Host, spec VmSpec) error {
+
+	log.Println("Remote http call to create vm")
+	_, err := h.post("/vm", map[string]interface{}{
+		"vmName":           spec.Name,
+		"vmHostname":       spec.Hostname,
+		"vmAction":         "create",
+		"vmMemory":         spec.Memory,
+		"vmVcpus":          spec.CPU,
+		"vmDisk":           spec.Disk,
+		"vmType":           spec.Type,
+		"vncPass":          spec.VncPass,
+		"rootPass":         spec.RootPass,
+		"sshKeys":          spec.SshKeys,
+		"userData":         spec.UserData,
+		"extraSpecs":       spec.ExtraSpecs,
+		"hostIp":           host.Ip,
+		"hostPass":         host.Pass,
+		"hostPrivateKey":   host.PrivateKey,
+		"hostKnownHostKey": host.KnownHostKey,
+		"hostUser":         host.User,
+	})
+
+	return err
+}
+
+func (h *httpClient) ActionVm(host Host, name string, action VmAction) error {
+
+	log.Printf("Remote http call to %v vm", action)
+	_, err := h.post("/vm", map[string]interface{}{
+		"vmName":           name,
+		"vmAction":         action,
+		"hostIp":           host.Ip,
+		"hostPass":         host.Pass,
+		"hostPrivateKey":   host.PrivateKey,
+		"hostKnownHostKey": host.KnownHostKey,
+		"hostUser":         host.User,
+	})
+
+	return err
+}
+
+func (h *httpClient) ResizeVm(host Host, name string, cpu, memory, disk int32) error {
+
+	log.Printf("Remote http call to resize vm %v", name)
+	_, err := h.post("/vm", map[string]interface{}{
+		"vmName":           name,
+		"vmAction":         "resize",
+		"vmMemory":         memory,
+		"vmVcpus":          cpu,
+		"vmDisk":           disk,
+		"hostIp":           host.Ip,
+		"hostPass":         host.Pass,
+		"hostPrivateKey":   host.PrivateKey,
+		"hostKnownHostKey": host.KnownHostKey,
+		"hostUser":         host.User,
+	})
+
+	return err
+}
+
+func (h *httpClient) MigrateVm(source, target Host, name string, live bool) error {
+
+	log.Printf("Remote http call to migrate vm %v from %v to %v, live -> %v", name, source.Ip, target.Ip, live)
+	_, err := h.post("/vm/migrate", map[string]interface{}{
+		"vmName":                 name,
+		"migrateLive":            live,
+		"hostIp":                 source.Ip,
+		"hostPass":               source.Pass,
+		"hostPrivateKey":         source.PrivateKey,
+		"hostKnownHostKey":       source.KnownHostKey,
+		"hostUser":               source.User,
+		"targetHostIp":           target.Ip,
+		"targetHostPass":         target.Pass,
+		"targetHostPrivateKey":   target.PrivateKey,
+		"targetHostKnownHostKey": target.KnownHostKey,
+		"targetHostUser":         target.User,
+	})
+
+	return err
+}
+
+//Disk of source vm or its snapshot copied into new vm, deployer copies locally if source and target are same host
+func (h *httpClient) CloneVm(source, target Host, name, snapshot string, spec VmSpec) error {
+
+	log.Printf("Remote http call to clone vm %v from %v on %v to %v", spec.Name, name, source.Ip, target.Ip)
+	_, err := h.post("/vm/clone", map[string]interface{}{
+		"sourceVmName":           name,
+		"snapshotName":           snapshot,
+		"vmName":                 spec.Name,
+		"vmHostname":             spec.Hostname,
+		"vmMemory":               spec.Memory,
+		"vmVcpus":                spec.CPU,
+		"vmDisk":                 spec.Disk,
+		"vmType":                 spec.Type,
+		"vncPass":                spec.VncPass,
+		"rootPass":               spec.RootPass,
+		"sshKeys":                spec.SshKeys,
+		"extraSpecs":             spec.ExtraSpecs,
+		"hostIp":                 source.Ip,
+		"hostPass":               source.Pass,
+		"hostPrivateKey":         source.PrivateKey,
+		"hostKnownHostKey":       source.KnownHostKey,
+		"hostUser":               source.User,
+		"targetHostIp":           target.Ip,
+		"targetHostPass":         target.Pass,
+		"targetHostPrivateKey":   target.PrivateKey,
+		"targetHostKnownHostKey": target.KnownHostKey,
+		"targetHostUser":         target.User,
+	})
+
+	return err
+}
+
+func (h *httpClient) GetVmStatus(host Host, name string) (VmStatus, error) {
+
+	var vmStatus VmStatus
+	//vmName=test-1\&hostIp=127.0.0.1\&hostPass=xxxxx\&hostUser=root
+	query := map[string]string{
+		"vmName":           name,
+		"hostIp":           host.Ip,
+		"hostUser":         host.User,
+		"hostPass":         host.Pass,
+		"hostPrivateKey":   host.PrivateKey,
+		"hostKnownHostKey": host.KnownHostKey,
+	}
+	err, reponse_data := utils.HttpGetJsonData(h.baseUrl+"/vm", query)
+	if err != nil {
+		return vmStatus, err
+	}
+
+	err = json.Unmarshal(reponse_data, &vmStatus)
+	return vmStatus, err
+}
+
+func (h *httpClient) ActionSnapshot(host Host, name, snapshot string, action SnapshotAction) (SnapshotInfo, error) {
+
+	var snapshotInfo SnapshotInfo
+	log.Printf("Remote http call to %v snapshot %v of vm %v", action, snapshot, name)
+	reponse_data, err := h.post("/vm/snapshot", map[string]interface{}{
+		"vmName":           name,
+		"snapshotName":     snapshot,
+		"snapshotAction":   action,
+		"hostIp":           host.Ip,
+		"hostPass":         host.Pass,
+		"hostPrivateKey":   host.PrivateKey,
+		"hostKnownHostKey": host.KnownHostKey,
+		"hostUser":         host.User,
+	})
+	if err != nil {
+		return snapshotInfo, err
+	}
+
+	//revert/delete may return nothing
+	if len(reponse_data) > 0 {
+		json.Unmarshal(reponse_data, &snapshotInfo)
+	}
+	return snapshotInfo, nil
+}
+
+func (h *httpClient) InstallAddons(target Host, addons []string) error {
+
+	log.Printf("Remote http call to install addons %v", addons)
+	_, err := h.post("/vm/addons", map[string]interface{}{
+		"Address":  target.Ip,
+		"Passwd":   target.Pass,
+		"Username": target.User,
+		"Port":     target.Port,
+		"Addons":   addons,
+	})
+
+	return err
+}
+
+func (h *httpClient) InstallK8s(target Host, controller, worker uint16) error {
+
+	log.Println("Remote http call to install k8s cluster")
+	_, err := h.post("/k8s", map[string]interface{}{
+		"Ip":         target.Ip,
+		"Port":       target.Port,
+		"Pass":       target.Pass,
+		"User":       target.User,
+		"Controller": controller,
+		"Worker":     worker,
+	})
+
+	return err
+}
+
+func (h *httpClient) CreateContainer(host Host, spec ContainerSpec) (ContainerInfo, error) {
+
+	var containerInfo ContainerInfo
+	log.Printf("Remote http call to install software %v", spec.Name)
+	reponse_data, err := h.post("/container", map[string]interface{}{
+		"Ip":           host.Ip,
+		"Pass":         host.Pass,
+		"PrivateKey":   host.PrivateKey,
+		"KnownHostKey": host.KnownHostKey,
+		"User":         host.User,
+		"Name":         spec.Name,
+		"Software":     spec.Software,
+		"Version":      spec.Version,
+		"Cpu":          spec.CPU,
+		"Memory":       strconv.Itoa(int(spec.Memory)) + "m",
+	})
+	if err != nil {
+		return containerInfo, err
+	}
+
+	if err := json.Unmarshal(reponse_data, &containerInfo); err != nil {
+		log.Printf("Failed to unmarshal software %v status information", spec.Name)
+	}
+	return containerInfo, nil
+}
+
+func (h *httpClient) ActionContainer(host Host, name, software, action string) (ContainerInfo, error) {
+
+	var containerInfo ContainerInfo
+	log.Printf("Remote http call to %v software %v", action, name)
+	reponse_data, err := h.post("/container/action", map[string]interface{}{
+		"Ip":           host.Ip,
+		"Pass":         host.Pass,
+		"PrivateKey":   host.PrivateKey,
+		"KnownHostKey": host.KnownHostKey,
+		"User":         host.User,
+		"Name":         name,
+		"Software":     software,
+		"Action":       action,
+	})
+	if err != nil {
+		return containerInfo, err
+	}
+
+	//stop/delete may return nothing
+	if len(reponse_data) > 0 {
+		json.Unmarshal(reponse_data, &containerInfo)
+	}
+	return containerInfo, nil
+}
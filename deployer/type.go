@@ -0,0 +1,141 @@
+package deployer
+
+type VmAction string
+type SnapshotAction string
+
+const (
+	VmActionStart    VmAction = "start"
+	VmActionShutdown VmAction = "shutdown"
+	VmActionReboot   VmAction = "reboot"
+	VmActionDelete   VmAction = "delete"
+
+	SnapshotActionCreate SnapshotAction = "create"
+	SnapshotActionRevert SnapshotAction = "revert"
+	SnapshotActionDelete SnapshotAction = "delete"
+
+	DnatStatePresent = "present"
+	DnatStateAbsent  = "absent"
+)
+
+//Deployer client, every remote call to deployer goes through this interface
+type Client interface {
+	//Host part
+	InstallHost(host Host, subnet string) (HostInfo, error)
+	GetHostCondition(host Host) (HostCondition, error)
+	UpdateRoutes(hosts []Host, routes []Route) error
+	ActionDnat(host Host, rules []DnatRule) error
+
+	//VM part
+	CreateVm(host Host, spec VmSpec) error
+	ActionVm(host Host, name string, action VmAction) error
+	ResizeVm(host Host, name string, cpu, memory, disk int32) error
+	MigrateVm(source, target Host, name string, live bool) error
+	CloneVm(source, target Host, name, snapshot string, spec VmSpec) error
+	GetVmStatus(host Host, name string) (VmStatus, error)
+	InstallAddons(target Host, addons []string) error
+	ActionSnapshot(host Host, name, snapshot string, action SnapshotAction) (SnapshotInfo, error)
+
+	//K8S part
+	InstallK8s(target Host, controller, worker uint16) error
+
+	//Container part
+	CreateContainer(host Host, spec ContainerSpec) (ContainerInfo, error)
+	ActionContainer(host Host, name, software, action string) (ContainerInfo, error)
+}
+
+//Login information of a remote host(node or vm)
+//Port is only used when target is not listening on default ssh port, e.g. vm behind dnat
+//PrivateKey is PEM encoded ssh private key, preferred over Pass if given
+//KnownHostKey is host public key in authorized_keys format, or SHA256 fingerprint pinned by admin if never contacted,
+//deployer must verify host against it if given, empty means host key not known yet
+type Host struct {
+	Ip           string
+	Port         string
+	User         string
+	Pass         string
+	PrivateKey   string
+	Role         string
+	KnownHostKey string
+}
+
+type HostInfo struct {
+	CPU    int32  `json:"cpu"`
+	Memory int32  `json:"memory"`
+	Disk   int32  `json:"disk"`
+	OSType string `json:"type"`
+}
+
+type HostCondition struct {
+	CpuLoad   float64 `json:"cpu_load"`
+	MemAvail  int     `json:"memory_avail"`
+	DiskUsage string  `json:"disk_usage"`
+	Engine    uint8   `json:"engine_status"`
+}
+
+type Route struct {
+	Subnet string `json:"subnet"`
+	Via    string `json:"via"`
+}
+
+type DnatRule struct {
+	Dport       string `json:"dport"`
+	Destination string `json:"destination"`
+	State       string `json:"state"`
+	Protocol    string `json:"protocol"`
+}
+
+type VmSpec struct {
+	Name     string
+	Hostname string
+	CPU      int32
+	Memory   int32
+	Disk     int32
+	Type     string
+	VncPass  string
+	RootPass string
+	//public keys authorized for root, cloud-init user-data passed through as is
+	SshKeys  []string
+	UserData string
+	//hints of flavor, e.g. cpu pinning, deployer may ignore unsupported ones
+	ExtraSpecs map[string]string
+}
+
+type VmStatus struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Address string `json:"address"`
+	VncPort string `json:"vncPort"`
+}
+
+//Snapshot of vm, size(unit MB) is disk space taken on host
+type SnapshotInfo struct {
+	Name string `json:"name"`
+	Size int32  `json:"size"`
+}
+
+type ContainerSpec struct {
+	Name     string
+	Software string
+	Version  string
+	CPU      uint8
+	Memory   uint32
+}
+
+/*
+{
+    "additional_infor": {
+        "admin_password": "33c426556ee546949703391ce86a9cb6"
+    },
+    "address": "172.19.2.3",
+    "port_mapping": [
+        "50000/tcp -> 0.0.0.0:49187",
+        "8080/tcp -> 0.0.0.0:49188"
+    ]
+}
+*/
+type ContainerInfo struct {
+	Status          string            `json:"status"`
+	Address         string            `json:"address"`
+	PortMapping     []string          `json:"port_mapping"`
+	AdditionalInfor map[string]string `json:"additional_infor,omitempty"`
+}
@@ -0,0 +1,214 @@
+package flavor
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/project"
+)
+
+//Flavors compiled in before catalog, kept until flavor table saved into db for the first time
+var FlavorDB = FlavorMap{Map: map[string]*Flavor{
+	"small":  {Name: "small", CPU: 2, Memory: 2048, Disk: 30, CreatedAt: time.Now()},
+	"middle": {Name: "middle", CPU: 4, Memory: 4096, Disk: 64, CreatedAt: time.Now()},
+	"large":  {Name: "large", CPU: 6, Memory: 8192, Disk: 80, CreatedAt: time.Now()},
+}}
+
+var flavorNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+//Roles flavor can be restricted to, same as account roles
+var roles = []string{"admin", "guest"}
+
+//Extra specs passed to deployer as hints, e.g. cpu pinning, value checked by validator
+var extraSpecValidators = map[string]func(string) bool{
+	"cpuPolicy":       oneOf("shared", "dedicated"),
+	"cpuThreadPolicy": oneOf("prefer", "isolate", "require"),
+	"memPageSize":     oneOf("small", "large", "any", "2MB", "1GB"),
+	"numaNodes": func(value string) bool {
+		n, err := strconv.Atoi(value)
+		return err == nil && n >= 1 && n <= 8
+	},
+}
+
+func oneOf(values ...string) func(string) bool {
+	return func(value string) bool {
+		return contains(values, value)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type FlavorMap struct {
+	Map  map[string]*Flavor `json:"flavor"`
+	lock sync.RWMutex       `json:"-"`
+}
+
+type FlavorMapItem struct {
+	Key   string
+	Value *Flavor
+}
+
+func (m *FlavorMap) Set(key string, value *Flavor) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map[key] = value
+
+}
+
+func (m *FlavorMap) Get(key string) (value *Flavor, exists bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	value, exists = m.Map[key]
+	return
+
+}
+
+func (m *FlavorMap) Del(key string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.Map, key)
+
+}
+
+// Replace whole map, used by db restore
+func (m *FlavorMap) Replace(newMap map[string]*Flavor) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copy of map and flavors made under lock, used by db to persist map
+func (m *FlavorMap) Snapshot() map[string]*Flavor {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*Flavor, len(m.Map))
+	for k, v := range m.Map {
+		c := *v
+		c.ExtraSpecs = make(map[string]string, len(v.ExtraSpecs))
+		for name, value := range v.ExtraSpecs {
+			c.ExtraSpecs[name] = value
+		}
+		c.Roles = append([]string{}, v.Roles...)
+		c.Projects = append([]string{}, v.Projects...)
+		copied[k] = &c
+	}
+
+	return copied
+}
+
+// Iter iterates over the items in a concurrent map
+// Each item is sent over a channel, so that
+// we can iterate over the map using the builtin range keyword
+func (m *FlavorMap) Iter() <-chan FlavorMapItem {
+	c := make(chan FlavorMapItem)
+
+	f := func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		for k, v := range m.Map {
+			c <- FlavorMapItem{k, v}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+//Check whether flavor can be used by account of role within project
+//Admin sees all flavors, project is empty if vm not created in project
+func (f *Flavor) VisibleTo(role, projectName string) bool {
+
+	if role == "admin" || (len(f.Roles) == 0 && len(f.Projects) == 0) {
+		return true
+	}
+
+	return contains(f.Roles, role) || (projectName != "" && contains(f.Projects, projectName))
+}
+
+//Build flavor from request, roles, projects and extra specs validated
+func NewFlavor(flavorRequest FlavorRequest) (*Flavor, error) {
+
+	if flavorNameRegexp.MatchString(flavorRequest.Name) == false {
+		return nil, fmt.Errorf("flavor name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	for _, r := range flavorRequest.Roles {
+		if contains(roles, r) == false {
+			return nil, fmt.Errorf("role %v not valid, must be one of %v", r, roles)
+		}
+	}
+	for _, p := range flavorRequest.Projects {
+		if _, exists := project.ProjectDB.Get(p); exists == false {
+			return nil, fmt.Errorf("project %v not existed", p)
+		}
+	}
+	for k, v := range flavorRequest.ExtraSpecs {
+		validator, exists := extraSpecValidators[k]
+		if exists == false {
+			return nil, fmt.Errorf("extra spec %v not supported, must be one of %v", k, extraSpecKeys())
+		}
+		if validator(v) == false {
+			return nil, fmt.Errorf("extra spec %v has invalid value %v", k, v)
+		}
+	}
+
+	return &Flavor{
+		Name:       flavorRequest.Name,
+		CPU:        flavorRequest.CPU,
+		Memory:     flavorRequest.Memory,
+		Disk:       flavorRequest.Disk,
+		ExtraSpecs: flavorRequest.ExtraSpecs,
+		Roles:      flavorRequest.Roles,
+		Projects:   flavorRequest.Projects,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+func extraSpecKeys() []string {
+
+	keys := []string{}
+	for k := range extraSpecValidators {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+//Find flavor usable by account of role within project
+func Lookup(name, role, projectName string) (*Flavor, error) {
+
+	myFlavor, exists := FlavorDB.Get(name)
+	if exists == false {
+		return nil, &InvalidError{Name: name, Reason: "not found in catalog"}
+	}
+	if myFlavor.VisibleTo(role, projectName) == false {
+		log.Printf("Flavor %v not visible to role %v in project %v", name, role, projectName)
+		return nil, &InvalidError{Name: name, Reason: "not available to your account or project"}
+	}
+
+	return myFlavor, nil
+}
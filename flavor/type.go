@@ -0,0 +1,40 @@
+package flavor
+
+import (
+	"fmt"
+	"time"
+)
+
+//VM size registered by admin, memory unit MB, disk unit GB
+//Flavor with neither roles nor projects is visible to everyone
+type Flavor struct {
+	Name       string            `json:"name"`
+	CPU        int32             `json:"cpu"`
+	Memory     int32             `json:"memory"`
+	Disk       int32             `json:"disk"`
+	ExtraSpecs map[string]string `json:"extraSpecs,omitempty"`
+	Roles      []string          `json:"roles,omitempty"`
+	Projects   []string          `json:"projects,omitempty"`
+	CreatedAt  time.Time         `json:"createdAt"`
+}
+
+//Extra specs only accepted in json body, name taken from path on update
+type FlavorRequest struct {
+	Name       string            `json:"name" form:"name"`
+	CPU        int32             `json:"cpu" form:"cpu" binding:"required,min=1"`
+	Memory     int32             `json:"memory" form:"mem" binding:"required,min=1"`
+	Disk       int32             `json:"disk" form:"disk" binding:"required,min=1"`
+	ExtraSpecs map[string]string `json:"extraSpecs" form:"-"`
+	Roles      []string          `json:"roles" form:"roles"`
+	Projects   []string          `json:"projects" form:"projects"`
+}
+
+//VM request not satisfied by flavor catalog
+type InvalidError struct {
+	Name   string
+	Reason string
+}
+
+func (e *InvalidError) Error() string {
+	return fmt.Sprintf("flavor %v %v", e.Name, e.Reason)
+}
@@ -17,6 +17,7 @@ require (
 	github.com/pkg/sftp v1.13.3
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/ugorji/go v1.2.5 // indirect
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
 	golang.org/x/text v0.3.6 // indirect
 	gopkg.in/ini.v1 v1.62.0
@@ -0,0 +1,227 @@
+package hostkey
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var HostKeyDB = HostKeyMap{Map: make(map[string]*HostKey)}
+
+type HostKeyMap struct {
+	Map  map[string]*HostKey `json:"hostkey"`
+	lock sync.RWMutex        `json:"-"`
+}
+
+type HostKeyMapItem struct {
+	Key   string
+	Value *HostKey
+}
+
+func (m *HostKeyMap) Set(key string, value *HostKey) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map[key] = value
+
+}
+
+func (m *HostKeyMap) Get(key string) (value *HostKey, exists bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	value, exists = m.Map[key]
+	return
+
+}
+
+func (m *HostKeyMap) Del(key string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.Map, key)
+
+}
+
+// Replace whole map, used by db restore
+func (m *HostKeyMap) Replace(newMap map[string]*HostKey) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copy of map and host keys made under lock, used by db to persist map
+func (m *HostKeyMap) Snapshot() map[string]*HostKey {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*HostKey, len(m.Map))
+	for k, v := range m.Map {
+		c := *v
+		copied[k] = &c
+	}
+
+	return copied
+}
+
+// Iter iterates over the items in a concurrent map
+// Each item is sent over a channel, so that
+// we can iterate over the map using the builtin range keyword
+func (m *HostKeyMap) Iter() <-chan HostKeyMapItem {
+	c := make(chan HostKeyMapItem)
+
+	f := func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		for k, v := range m.Map {
+			c <- HostKeyMapItem{k, v}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+//Verify host key presented by remote host
+//Key is recorded on first contact, later contacts must present the same key
+//Args:
+//  address -> host:port
+//  name    -> what the host is, e.g. node name or vm name, only for display
+func (m *HostKeyMap) Verify(address, name string, key ssh.PublicKey) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	known, exists := m.Map[address]
+	if exists == false {
+		log.Printf("Host key of %v(%v) recorded on first contact: %v", name, address, fingerprint)
+		m.Map[address] = &HostKey{
+			Address:     address,
+			Name:        name,
+			Type:        key.Type(),
+			Fingerprint: fingerprint,
+			PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+			Source:      SourceTofu,
+			FirstSeen:   time.Now(),
+			LastSeen:    time.Now(),
+		}
+		return nil
+	}
+
+	if known.Fingerprint != fingerprint {
+		err := &MismatchError{Address: address, Name: name, Expected: known.Fingerprint, Got: fingerprint}
+		log.Println(err)
+		return err
+	}
+	if known.PublicKey == "" {
+		known.Type = key.Type()
+		known.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		known.FirstSeen = time.Now()
+	}
+	known.LastSeen = time.Now()
+
+	return nil
+}
+
+//Ssh host key callback verifying against known host keys
+func Callback(name string) ssh.HostKeyCallback {
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return HostKeyDB.Verify(hostname, name, key)
+	}
+}
+
+//Known host key of address passed to deployer, public key if recorded, otherwise fingerprint pinned by admin
+//Empty returned if address never contacted nor pinned
+func Known(host string, port int) string {
+
+	known, exists := HostKeyDB.Get(net.JoinHostPort(host, strconv.Itoa(port)))
+	if exists == false {
+		return ""
+	}
+	if known.PublicKey != "" {
+		return known.PublicKey
+	}
+
+	return known.Fingerprint
+}
+
+//Pin host key fingerprint provided by admin, existing key of address replaced
+func Pin(hostKeyRequest HostKeyRequest) (*HostKey, error) {
+
+	address, err := NormalizeAddress(hostKeyRequest.Address)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(hostKeyRequest.Fingerprint, "SHA256:") == false {
+		return nil, fmt.Errorf("fingerprint must be in SHA256:xxx format, e.g. output of ssh-keygen -lf")
+	}
+
+	newKey := &HostKey{
+		Address:     address,
+		Name:        hostKeyRequest.Name,
+		Fingerprint: hostKeyRequest.Fingerprint,
+		Source:      SourceAdmin,
+	}
+	HostKeyDB.Set(address, newKey)
+	log.Printf("Host key fingerprint of %v pinned: %v", address, newKey.Fingerprint)
+
+	return newKey, nil
+}
+
+//Forget host key of address, next contact will be trusted on first use again
+func Forget(host string, port int) {
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	if _, exists := HostKeyDB.Get(address); exists {
+		HostKeyDB.Del(address)
+		log.Printf("Host key of %v forgotten", address)
+	}
+}
+
+//Forget all host keys of host, no matter which port
+func ForgetHost(host string) {
+
+	addresses := []string{}
+	for k := range HostKeyDB.Iter() {
+		if h, _, err := net.SplitHostPort(k.Key); err == nil && h == host {
+			addresses = append(addresses, k.Key)
+		}
+	}
+	for _, address := range addresses {
+		HostKeyDB.Del(address)
+		log.Printf("Host key of %v forgotten", address)
+	}
+}
+
+//Normalize address as host:port, default ssh port 22 used if not given
+func NormalizeAddress(address string) (string, error) {
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, "22"
+	}
+	if host == "" {
+		return "", fmt.Errorf("invalid address %v", address)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return "", fmt.Errorf("invalid port of address %v", address)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
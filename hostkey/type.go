@@ -0,0 +1,44 @@
+package hostkey
+
+import "time"
+
+type Source string
+
+const (
+	//recorded on first contact, trust-on-first-use
+	SourceTofu Source = "tofu"
+	//fingerprint provided by admin
+	SourceAdmin Source = "admin"
+)
+
+//Known ssh host key, keyed by address(host:port)
+//PublicKey is empty if fingerprint provided by admin but host never contacted
+type HostKey struct {
+	Address     string    `json:"address"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Fingerprint string    `json:"fingerprint"`
+	PublicKey   string    `json:"publicKey"`
+	Source      Source    `json:"source"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+type HostKeyRequest struct {
+	Address     string `json:"address" form:"address" binding:"required"`
+	Name        string `json:"name" form:"name"`
+	Fingerprint string `json:"fingerprint" form:"fingerprint" binding:"required"`
+}
+
+//Host key presented by remote host not match the known one
+type MismatchError struct {
+	Address  string
+	Name     string
+	Expected string
+	Got      string
+}
+
+func (e *MismatchError) Error() string {
+	return "host key verification failed for " + e.Name + "(" + e.Address + "): expected " + e.Expected + ", got " + e.Got +
+		", remote host may be reinstalled or connection intercepted, ask admin to reset known host key if it is expected"
+}
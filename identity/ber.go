@@ -0,0 +1,303 @@
+package identity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//Minimal BER encoding needed by ldap messages(RFC 4511), only single byte tags supported
+
+const (
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x30
+	berTagSet         = 0x31
+)
+
+//Decoded tag-length-value, children parsed for constructed types
+type berPacket struct {
+	Tag      byte
+	Value    []byte
+	Children []*berPacket
+}
+
+func berEncode(tag byte, value []byte) []byte {
+
+	var length []byte
+	n := len(value)
+	switch {
+	case n < 0x80:
+		length = []byte{byte(n)}
+	case n <= 0xff:
+		length = []byte{0x81, byte(n)}
+	case n <= 0xffff:
+		length = []byte{0x82, byte(n >> 8), byte(n)}
+	default:
+		length = []byte{0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+
+	packet := append([]byte{tag}, length...)
+	return append(packet, value...)
+}
+
+func berConstructed(tag byte, children ...[]byte) []byte {
+
+	value := []byte{}
+	for _, child := range children {
+		value = append(value, child...)
+	}
+
+	return berEncode(tag, value)
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+func berInt(tag byte, v int) []byte {
+
+	value := []byte{byte(v)}
+	for v > 0x7f || v < -0x80 {
+		v >>= 8
+		value = append([]byte{byte(v)}, value...)
+	}
+
+	return berEncode(tag, value)
+}
+
+func berBool(v bool) []byte {
+
+	if v {
+		return berEncode(berTagBoolean, []byte{0xff})
+	}
+	return berEncode(berTagBoolean, []byte{0x00})
+}
+
+//Read one packet from stream
+func berRead(r *bufio.Reader) (*berPacket, error) {
+
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag&0x1f == 0x1f {
+		return nil, fmt.Errorf("ber multi-byte tag not supported")
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		num := int(first & 0x7f)
+		if num == 0 || num > 4 {
+			return nil, fmt.Errorf("ber length of %v bytes not supported", num)
+		}
+		length = 0
+		for i := 0; i < num; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > 16<<20 {
+		return nil, fmt.Errorf("ber packet too large: %v", length)
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, err
+	}
+
+	return berParse(tag, value)
+}
+
+func berParse(tag byte, value []byte) (*berPacket, error) {
+
+	packet := &berPacket{Tag: tag, Value: value}
+	//constructed bit set
+	if tag&0x20 != 0 {
+		r := bufio.NewReader(bytes.NewReader(value))
+		for {
+			child, err := berRead(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			packet.Children = append(packet.Children, child)
+		}
+	}
+
+	return packet, nil
+}
+
+func (p *berPacket) Int() int {
+
+	v := 0
+	for i, b := range p.Value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int(b)
+	}
+
+	return v
+}
+
+func (p *berPacket) String() string {
+	return string(p.Value)
+}
+
+//Escape value substituted into ldap filter(RFC 4515)
+func ldapEscapeFilter(s string) string {
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			b.WriteString(fmt.Sprintf("\\%02x", c))
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+//Decode \xx escapes of filter value
+func ldapUnescapeFilter(s string) (string, error) {
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("ldap filter escape not valid: %v", s)
+		}
+		decoded, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap filter escape not valid: %v", s)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+
+	return b.String(), nil
+}
+
+//Compile ldap filter string into ber, e.g. (&(objectClass=person)(uid=bob))
+//Supported: and, or, not, equality, presence, substrings, >=, <=, ~=
+func ldapCompileFilter(filter string) ([]byte, error) {
+
+	packet, rest, err := ldapParseFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap filter has trailing characters: %v", rest)
+	}
+
+	return packet, nil
+}
+
+func ldapParseFilter(f string) ([]byte, string, error) {
+
+	if len(f) < 3 || f[0] != '(' {
+		return nil, "", fmt.Errorf("ldap filter not valid: %v", f)
+	}
+	f = f[1:]
+
+	switch f[0] {
+	case '&', '|':
+		tag := byte(0xa0)
+		if f[0] == '|' {
+			tag = 0xa1
+		}
+		f = f[1:]
+		children := [][]byte{}
+		for len(f) > 0 && f[0] == '(' {
+			child, rest, err := ldapParseFilter(f)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			f = rest
+		}
+		if len(f) == 0 || f[0] != ')' {
+			return nil, "", fmt.Errorf("ldap filter missing ')'")
+		}
+		return berConstructed(tag, children...), f[1:], nil
+	case '!':
+		child, rest, err := ldapParseFilter(f[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", fmt.Errorf("ldap filter missing ')'")
+		}
+		return berConstructed(0xa2, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(f, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap filter missing ')'")
+	}
+	item, rest := f[:end], f[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, "", fmt.Errorf("ldap filter item not valid: %v", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	tag := byte(0xa3)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = 0xa5, attr[:len(attr)-1]
+	case '<':
+		tag, attr = 0xa6, attr[:len(attr)-1]
+	case '~':
+		tag, attr = 0xa8, attr[:len(attr)-1]
+	}
+
+	if tag == 0xa3 && value == "*" {
+		return berString(0x87, attr), rest, nil
+	}
+	if tag == 0xa3 && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		subs := [][]byte{}
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			decoded, err := ldapUnescapeFilter(part)
+			if err != nil {
+				return nil, "", err
+			}
+			subTag := byte(0x81)
+			if i == 0 {
+				subTag = 0x80
+			} else if i == len(parts)-1 {
+				subTag = 0x82
+			}
+			subs = append(subs, berString(subTag, decoded))
+		}
+		return berConstructed(0xa4, berString(berTagOctetString, attr), berConstructed(berTagSequence, subs...)), rest, nil
+	}
+
+	decoded, err := ldapUnescapeFilter(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return berConstructed(tag, berString(berTagOctetString, attr), berString(berTagOctetString, decoded)), rest, nil
+}
@@ -0,0 +1,259 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/config"
+	"github.com/dgrijalva/jwt-go"
+)
+
+//Path where stand-in oidc issuer is mounted
+const FakeIssuerPath = "/idp/fake"
+
+const fakeKeyID = "fake"
+
+//Local stand-in identity provider for test, users are given by config FakeUsers
+//It verifies passwords directly like ldap, and also serves as oidc issuer so oidc provider can be pointed at it
+//Client secret is not checked by the stand-in issuer
+type fakeProvider struct {
+	users map[string]fakeUser
+	key   *rsa.PrivateKey
+	codes map[string]fakeCode
+	lock  sync.Mutex
+}
+
+type fakeUser struct {
+	password string
+	groups   []string
+}
+
+//Authorization code issued by stand-in issuer, valid once in one minute
+type fakeCode struct {
+	user, clientID, redirectURI, nonce string
+	expiresAt                          time.Time
+}
+
+var fake *fakeProvider
+
+var _ PasswordProvider = &fakeProvider{}
+
+var fakeLoginPage = template.Must(template.New("login").Parse(`<html><body>
+<h3>DevLab stand-in identity provider</h3>
+<form method="post">
+<input name="username" placeholder="username"/> <input name="password" type="password" placeholder="password"/>
+<input type="hidden" name="client_id" value="{{.client_id}}"/>
+<input type="hidden" name="redirect_uri" value="{{.redirect_uri}}"/>
+<input type="hidden" name="state" value="{{.state}}"/>
+<input type="hidden" name="nonce" value="{{.nonce}}"/>
+<button type="submit">Login</button>
+</form></body></html>`))
+
+func newFakeProvider() *fakeProvider {
+
+	p := &fakeProvider{
+		users: make(map[string]fakeUser),
+		codes: make(map[string]fakeCode),
+	}
+	for _, item := range splitList(config.Identity.FakeUsers) {
+		fields := strings.SplitN(item, ":", 3)
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			log.Printf("Fake identity user %v not valid, ignored", fields[0])
+			continue
+		}
+		user := fakeUser{password: fields[1]}
+		if len(fields) == 3 && fields[2] != "" {
+			user.groups = strings.Split(fields[2], "|")
+		}
+		p.users[fields[0]] = user
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Fake identity provider key generation failed: %v", err)
+	}
+	p.key = key
+	fake = p
+
+	return p
+}
+
+func (p *fakeProvider) Authenticate(username, password string) (*Identity, error) {
+
+	user, exists := p.users[username]
+	if exists == false || subtle.ConstantTimeCompare([]byte(user.password), []byte(password)) != 1 {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	return &Identity{Name: username, Groups: user.groups}, nil
+}
+
+//Http handler of stand-in oidc issuer, nil if fake provider not enabled
+func FakeIssuer() http.Handler {
+
+	if fake == nil {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", fake.discoveryHandler)
+	mux.HandleFunc("/authorize", fake.authorizeHandler)
+	mux.HandleFunc("/token", fake.tokenHandler)
+	mux.HandleFunc("/jwks", fake.jwksHandler)
+
+	return mux
+}
+
+//Issuer url as seen by the client
+func fakeIssuerURL(r *http.Request) string {
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host + FakeIssuerPath
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *fakeProvider) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+
+	issuer := fakeIssuerURL(r)
+	writeJSON(w, http.StatusOK, oidcDiscovery{
+		Issuer:                issuer,
+		AuthorizationEndpoint: issuer + "/authorize",
+		TokenEndpoint:         issuer + "/token",
+		JwksURI:               issuer + "/jwks",
+	})
+}
+
+func (p *fakeProvider) jwksHandler(w http.ResponseWriter, r *http.Request) {
+
+	writeJSON(w, http.StatusOK, map[string][]jsonWebKey{
+		"keys": {{
+			Kty: "RSA",
+			Kid: fakeKeyID,
+			N:   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+//GET shows login form, POST verifies user and redirects back to client with code
+func (p *fakeProvider) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := map[string]string{}
+	for _, name := range []string{"client_id", "redirect_uri", "state", "nonce"} {
+		params[name] = r.FormValue(name)
+	}
+	redirectURI, err := url.Parse(params["redirect_uri"])
+	if err != nil || params["redirect_uri"] == "" || params["client_id"] == "" {
+		http.Error(w, "client_id and redirect_uri required", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		fakeLoginPage.Execute(w, params)
+		return
+	}
+
+	username := r.FormValue("username")
+	if _, err := p.Authenticate(username, r.FormValue("password")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	code := NewState()
+	p.lock.Lock()
+	for k, c := range p.codes {
+		if time.Now().After(c.expiresAt) {
+			delete(p.codes, k)
+		}
+	}
+	p.codes[code] = fakeCode{
+		user:        username,
+		clientID:    params["client_id"],
+		redirectURI: params["redirect_uri"],
+		nonce:       params["nonce"],
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	p.lock.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	query.Set("state", params["state"])
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+//Exchange code for id token
+func (p *fakeProvider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, _, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+	} else {
+		clientID = r.FormValue("client_id")
+	}
+
+	p.lock.Lock()
+	c, exists := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.lock.Unlock()
+
+	if exists == false || time.Now().After(c.expiresAt) ||
+		r.FormValue("grant_type") != "authorization_code" ||
+		c.clientID != clientID || c.redirectURI != r.FormValue("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":                fakeIssuerURL(r),
+		"sub":                c.user,
+		"aud":                c.clientID,
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+		"nonce":              c.nonce,
+		"preferred_username": c.user,
+		"groups":             p.users[c.user].groups,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = fakeKeyID
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": NewState(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
@@ -0,0 +1,149 @@
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/config"
+)
+
+var passwordProviders = make(map[string]PasswordProvider)
+var redirectProviders = make(map[string]RedirectProvider)
+
+var defaultRole = account.RoleGuest
+var adminGroups []string
+
+//account name is used as prefix of vm/k8s/software name
+var accountNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+//Load identity providers from config.ini
+func init() {
+
+	switch account.RoleType(config.Identity.DefaultRole) {
+	case "":
+	case account.RoleAdmin, account.RoleGuest:
+		defaultRole = account.RoleType(config.Identity.DefaultRole)
+	default:
+		log.Printf("Identity default role %v not valid, %v used", config.Identity.DefaultRole, defaultRole)
+	}
+	adminGroups = splitList(config.Identity.AdminGroups)
+
+	for _, kind := range splitList(config.Identity.Providers) {
+		switch kind {
+		case "ldap":
+			passwordProviders[kind] = newLdapProvider()
+		case "oidc":
+			redirectProviders[kind] = newOidcProvider()
+		case "fake":
+			passwordProviders[kind] = newFakeProvider()
+		default:
+			log.Printf("Identity provider %v not supported, ignored", kind)
+			continue
+		}
+		log.Printf("Identity provider %v enabled", kind)
+	}
+}
+
+//Split comma separated list, blanks trimmed
+func splitList(s string) []string {
+
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func GetPasswordProvider(name string) (PasswordProvider, bool) {
+	p, exists := passwordProviders[name]
+	return p, exists
+}
+
+func GetRedirectProvider(name string) (RedirectProvider, bool) {
+	p, exists := redirectProviders[name]
+	return p, exists
+}
+
+//All enabled providers, one-time password is always available
+func GetProviders() []ProviderInfo {
+
+	providers := []ProviderInfo{}
+	for name := range passwordProviders {
+		providers = append(providers, ProviderInfo{Name: name, Flow: "password"})
+	}
+	for name := range redirectProviders {
+		providers = append(providers, ProviderInfo{Name: name, Flow: "redirect"})
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].Name < providers[j].Name
+	})
+
+	return providers
+}
+
+//Role of account provisioned on first login, admin if member of any admin group
+func mapRole(groups []string) account.RoleType {
+
+	for _, group := range groups {
+		for _, adminGroup := range adminGroups {
+			if strings.EqualFold(group, adminGroup) {
+				return account.RoleAdmin
+			}
+		}
+	}
+
+	return defaultRole
+}
+
+//Find or create account of identity asserted by provider
+//Account is created on first login with role mapped from groups, role is not changed afterwards
+//Existing account is only accepted if it was provisioned by the same provider, local accounts can't be taken over
+func Provision(providerName string, id *Identity) (*account.Account, error) {
+
+	name := strings.ToLower(id.Name)
+	if accountNameRegexp.MatchString(name) == false {
+		return nil, fmt.Errorf("user name %v can't be used as account name", id.Name)
+	}
+
+	if ac, exists := account.AccountDB.Get(name); exists {
+		if ac.Provider != providerName {
+			return nil, fmt.Errorf("account %v is not managed by identity provider %v", name, providerName)
+		}
+		return ac, nil
+	}
+
+	role := mapRole(id.Groups)
+	err := account.AccountDB.Add(account.AccountRequest{
+		Name:     name,
+		Role:     role,
+		Contract: id.Email,
+		Provider: providerName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Account %v provisioned by identity provider %v with role %v", name, providerName, role)
+
+	ac, _ := account.AccountDB.Get(name)
+	return ac, nil
+}
+
+//Unpredictable random token, used as oidc state and authorization code
+func NewState() string {
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("random token generation failed: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,259 @@
+package identity
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/config"
+)
+
+//Stand-in idp with one admin and one guest user
+func newTestFakeProvider() *fakeProvider {
+
+	config.Identity.FakeUsers = "alice:secret:devlab-admins|dev,bob:password"
+	adminGroups = []string{"devlab-admins"}
+
+	return newFakeProvider()
+}
+
+//Minimal ldap server backed by users of stand-in idp
+//Users are entries uid=<name>,ou=people,dc=devlab with mail and memberOf attributes
+func serveLdap(t *testing.T, p *fakeProvider, bindDN, bindPassword string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	userDN := func(name string) string {
+		return "uid=" + name + ",ou=people,dc=devlab"
+	}
+	result := func(tag byte, code int) []byte {
+		return berConstructed(tag, berInt(berTagEnumerated, code),
+			berString(berTagOctetString, ""), berString(berTagOctetString, ""))
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					packet, err := berRead(r)
+					if err != nil || len(packet.Children) < 2 {
+						return
+					}
+					id, op := packet.Children[0].Int(), packet.Children[1]
+					reply := func(response []byte) {
+						conn.Write(berConstructed(berTagSequence, berInt(berTagInteger, id), response))
+					}
+
+					switch op.Tag {
+					case 0x60:
+						dn, password := op.Children[1].String(), op.Children[2].String()
+						code := ldapInvalidCredentials
+						if dn == bindDN && password == bindPassword {
+							code = ldapSuccess
+						}
+						for name := range p.users {
+							if dn == userDN(name) {
+								if _, err := p.Authenticate(name, password); err == nil {
+									code = ldapSuccess
+								}
+							}
+						}
+						reply(result(0x61, code))
+					case 0x63:
+						//only equality filter on uid supported
+						filter := op.Children[6]
+						if filter.Tag == 0xa3 && filter.Children[0].String() == "uid" {
+							name := filter.Children[1].String()
+							if user, exists := p.users[name]; exists {
+								groups := [][]byte{}
+								for _, group := range user.groups {
+									groups = append(groups, berString(berTagOctetString, "cn="+group+",ou=groups,dc=devlab"))
+								}
+								reply(berConstructed(0x64,
+									berString(berTagOctetString, userDN(name)),
+									berConstructed(berTagSequence,
+										berConstructed(berTagSequence,
+											berString(berTagOctetString, "mail"),
+											berConstructed(berTagSet, berString(berTagOctetString, name+"@devlab.local"))),
+										berConstructed(berTagSequence,
+											berString(berTagOctetString, "memberOf"),
+											berConstructed(berTagSet, groups...))),
+								))
+							}
+						}
+						reply(result(0x65, ldapSuccess))
+					case 0x42:
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return "ldap://" + ln.Addr().String()
+}
+
+func TestFakeProviderLogin(t *testing.T) {
+
+	p := newTestFakeProvider()
+
+	if _, err := p.Authenticate("alice", "wrong"); err == nil {
+		t.Errorf("login with wrong password succeeded")
+	}
+	if _, err := p.Authenticate("nobody", "secret"); err == nil {
+		t.Errorf("login of unknown user succeeded")
+	}
+
+	id, err := p.Authenticate("alice", "secret")
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	alice, err := Provision("fake", id)
+	if err != nil {
+		t.Fatalf("provision failed: %v", err)
+	}
+	if alice.Role != account.RoleAdmin || alice.Provider != "fake" {
+		t.Errorf("alice provisioned with role %v provider %v", alice.Role, alice.Provider)
+	}
+
+	id, _ = p.Authenticate("bob", "password")
+	bob, err := Provision("fake", id)
+	if err != nil {
+		t.Fatalf("provision failed: %v", err)
+	}
+	if bob.Role != defaultRole {
+		t.Errorf("bob provisioned with role %v, expected %v", bob.Role, defaultRole)
+	}
+
+	//local account can't be taken over by identity provider
+	account.AccountDB.Add(account.AccountRequest{Name: "carol", Role: account.RoleGuest})
+	if _, err := Provision("fake", &Identity{Name: "carol"}); err == nil {
+		t.Errorf("local account taken over by identity provider")
+	}
+	if _, err := Provision("fake", &Identity{Name: "not valid"}); err == nil {
+		t.Errorf("account provisioned with invalid name")
+	}
+}
+
+func TestLdapLogin(t *testing.T) {
+
+	p := newTestFakeProvider()
+	config.Identity.LdapURL = serveLdap(t, p, "cn=devlab,dc=devlab", "service")
+	config.Identity.LdapBindDN = "cn=devlab,dc=devlab"
+	config.Identity.LdapBindPassword = "service"
+	config.Identity.LdapBaseDN = "ou=people,dc=devlab"
+	ldap := newLdapProvider()
+
+	id, err := ldap.Authenticate("alice", "secret")
+	if err != nil {
+		t.Fatalf("ldap login failed: %v", err)
+	}
+	if id.Name != "alice" || id.Email != "alice@devlab.local" {
+		t.Errorf("unexpected identity %+v", id)
+	}
+	if mapRole(id.Groups) != account.RoleAdmin {
+		t.Errorf("groups %v not mapped to admin", id.Groups)
+	}
+
+	if _, err := ldap.Authenticate("alice", "wrong"); err == nil {
+		t.Errorf("ldap login with wrong password succeeded")
+	}
+	if _, err := ldap.Authenticate("nobody", "secret"); err == nil {
+		t.Errorf("ldap login of unknown user succeeded")
+	}
+	//wildcard is escaped, never matches every entry
+	if _, err := ldap.Authenticate("*", "secret"); err == nil {
+		t.Errorf("ldap login with wildcard user succeeded")
+	}
+	if _, err := ldap.Authenticate("alice", ""); err == nil {
+		t.Errorf("ldap login with empty password succeeded")
+	}
+
+	ldap.bindPassword = "wrong"
+	if _, err := ldap.Authenticate("alice", "secret"); err == nil {
+		t.Errorf("ldap login succeeded with wrong service account password")
+	}
+}
+
+func TestOidcLoginAgainstFakeIssuer(t *testing.T) {
+
+	newTestFakeProvider()
+	mux := http.NewServeMux()
+	mux.Handle(FakeIssuerPath+"/", http.StripPrefix(FakeIssuerPath, FakeIssuer()))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config.Identity.OidcIssuer = server.URL + FakeIssuerPath
+	config.Identity.OidcClientID = "devlab"
+	config.Identity.OidcRedirectURL = "http://devlab.local/sso/oidc/callback"
+	oidc := newOidcProvider()
+
+	//browser posts login form to authorization endpoint, code returned by redirect
+	browser := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	login := func(state, username, password string) (string, int) {
+		authURL, err := oidc.AuthCodeURL(state)
+		if err != nil {
+			t.Fatalf("auth code url failed: %v", err)
+		}
+		u, _ := url.Parse(authURL)
+		form := u.Query()
+		form.Set("username", username)
+		form.Set("password", password)
+		u.RawQuery = ""
+		resp, err := browser.PostForm(u.String(), form)
+		if err != nil {
+			t.Fatalf("login request failed: %v", err)
+		}
+		resp.Body.Close()
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if location.Query().Get("state") != state && resp.StatusCode == http.StatusFound {
+			t.Errorf("state %v not echoed back", state)
+		}
+		return location.Query().Get("code"), resp.StatusCode
+	}
+
+	if _, status := login(NewState(), "alice", "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("login with wrong password returned %v", status)
+	}
+
+	state := NewState()
+	code, status := login(state, "alice", "secret")
+	if status != http.StatusFound || code == "" {
+		t.Fatalf("login returned %v without code", status)
+	}
+	id, err := oidc.Exchange(code, state)
+	if err != nil {
+		t.Fatalf("code exchange failed: %v", err)
+	}
+	if id.Name != "alice" || mapRole(id.Groups) != account.RoleAdmin {
+		t.Errorf("unexpected identity %+v", id)
+	}
+
+	//code is valid only once
+	if _, err := oidc.Exchange(code, state); err == nil {
+		t.Errorf("code exchanged twice")
+	}
+
+	//id token is bound to state of the login it was issued for
+	code, _ = login(NewState(), "bob", "password")
+	if _, err := oidc.Exchange(code, state); err == nil {
+		t.Errorf("id token accepted with nonce of another login")
+	}
+}
@@ -0,0 +1,292 @@
+package identity
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/config"
+)
+
+//ldap result codes
+const (
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+var ldapTimeout = 10 * time.Second
+
+//Ldap provider, user entry searched by service account, password verified by binding as the user
+type ldapProvider struct {
+	url, bindDN, bindPassword, baseDN string
+	userFilter, emailAttr, groupAttr  string
+	insecureSkipVerify                bool
+}
+
+var _ PasswordProvider = &ldapProvider{}
+
+func newLdapProvider() *ldapProvider {
+
+	p := &ldapProvider{
+		url:                "ldap://127.0.0.1:389",
+		bindDN:             config.Identity.LdapBindDN,
+		bindPassword:       config.Identity.LdapBindPassword,
+		baseDN:             config.Identity.LdapBaseDN,
+		userFilter:         "(uid=%s)",
+		emailAttr:          "mail",
+		groupAttr:          "memberOf",
+		insecureSkipVerify: config.Identity.LdapInsecureSkipVerify,
+	}
+	if config.Identity.LdapURL != "" {
+		p.url = config.Identity.LdapURL
+	}
+	if config.Identity.LdapUserFilter != "" {
+		p.userFilter = config.Identity.LdapUserFilter
+	}
+	if config.Identity.LdapEmailAttribute != "" {
+		p.emailAttr = config.Identity.LdapEmailAttribute
+	}
+	if config.Identity.LdapGroupAttribute != "" {
+		p.groupAttr = config.Identity.LdapGroupAttribute
+	}
+
+	return p
+}
+
+func (p *ldapProvider) Authenticate(username, password string) (*Identity, error) {
+
+	//empty password means unauthenticated bind which always succeeds
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("ldap user name and password required")
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	if p.bindDN != "" {
+		if err := conn.bind(p.bindDN, p.bindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service account bind failed: %v", err)
+		}
+	}
+
+	filter := strings.Replace(p.userFilter, "%s", ldapEscapeFilter(username), -1)
+	entries, err := conn.search(p.baseDN, filter, []string{p.emailAttr, p.groupAttr})
+	if err != nil {
+		return nil, fmt.Errorf("ldap user search failed: %v", err)
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("ldap user %v not found or not unique", username)
+	}
+	entry := entries[0]
+
+	if err := conn.bind(entry.dn, password); err != nil {
+		return nil, err
+	}
+	log.Printf("Ldap user %v authenticated as %v", username, entry.dn)
+
+	id := &Identity{Name: username}
+	if values := entry.attrs[strings.ToLower(p.emailAttr)]; len(values) > 0 {
+		id.Email = values[0]
+	}
+	for _, group := range entry.attrs[strings.ToLower(p.groupAttr)] {
+		id.Groups = append(id.Groups, group, ldapGroupName(group))
+	}
+
+	return id, nil
+}
+
+//Common name of group dn, so admin groups can be given as cn=devlab-admins,... or devlab-admins
+func ldapGroupName(dn string) string {
+
+	first := strings.SplitN(dn, ",", 2)[0]
+	if kv := strings.SplitN(first, "=", 2); len(kv) == 2 {
+		return strings.TrimSpace(kv[1])
+	}
+
+	return dn
+}
+
+type ldapConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int
+}
+
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+func (p *ldapProvider) dial() (*ldapConn, error) {
+
+	u, err := url.Parse(p.url)
+	if err != nil {
+		return nil, fmt.Errorf("ldap url %v not valid: %v", p.url, err)
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	switch u.Scheme {
+	case "ldap":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{
+			ServerName:         u.Hostname(),
+			InsecureSkipVerify: p.insecureSkipVerify,
+		})
+	default:
+		return nil, fmt.Errorf("ldap url scheme %v not supported", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap server connect failed: %v", err)
+	}
+
+	return &ldapConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+//Send request, operation is the ber encoded protocol op
+func (c *ldapConn) send(operation []byte) (int, error) {
+
+	c.messageID++
+	c.conn.SetDeadline(time.Now().Add(ldapTimeout))
+	message := berConstructed(berTagSequence, berInt(berTagInteger, c.messageID), operation)
+	_, err := c.conn.Write(message)
+
+	return c.messageID, err
+}
+
+//Read response of message id, return protocol op
+func (c *ldapConn) receive(messageID int) (*berPacket, error) {
+
+	for {
+		packet, err := berRead(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if packet.Tag != berTagSequence || len(packet.Children) < 2 {
+			return nil, fmt.Errorf("ldap response not valid")
+		}
+		if packet.Children[0].Int() == messageID {
+			return packet.Children[1], nil
+		}
+	}
+}
+
+//Check ldap result of bind/search done response
+func ldapResult(op *berPacket) error {
+
+	if len(op.Children) < 3 {
+		return fmt.Errorf("ldap result not valid")
+	}
+	switch code := op.Children[0].Int(); code {
+	case ldapSuccess:
+		return nil
+	case ldapInvalidCredentials:
+		return fmt.Errorf("ldap invalid credentials")
+	default:
+		return fmt.Errorf("ldap error %v: %v", code, op.Children[2].String())
+	}
+}
+
+//Simple bind
+func (c *ldapConn) bind(dn, password string) error {
+
+	id, err := c.send(berConstructed(0x60,
+		berInt(berTagInteger, 3),
+		berString(berTagOctetString, dn),
+		berString(0x80, password),
+	))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.Tag != 0x61 {
+		return fmt.Errorf("ldap bind response not valid")
+	}
+
+	return ldapResult(op)
+}
+
+//Subtree search, attribute names in result are lower case
+func (c *ldapConn) search(baseDN, filter string, attributes []string) ([]ldapEntry, error) {
+
+	compiled, err := ldapCompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := [][]byte{}
+	for _, attr := range attributes {
+		attrs = append(attrs, berString(berTagOctetString, attr))
+	}
+
+	id, err := c.send(berConstructed(0x63,
+		berString(berTagOctetString, baseDN),
+		berInt(berTagEnumerated, 2), //wholeSubtree
+		berInt(berTagEnumerated, 0), //neverDerefAliases
+		berInt(berTagInteger, 2),    //size limit, more than one entry is an error anyway
+		berInt(berTagInteger, int(ldapTimeout/time.Second)),
+		berBool(false),
+		compiled,
+		berConstructed(berTagSequence, attrs...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []ldapEntry{}
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Tag {
+		case 0x64:
+			if len(op.Children) < 2 {
+				return nil, fmt.Errorf("ldap search entry not valid")
+			}
+			entry := ldapEntry{dn: op.Children[0].String(), attrs: make(map[string][]string)}
+			for _, attr := range op.Children[1].Children {
+				if len(attr.Children) < 2 {
+					continue
+				}
+				name := strings.ToLower(attr.Children[0].String())
+				for _, value := range attr.Children[1].Children {
+					entry.attrs[name] = append(entry.attrs[name], value.String())
+				}
+			}
+			entries = append(entries, entry)
+		case 0x73:
+			//search result reference, referrals not followed
+		case 0x65:
+			return entries, ldapResult(op)
+		default:
+			return nil, fmt.Errorf("ldap search response not valid")
+		}
+	}
+}
+
+func (c *ldapConn) close() {
+
+	//unbind request, no response
+	c.send(berEncode(0x42, nil))
+	c.conn.Close()
+}
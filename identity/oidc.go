@@ -0,0 +1,299 @@
+package identity
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/config"
+	"github.com/dgrijalva/jwt-go"
+)
+
+//OpenID connect provider, authorization code flow with id token verified against issuer jwks
+type oidcProvider struct {
+	issuer, clientID, clientSecret, redirectURL string
+	scopes                                      string
+	usernameClaim, groupsClaim                  string
+	client                                      *http.Client
+	//discovery document and signing keys, loaded on first use
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+	lock      sync.Mutex
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IdToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+var _ RedirectProvider = &oidcProvider{}
+
+func newOidcProvider() *oidcProvider {
+
+	p := &oidcProvider{
+		issuer:        strings.TrimSuffix(config.Identity.OidcIssuer, "/"),
+		clientID:      config.Identity.OidcClientID,
+		clientSecret:  config.Identity.OidcClientSecret,
+		redirectURL:   config.Identity.OidcRedirectURL,
+		scopes:        "openid profile email",
+		usernameClaim: "preferred_username",
+		groupsClaim:   "groups",
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+	if config.Identity.OidcScopes != "" {
+		p.scopes = config.Identity.OidcScopes
+	}
+	if config.Identity.OidcUsernameClaim != "" {
+		p.usernameClaim = config.Identity.OidcUsernameClaim
+	}
+	if config.Identity.OidcGroupsClaim != "" {
+		p.groupsClaim = config.Identity.OidcGroupsClaim
+	}
+	if p.issuer == "" || p.clientID == "" {
+		log.Println("Error: oidc issuer and client id required, oidc login will fail")
+	}
+
+	return p
+}
+
+func (p *oidcProvider) getJSON(target string, v interface{}) error {
+
+	resp, err := p.client.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v returned %v", target, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+//Load discovery document of issuer
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d oidcDiscovery
+	if err := p.getJSON(p.issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %v", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("oidc discovery issuer %v not match %v", d.Issuer, p.issuer)
+	}
+	p.discovery = &d
+
+	return p.discovery, nil
+}
+
+//Get signing key by key id, jwks reloaded if key not known, e.g. key rotated
+func (p *oidcProvider) signingKey(kid string) (*rsa.PublicKey, error) {
+
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if key, exists := p.keys[kid]; exists {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(d.JwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("oidc jwks load failed: %v", err)
+	}
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	key, exists := p.keys[kid]
+	if exists == false {
+		//single key without kid
+		if len(p.keys) == 1 && kid == "" {
+			for _, key := range p.keys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("oidc signing key %v not found", kid)
+	}
+
+	return key, nil
+}
+
+func (p *oidcProvider) AuthCodeURL(state string) (string, error) {
+
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.clientID)
+	params.Set("redirect_uri", p.redirectURL)
+	params.Set("scope", p.scopes)
+	params.Set("state", state)
+	params.Set("nonce", state)
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return d.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+func (p *oidcProvider) Exchange(code, state string) (*Identity, error) {
+
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var tokenResponse oidcTokenResponse
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("oidc token response not valid: %v", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || tokenResponse.IdToken == "" {
+		return nil, fmt.Errorf("oidc token request failed: %v %v %v", resp.Status, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+
+	claims, err := p.verify(tokenResponse.IdToken, d.Issuer, state)
+	if err != nil {
+		return nil, err
+	}
+
+	id := &Identity{}
+	id.Name, _ = claims[p.usernameClaim].(string)
+	if id.Name == "" {
+		return nil, fmt.Errorf("oidc id token has no %v claim", p.usernameClaim)
+	}
+	id.Email, _ = claims["email"].(string)
+	switch groups := claims[p.groupsClaim].(type) {
+	case string:
+		id.Groups = []string{groups}
+	case []interface{}:
+		for _, group := range groups {
+			if g, ok := group.(string); ok {
+				id.Groups = append(id.Groups, g)
+			}
+		}
+	}
+
+	return id, nil
+}
+
+//Verify id token signature, issuer, audience, expiry and nonce
+func (p *oidcProvider) verify(idToken, issuer, nonce string) (jwt.MapClaims, error) {
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA:
+			kid, _ := token.Header["kid"].(string)
+			return p.signingKey(kid)
+		case *jwt.SigningMethodHMAC:
+			if p.clientSecret == "" {
+				return nil, fmt.Errorf("hmac signed id token requires client secret")
+			}
+			return []byte(p.clientSecret), nil
+		default:
+			return nil, fmt.Errorf("id token signing method %v not supported", token.Header["alg"])
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("oidc id token not valid: %v", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != issuer {
+		return nil, fmt.Errorf("oidc id token issuer %v not match", iss)
+	}
+	audienceMatched := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audienceMatched = aud == p.clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == p.clientID {
+				audienceMatched = true
+			}
+		}
+	}
+	if audienceMatched == false {
+		return nil, fmt.Errorf("oidc id token audience not match client id")
+	}
+	if _, exists := claims["exp"]; exists == false {
+		return nil, fmt.Errorf("oidc id token has no expiry")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("oidc id token nonce not match")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,27 @@
+package identity
+
+//Identity asserted by provider after a successful login
+type Identity struct {
+	Name   string
+	Email  string
+	Groups []string
+}
+
+//Provider verifying account name and password directly, e.g. ldap bind
+type PasswordProvider interface {
+	Authenticate(username, password string) (*Identity, error)
+}
+
+//Provider redirecting browser to its login page, e.g. openid connect authorization code flow
+//state is echoed back on callback and bound into the issued token
+type RedirectProvider interface {
+	AuthCodeURL(state string) (string, error)
+	Exchange(code, state string) (*Identity, error)
+}
+
+//Provider info shown to login page
+type ProviderInfo struct {
+	Name string `json:"name"`
+	//password or redirect
+	Flow string `json:"flow"`
+}
@@ -0,0 +1,212 @@
+package image
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/node"
+)
+
+//Images known by deployer, kept until image table saved into db for the first time
+var ImageDB = ImageMap{Map: map[string]*Image{
+	"centos7":  {Name: "centos7", OSFamily: "centos", Version: "7", DefaultUser: "centos", MinDisk: 10, Nodes: []string{}, CreatedAt: time.Now()},
+	"ubuntu18": {Name: "ubuntu18", OSFamily: "ubuntu", Version: "18.04", DefaultUser: "ubuntu", MinDisk: 10, Nodes: []string{}, CreatedAt: time.Now()},
+}}
+
+//image name is passed to deployer as vm type, so restricted
+var imageNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+type ImageMap struct {
+	Map  map[string]*Image `json:"image"`
+	lock sync.RWMutex      `json:"-"`
+}
+
+type ImageMapItem struct {
+	Key   string
+	Value *Image
+}
+
+func (m *ImageMap) Set(key string, value *Image) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map[key] = value
+
+}
+
+func (m *ImageMap) Get(key string) (value *Image, exists bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	value, exists = m.Map[key]
+	return
+
+}
+
+func (m *ImageMap) Del(key string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.Map, key)
+
+}
+
+// Replace whole map, used by db restore
+func (m *ImageMap) Replace(newMap map[string]*Image) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copy of map and images made under lock, used by db to persist map
+func (m *ImageMap) Snapshot() map[string]*Image {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*Image, len(m.Map))
+	for k, v := range m.Map {
+		c := *v
+		c.Nodes = append([]string{}, v.Nodes...)
+		copied[k] = &c
+	}
+
+	return copied
+}
+
+// Iter iterates over the items in a concurrent map
+// Each item is sent over a channel, so that
+// we can iterate over the map using the builtin range keyword
+func (m *ImageMap) Iter() <-chan ImageMapItem {
+	c := make(chan ImageMapItem)
+
+	f := func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		for k, v := range m.Map {
+			c <- ImageMapItem{k, v}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+//Nodes holding image in cache, empty if image not found
+func (m *ImageMap) CachedOn(name string) []string {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if myImage, exists := m.Map[name]; exists {
+		return append([]string{}, myImage.Nodes...)
+	}
+
+	return []string{}
+}
+
+//Record node holding image in cache, e.g. after vm created from it
+func (m *ImageMap) MarkCached(name, nodeName string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	myImage, exists := m.Map[name]
+	if exists == false {
+		return
+	}
+	for _, n := range myImage.Nodes {
+		if n == nodeName {
+			return
+		}
+	}
+	myImage.Nodes = append(myImage.Nodes, nodeName)
+	sort.Strings(myImage.Nodes)
+	log.Printf("Image %v cached on node %v", name, nodeName)
+}
+
+//Remove node from cache list of all images, e.g. node removed
+func (m *ImageMap) ForgetNode(nodeName string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, myImage := range m.Map {
+		nodes := []string{}
+		for _, n := range myImage.Nodes {
+			if n != nodeName {
+				nodes = append(nodes, n)
+			}
+		}
+		myImage.Nodes = nodes
+	}
+}
+
+//Register image into catalog, existing image with same name updated
+//Nodes must be compute nodes already added
+func Register(imageRequest ImageRequest) (*Image, error) {
+
+	if imageNameRegexp.MatchString(imageRequest.Name) == false {
+		return nil, fmt.Errorf("image name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+
+	nodes := []string{}
+	seen := make(map[string]bool)
+	for _, n := range imageRequest.Nodes {
+		if seen[n] {
+			continue
+		}
+		myNode, exists := node.NodeDB.Get(n)
+		if exists == false {
+			return nil, fmt.Errorf("node %v not existed", n)
+		}
+		if myNode.Role != node.NodeRoleCompute {
+			return nil, fmt.Errorf("node %v is not a compute node", n)
+		}
+		seen[n] = true
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+
+	newImage := &Image{
+		Name:        imageRequest.Name,
+		OSFamily:    imageRequest.OSFamily,
+		Version:     imageRequest.Version,
+		DefaultUser: imageRequest.DefaultUser,
+		MinDisk:     imageRequest.MinDisk,
+		Nodes:       nodes,
+		CreatedAt:   time.Now(),
+	}
+	if oldImage, exists := ImageDB.Get(newImage.Name); exists {
+		newImage.CreatedAt = oldImage.CreatedAt
+	}
+	ImageDB.Set(newImage.Name, newImage)
+	log.Printf("Image %v registered, cached on nodes %v", newImage.Name, newImage.Nodes)
+
+	return newImage, nil
+}
+
+//Check vm of given disk size(unit GB) can be created from image
+func Validate(name string, disk int32) (*Image, error) {
+
+	myImage, exists := ImageDB.Get(name)
+	if exists == false {
+		return nil, &InvalidError{Name: name, Reason: "not found in catalog"}
+	}
+	if disk < myImage.MinDisk {
+		return nil, &InvalidError{Name: name, Reason: fmt.Sprintf("requires disk at least %vG", myImage.MinDisk)}
+	}
+
+	return myImage, nil
+}
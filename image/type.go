@@ -0,0 +1,38 @@
+package image
+
+import (
+	"fmt"
+	"time"
+)
+
+//VM image registered by admin, name is passed to deployer as vm type
+//Nodes are compute nodes holding image in local cache, preferred by scheduler
+type Image struct {
+	Name        string    `json:"name"`
+	OSFamily    string    `json:"osFamily"`
+	Version     string    `json:"version"`
+	DefaultUser string    `json:"defaultUser"`
+	MinDisk     int32     `json:"minDisk"`
+	Nodes       []string  `json:"nodes"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+//Existing image with same name is updated, minimum disk unit GB
+type ImageRequest struct {
+	Name        string   `json:"name" form:"name" binding:"required"`
+	OSFamily    string   `json:"osFamily" form:"osFamily" binding:"required"`
+	Version     string   `json:"version" form:"version"`
+	DefaultUser string   `json:"defaultUser" form:"defaultUser" binding:"required"`
+	MinDisk     int32    `json:"minDisk" form:"minDisk" binding:"min=0"`
+	Nodes       []string `json:"nodes" form:"nodes"`
+}
+
+//VM request not satisfied by image catalog
+type InvalidError struct {
+	Name   string
+	Reason string
+}
+
+func (e *InvalidError) Error() string {
+	return fmt.Sprintf("image %v %v", e.Name, e.Reason)
+}
@@ -35,6 +35,7 @@ func NewK8s(name string, k8sRequest K8sRequest) *K8S {
 		NumOfWorker:      k8sRequest.NumOfWorker,
 		Lifetime:         time.Duration(k8sRequest.Duration),
 		Status:           K8sStatusInit,
+		Project:          k8sRequest.Project,
 	}
 
 	return &newK8S
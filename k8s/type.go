@@ -25,6 +25,7 @@ type K8S struct {
 	Lifetime         time.Duration `json:"lifeTime"`
 	Status           K8sStatus     `json:"status"`
 	HostVm           string        `json:"hostVm"`
+	Project          string        `json:"project,omitempty"`
 	sync.RWMutex     `json:"-"`
 }
 
@@ -33,4 +34,6 @@ type K8sRequest struct {
 	NumOfContronller uint16 `form:"numOfContronller" json:"numOfContronller" binding:"omitempty,max=5"`
 	NumOfWorker      uint16 `form:"numOfWorker" json:"numOfWorker" binding:"omitempty,max=100"`
 	Duration         int    `form:"duration" json:"duration" binding:"omitempty"`
+	//project which k8s belongs to, given by api query parameter
+	Project string `form:"-" json:"-"`
 }
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/audit"
+	"github.com/JinlongWukong/DevLab/blueprint"
 	"github.com/JinlongWukong/DevLab/config"
 	"github.com/JinlongWukong/DevLab/db"
 	"github.com/JinlongWukong/DevLab/manager"
@@ -76,13 +78,60 @@ func (l LifeCycle) Control(ctx context.Context, wg *sync.WaitGroup) {
 						log.Printf("Accout %v vm %v lifetime is %v", ac.Value.Name, vm.Name, vmLifeTime)
 						if vmLifeTime <= 0 {
 							log.Printf("%v Lifetime is over, begin to delete vm", vm.Name)
+							record := audit.Record{
+								Source: audit.SourceLifecycle,
+								Actor:  audit.SourceLifecycle,
+								Action: "delete",
+								Kind:   "vm",
+								Target: vm.Name,
+								Owner:  ac.Value.Name,
+								Result: audit.ResultSuccess,
+							}
 							if err := workflow.ActionVM(ac.Value, vm, "delete"); err != nil {
 								log.Println(err)
+								record.Result = audit.ResultFailure
+								record.Error = err.Error()
 							}
+							audit.Log(record)
 						} else if vmLifeTime < 6*time.Hour {
 							ac.Value.SendNotification(fmt.Sprintf("Warning, Your VM %v still have %v life left", vm.Name, vmLifeTime))
 						}
 					}
+					//environment members are forever, torn down together with environment
+					envSlice := []*blueprint.Environment{}
+					for item := range ac.Value.IterEnvironment() {
+						envSlice = append(envSlice, item)
+					}
+					for _, env := range envSlice {
+						if env.GetLifeTime() >= forever || env.GetStatus() == blueprint.EnvironmentStatusDeleting {
+							continue
+						}
+						envLifeTime := env.ChangeLifeTime(-period)
+						log.Printf("Accout %v environment %v lifetime is %v", ac.Value.Name, env.Name, envLifeTime)
+						if envLifeTime <= 0 {
+							if env.GetStatus() == blueprint.EnvironmentStatusDeploying {
+								continue
+							}
+							log.Printf("%v Lifetime is over, begin to tear down environment", env.Name)
+							record := audit.Record{
+								Source: audit.SourceLifecycle,
+								Actor:  audit.SourceLifecycle,
+								Action: "delete",
+								Kind:   "environment",
+								Target: env.Name,
+								Owner:  ac.Value.Name,
+								Result: audit.ResultSuccess,
+							}
+							if err := workflow.DeleteEnvironment(ac.Value, env.Name); err != nil {
+								log.Println(err)
+								record.Result = audit.ResultFailure
+								record.Error = err.Error()
+							}
+							audit.Log(record)
+						} else if envLifeTime < 6*time.Hour {
+							ac.Value.SendNotification(fmt.Sprintf("Warning, Your environment %v still have %v life left", env.Name, envLifeTime))
+						}
+					}
 					db.NotifyToSave()
 				}
 			}
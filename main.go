@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
@@ -10,16 +11,38 @@ import (
 	"time"
 
 	"github.com/JinlongWukong/DevLab/api"
+	"github.com/JinlongWukong/DevLab/auth"
+	"github.com/JinlongWukong/DevLab/config"
 	"github.com/JinlongWukong/DevLab/db"
 	"github.com/JinlongWukong/DevLab/lifecycle"
 	"github.com/JinlongWukong/DevLab/manager"
 	"github.com/JinlongWukong/DevLab/network"
 	"github.com/JinlongWukong/DevLab/notification"
+	"github.com/JinlongWukong/DevLab/reconciler"
 	"github.com/JinlongWukong/DevLab/supervisor"
 )
 
 func main() {
 
+	//Restore db from remote backup before startup, e.g. --restore 2021-05-01T10:00:00+08:00 or --restore latest
+	restore := flag.String("restore", "", "restore db from remote sftp backup timestamp(or latest) before startup")
+	flag.Parse()
+
+	if err := config.CheckLoaded(); err != nil {
+		log.Fatalf("configuration file loading failed %v, program exited", err)
+	}
+
+	//Fail closed, tokens signed by missing or weak secret can be forged
+	if err := auth.CheckSecret(); err != nil {
+		log.Fatalf("%v, program exited", err)
+	}
+
+	if *restore != "" {
+		if err := db.RestoreOnStartup(*restore); err != nil {
+			log.Fatalf("Restore db from backup %v failed: %v", *restore, err)
+		}
+	}
+
 	//Used for stop service gracefully
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
@@ -32,6 +55,7 @@ func main() {
 		lifecycle.LifeCycle{},
 		supervisor.Supervisor{},
 		network.NetworkController{},
+		reconciler.Reconciler{},
 	)
 	for _, m := range managers {
 		wg.Add(1)
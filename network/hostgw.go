@@ -1,36 +1,24 @@
 package network
 
 import (
-	"encoding/json"
 	"log"
 
 	"github.com/JinlongWukong/DevLab/deployer"
 	"github.com/JinlongWukong/DevLab/node"
-	"github.com/JinlongWukong/DevLab/utils"
 )
 
 func updateRoutes(nodes []*node.Node) error {
 
-	hosts := [][]string{}
-	routes := []map[string]string{}
+	hosts := []deployer.Host{}
+	routes := []deployer.Route{}
 
 	for _, n := range nodes {
-		login := []string{n.IpAddress, n.UserName, n.Passwd, string(n.Role)}
-		route := map[string]string{"subnet": n.Subnet, "via": n.IpAddress}
-		hosts = append(hosts, login)
-		routes = append(routes, route)
+		hosts = append(hosts, n.DeployerHost())
+		routes = append(routes, deployer.Route{Subnet: n.Subnet, Via: n.IpAddress})
 	}
 
-	payload, _ := json.Marshal(map[string]interface{}{
-		"Hosts":  hosts,
-		"Routes": routes,
-		"Action": "route",
-	})
-
-	log.Println("Remote http call to update node route table")
-
-	url := deployer.GetDeployerBaseUrl() + "/hosts"
-	if err, _ := utils.HttpSendJsonData(url, "POST", payload); err != nil {
+	log.Println("Remote call to update node route table")
+	if err := deployer.GetClient().UpdateRoutes(hosts, routes); err != nil {
 		log.Println(err)
 		return err
 	}
@@ -1,9 +1,14 @@
 package node
 
 import (
+	"fmt"
 	"log"
 	"sync"
 	"sync/atomic"
+
+	"github.com/JinlongWukong/DevLab/deployer"
+	"github.com/JinlongWukong/DevLab/hostkey"
+	"github.com/JinlongWukong/DevLab/sshkey"
 )
 
 var NodeDB = NodeMap{Map: make(map[string]*Node)}
@@ -46,6 +51,29 @@ func (m *NodeMap) Del(key string) {
 
 }
 
+//Replace whole map, used by db restore
+func (m *NodeMap) Replace(newMap map[string]*Node) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+//Copy of map made under lock, nodes guarded by their own locks are shared
+func (m *NodeMap) Snapshot() map[string]*Node {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*Node, len(m.Map))
+	for k, v := range m.Map {
+		copied[k] = v
+	}
+
+	return copied
+}
+
 // Iter iterates over the items in a concurrent map
 // Each item is sent over a channel, so that
 // we can iterate over the map using the builtin range keyword
@@ -73,10 +101,12 @@ func (m *NodeMap) Iter() <-chan NodeMapItem {
 //   new node pointer
 func NewNode(nodeRequest NodeRequest) *Node {
 
-	if nodeRequest.IpAddress == "" ||
-		nodeRequest.User == "" ||
-		nodeRequest.Passwd == "" {
-		log.Println("Error: node ip,user,password must specify")
+	if nodeRequest.IpAddress == "" || nodeRequest.User == "" {
+		log.Println("Error: node ip,user must specify")
+		return nil
+	}
+	if err := nodeRequest.CheckCredential(); err != nil {
+		log.Printf("Error: %v", err)
 		return nil
 	}
 
@@ -87,20 +117,46 @@ func NewNode(nodeRequest NodeRequest) *Node {
 	}
 
 	newNode := Node{
-		Name:      nodeRequest.Name,
-		IpAddress: nodeRequest.IpAddress,
-		UserName:  nodeRequest.User,
-		Passwd:    nodeRequest.Passwd,
-		Role:      nodeRequest.Role,
-		Status:    NodeStatusInit,
-		State:     NodeStateEnable,
-		PortMap:   make(map[int]string),
-		Subnet:    subnet,
+		Name:       nodeRequest.Name,
+		IpAddress:  nodeRequest.IpAddress,
+		UserName:   nodeRequest.User,
+		Passwd:     nodeRequest.Passwd,
+		KeyName:    nodeRequest.KeyName,
+		PrivateKey: nodeRequest.PrivateKey,
+		Role:       nodeRequest.Role,
+		Status:     NodeStatusInit,
+		State:      NodeStateEnable,
+		PortMap:    make(map[int]string),
+		Subnet:     subnet,
 	}
 
 	return &newNode
 }
 
+//Check login credential of node request
+//Password or private key(uploaded or referenced by name from key store) must be given
+func (nodeRequest NodeRequest) CheckCredential() error {
+
+	if nodeRequest.Passwd == "" && nodeRequest.KeyName == "" && nodeRequest.PrivateKey == "" {
+		return fmt.Errorf("node password or ssh private key must specify")
+	}
+	if nodeRequest.KeyName != "" && nodeRequest.PrivateKey != "" {
+		return fmt.Errorf("only one of keyName and privateKey can be given")
+	}
+	if nodeRequest.KeyName != "" {
+		if _, err := sshkey.GetPrivateKey(nodeRequest.KeyName); err != nil {
+			return err
+		}
+	}
+	if nodeRequest.PrivateKey != "" {
+		if _, err := sshkey.ParsePrivateKey(nodeRequest.PrivateKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 //Get node pointer by name
 //Return nil if not existed
 func GetNodeByName(nodeName string) *Node {
@@ -114,6 +170,35 @@ func GetNodeByName(nodeName string) *Node {
 
 }
 
+//Get node login information for deployer
+func (myNode *Node) DeployerHost() deployer.Host {
+
+	return deployer.Host{
+		Ip:           myNode.IpAddress,
+		User:         myNode.UserName,
+		Pass:         myNode.Passwd,
+		PrivateKey:   myNode.LoginKey(),
+		Role:         string(myNode.Role),
+		KnownHostKey: hostkey.Known(myNode.IpAddress, 22),
+	}
+
+}
+
+//Get node login private key, key referenced by name is read from key store
+//Return empty if node login by password
+func (myNode *Node) LoginKey() string {
+
+	if myNode.KeyName != "" {
+		privateKey, err := sshkey.GetPrivateKey(myNode.KeyName)
+		if err != nil {
+			log.Printf("Node %v login key error: %v", myNode.Name, err)
+		}
+		return privateKey
+	}
+
+	return myNode.PrivateKey
+}
+
 //Set node state(enable/disbale)
 func (myNode *Node) SetState(state NodeState) {
 
@@ -235,5 +320,7 @@ func (myNode *Node) ReleasePort(port int) {
 	defer myNode.portMutex.Unlock()
 
 	delete(myNode.PortMap, port)
+	//port may be reused by another vm, which has a different host key
+	hostkey.Forget(myNode.IpAddress, port)
 
 }
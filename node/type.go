@@ -16,14 +16,17 @@ const (
 	NodeStatusUnhealth      NodeStatus = "unhealth"
 	NodeStatusOverload      NodeStatus = "overload"
 
-	NodeStateEnable  NodeState = "enable"
-	NodeStateDisable NodeState = "disable"
+	NodeStateEnable      NodeState = "enable"
+	NodeStateDisable     NodeState = "disable"
+	NodeStateMaintenance NodeState = "maintenance"
 
-	NodeActionAdd     NodeAction = "add"
-	NodeActionRemove  NodeAction = "remove"
-	NodeActionReboot  NodeAction = "reboot"
-	NodeActionEnable  NodeAction = "enable"
-	NodeActionDisable NodeAction = "disable"
+	NodeActionAdd      NodeAction = "add"
+	NodeActionRemove   NodeAction = "remove"
+	NodeActionReboot   NodeAction = "reboot"
+	NodeActionEnable   NodeAction = "enable"
+	NodeActionDisable  NodeAction = "disable"
+	NodeActionDrain    NodeAction = "drain"
+	NodeActionUncordon NodeAction = "uncordon"
 
 	NodeRoleCompute   NodeRole = "compute"
 	NodeRoleContainer NodeRole = "container"
@@ -36,6 +39,8 @@ type Node struct {
 	Name        string         `json:"name"`
 	UserName    string         `json:"user"`
 	Passwd      string         `json:"passwd"`
+	KeyName     string         `json:"keyName,omitempty"`
+	PrivateKey  string         `json:"privateKey,omitempty"`
 	Role        NodeRole       `json:"role"`
 	IpAddress   string         `json:"address"`
 	OSType      string         `json:"os"`
@@ -55,23 +60,21 @@ type Node struct {
 }
 
 type NodeRequest struct {
-	Name      string   `json:"name" form:"name" binding:"required"`
-	User      string   `json:"user" form:"user" binding:"required"`
-	Passwd    string   `json:"password" form:"password" binding:"required"`
-	IpAddress string   `json:"ip" form:"ip" binding:"required"`
-	Role      NodeRole `json:"role" form:"role" binding:"required"`
+	Name       string   `json:"name" form:"name" binding:"required"`
+	User       string   `json:"user" form:"user" binding:"required"`
+	Passwd     string   `json:"password" form:"password"`
+	KeyName    string   `json:"keyName" form:"keyName"`
+	PrivateKey string   `json:"privateKey" form:"privateKey"`
+	IpAddress  string   `json:"ip" form:"ip" binding:"required"`
+	Role       NodeRole `json:"role" form:"role" binding:"required"`
+	//optional, ssh host key fingerprint(SHA256:xxx) of node, trusted on first use if not given
+	HostKeyFingerprint string `json:"hostKeyFingerprint" form:"hostKeyFingerprint"`
 }
 
-type NodeInfo struct {
-	CPU    int32  `json:"cpu"`
-	Memory int32  `json:"memory"`
-	Disk   int32  `json:"disk"`
-	OSType string `json:"type"`
-}
-
-type NodeCondition struct {
-	CpuLoad   float64 `json:"cpu_load"`
-	MemAvail  int     `json:"memory_avail"`
-	DiskUsage string  `json:"disk_usage"`
-	Engine    uint8   `json:"engine_status"`
+//VMs are migrated off node unless stop given, containers are always stopped
+type NodeRequestDrain struct {
+	//shut down vms on node instead of migration
+	Stop bool `json:"stop" form:"stop"`
+	//running vms migrated live, shut off vms always cold migrated
+	Live bool `json:"live" form:"live"`
 }
@@ -0,0 +1,202 @@
+package project
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var ProjectDB = ProjectMap{Map: make(map[string]*Project)}
+
+type ProjectMap struct {
+	Map  map[string]*Project `json:"project"`
+	lock sync.RWMutex        `json:"-"`
+}
+
+type ProjectMapItem struct {
+	Key   string
+	Value *Project
+}
+
+//Add new project, creator becomes owner
+func (m *ProjectMap) Add(projectRequest ProjectRequest, owner string) (*Project, error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.Map[projectRequest.Name]; exists {
+		return nil, fmt.Errorf("project already existed")
+	}
+	newProject := &Project{
+		Name:        projectRequest.Name,
+		Description: projectRequest.Description,
+		Members:     map[string]Role{owner: RoleOwner},
+		CreatedAt:   time.Now(),
+	}
+	m.Map[newProject.Name] = newProject
+
+	return newProject, nil
+}
+
+func (m *ProjectMap) Get(key string) (value *Project, exists bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	value, exists = m.Map[key]
+	return
+
+}
+
+func (m *ProjectMap) Del(key string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.Map, key)
+
+}
+
+// Replace whole map, used by db restore
+func (m *ProjectMap) Replace(newMap map[string]*Project) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copy of map and projects made under lock, members copied under project lock
+func (m *ProjectMap) Snapshot() map[string]*Project {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*Project, len(m.Map))
+	for k, v := range m.Map {
+		v.RLock()
+		members := make(map[string]Role, len(v.Members))
+		for name, role := range v.Members {
+			members[name] = role
+		}
+		copied[k] = &Project{
+			Name:        v.Name,
+			Description: v.Description,
+			Members:     members,
+			CreatedAt:   v.CreatedAt,
+		}
+		v.RUnlock()
+	}
+
+	return copied
+}
+
+// Iter iterates over the items in a concurrent map
+// Each item is sent over a channel, so that
+// we can iterate over the map using the builtin range keyword
+func (m *ProjectMap) Iter() <-chan ProjectMapItem {
+	c := make(chan ProjectMapItem)
+
+	f := func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		for k, v := range m.Map {
+			c <- ProjectMapItem{k, v}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+//Remove account from all projects, used when account deleted
+func RemoveAccount(accountName string) {
+
+	for p := range ProjectDB.Iter() {
+		p.Value.Lock()
+		if _, exists := p.Value.Members[accountName]; exists {
+			delete(p.Value.Members, accountName)
+			log.Printf("Account %v removed from project %v", accountName, p.Key)
+		}
+		p.Value.Unlock()
+	}
+}
+
+//Check whether role is valid
+func ValidRole(role Role) bool {
+
+	_, exists := rolePermissions[role]
+	return exists
+}
+
+//Get role of account in project, empty if not a member
+func (p *Project) RoleOf(accountName string) Role {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.Members[accountName]
+}
+
+//Check whether account is granted the permission in project
+func (p *Project) Allowed(accountName string, perm Permission) bool {
+
+	for _, granted := range rolePermissions[p.RoleOf(accountName)] {
+		if granted == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Add member or change member role
+func (p *Project) SetMember(accountName string, role Role) error {
+
+	if ValidRole(role) == false {
+		return fmt.Errorf("project role %v not valid, must be one of owner, member, operator, viewer", role)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.Members[accountName] == RoleOwner && role != RoleOwner && p.numOfOwners() == 1 {
+		return fmt.Errorf("project %v must have at least one owner", p.Name)
+	}
+	p.Members[accountName] = role
+
+	return nil
+}
+
+//Remove member
+func (p *Project) RemoveMember(accountName string) error {
+
+	p.Lock()
+	defer p.Unlock()
+
+	role, exists := p.Members[accountName]
+	if exists == false {
+		return fmt.Errorf("account %v is not member of project %v", accountName, p.Name)
+	}
+	if role == RoleOwner && p.numOfOwners() == 1 {
+		return fmt.Errorf("project %v must have at least one owner", p.Name)
+	}
+	delete(p.Members, accountName)
+
+	return nil
+}
+
+func (p *Project) numOfOwners() int {
+
+	count := 0
+	for _, role := range p.Members {
+		if role == RoleOwner {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,52 @@
+package project
+
+import (
+	"sync"
+	"time"
+)
+
+type Role string
+type Permission string
+
+const (
+	//full control, include project membership management
+	RoleOwner Role = "owner"
+	//create/delete resources and operate them
+	RoleMember Role = "member"
+	//start/stop/reboot/console of existing resources
+	RoleOperator Role = "operator"
+	//read only
+	RoleViewer Role = "viewer"
+
+	PermRead    Permission = "read"
+	PermOperate Permission = "operate"
+	PermManage  Permission = "manage"
+	PermAdmin   Permission = "admin"
+)
+
+//Permissions granted by each role
+var rolePermissions = map[Role][]Permission{
+	RoleOwner:    {PermRead, PermOperate, PermManage, PermAdmin},
+	RoleMember:   {PermRead, PermOperate, PermManage},
+	RoleOperator: {PermRead, PermOperate},
+	RoleViewer:   {PermRead},
+}
+
+//Project(team) sharing vm, k8s and software among member accounts
+//Resources are still kept by the account who created them, tagged with project name
+type Project struct {
+	Name         string          `json:"name"`
+	Description  string          `json:"description"`
+	Members      map[string]Role `json:"members"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	sync.RWMutex `json:"-"`
+}
+
+type ProjectRequest struct {
+	Name        string `form:"name" json:"name" binding:"required"`
+	Description string `form:"description" json:"description"`
+}
+
+type MemberRequest struct {
+	Role Role `form:"role" json:"role" binding:"required"`
+}
@@ -0,0 +1,230 @@
+package quota
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/account"
+)
+
+var QuotaDB = QuotaMap{Map: make(map[string]*Quota)}
+
+type QuotaMap struct {
+	Map  map[string]*Quota `json:"quota"`
+	lock sync.RWMutex      `json:"-"`
+}
+
+type QuotaMapItem struct {
+	Key   string
+	Value *Quota
+}
+
+//Map key of quota, e.g. account/bob, project/team1
+func Key(scope Scope, name string) string {
+	return string(scope) + "/" + name
+}
+
+func (m *QuotaMap) Set(key string, value *Quota) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map[key] = value
+
+}
+
+func (m *QuotaMap) Get(key string) (value *Quota, exists bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	value, exists = m.Map[key]
+	return
+
+}
+
+func (m *QuotaMap) Del(key string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.Map, key)
+
+}
+
+// Replace whole map, used by db restore
+func (m *QuotaMap) Replace(newMap map[string]*Quota) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copy of map and quotas made under lock, used by db to persist map
+func (m *QuotaMap) Snapshot() map[string]*Quota {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*Quota, len(m.Map))
+	for k, v := range m.Map {
+		c := *v
+		copied[k] = &c
+	}
+
+	return copied
+}
+
+// Iter iterates over the items in a concurrent map
+// Each item is sent over a channel, so that
+// we can iterate over the map using the builtin range keyword
+func (m *QuotaMap) Iter() <-chan QuotaMapItem {
+	c := make(chan QuotaMapItem)
+
+	f := func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		for k, v := range m.Map {
+			c <- QuotaMapItem{k, v}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+//Set quota of account or project
+func SetQuota(scope Scope, name string, limit Resources) (*Quota, error) {
+
+	if scope != ScopeAccount && scope != ScopeProject {
+		return nil, fmt.Errorf("quota scope %v not valid, must be account or project", scope)
+	}
+	newQuota := &Quota{
+		Scope:     scope,
+		Name:      name,
+		Limit:     limit,
+		UpdatedAt: time.Now(),
+	}
+	QuotaDB.Set(Key(scope, name), newQuota)
+
+	return newQuota, nil
+}
+
+//Add up resources
+func (r *Resources) Add(other Resources) {
+	r.VM += other.VM
+	r.CPU += other.CPU
+	r.Memory += other.Memory
+	r.Disk += other.Disk
+	r.K8S += other.K8S
+	r.Software += other.Software
+}
+
+//Resources used by account, vm and software(container) both consume cpu/memory
+//Only resources tagged with project counted if projectName given
+func accountUsage(ac *account.Account, projectName string) Resources {
+
+	used := Resources{}
+	for myVm := range ac.Iter() {
+		if projectName == "" || myVm.Project == projectName {
+			used.VM++
+			used.CPU += int(myVm.CPU)
+			used.Memory += int(myVm.Memory)
+			used.Disk += int(myVm.Disk)
+		}
+	}
+	for myK8s := range ac.IterK8S() {
+		if projectName == "" || myK8s.Project == projectName {
+			used.K8S++
+		}
+	}
+	for mySoftware := range ac.IterSoftware() {
+		if projectName == "" || mySoftware.Project == projectName {
+			used.Software++
+			used.CPU += int(mySoftware.CPU)
+			used.Memory += int(mySoftware.Memory)
+		}
+	}
+
+	return used
+}
+
+//Current usage of account or project
+func GetUsage(scope Scope, name string) Usage {
+
+	usage := Usage{Scope: scope, Name: name}
+	switch scope {
+	case ScopeAccount:
+		if ac, exists := account.AccountDB.Get(name); exists {
+			usage.Used = accountUsage(ac, "")
+		}
+	case ScopeProject:
+		accounts := []*account.Account{}
+		for ac := range account.AccountDB.Iter() {
+			accounts = append(accounts, ac.Value)
+		}
+		for _, ac := range accounts {
+			usage.Used.Add(accountUsage(ac, name))
+		}
+	}
+	if q, exists := QuotaDB.Get(Key(scope, name)); exists {
+		limit := q.Limit
+		usage.Limit = &limit
+	}
+
+	return usage
+}
+
+//Check whether requested resources fit into quota of account or project
+//Return ExceededError naming the first limit hit
+func Check(scope Scope, name string, requested Resources) error {
+
+	q, exists := QuotaDB.Get(Key(scope, name))
+	if exists == false {
+		return nil
+	}
+	used := GetUsage(scope, name).Used
+
+	checks := []struct {
+		resource             string
+		limit, used, request int
+	}{
+		{"vm", q.Limit.VM, used.VM, requested.VM},
+		{"cpu", q.Limit.CPU, used.CPU, requested.CPU},
+		{"memory", q.Limit.Memory, used.Memory, requested.Memory},
+		{"disk", q.Limit.Disk, used.Disk, requested.Disk},
+		{"k8s", q.Limit.K8S, used.K8S, requested.K8S},
+		{"software", q.Limit.Software, used.Software, requested.Software},
+	}
+	for _, c := range checks {
+		if c.limit > 0 && c.request > 0 && c.used+c.request > c.limit {
+			return &ExceededError{
+				Scope:     scope,
+				Name:      name,
+				Resource:  c.resource,
+				Limit:     c.limit,
+				Used:      c.used,
+				Requested: c.request,
+			}
+		}
+	}
+
+	return nil
+}
+
+//Check quota of account and project which new resources belong to
+func CheckAll(accountName, projectName string, requested Resources) error {
+
+	if err := Check(ScopeAccount, accountName, requested); err != nil {
+		return err
+	}
+	if projectName != "" {
+		return Check(ScopeProject, projectName, requested)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package quota
+
+import (
+	"fmt"
+	"time"
+)
+
+type Scope string
+
+const (
+	ScopeAccount Scope = "account"
+	ScopeProject Scope = "project"
+)
+
+//Amount of resources, used for both limit and usage
+//Limit 0 means unlimited
+type Resources struct {
+	VM       int `form:"vm" json:"vm" binding:"min=0"`
+	CPU      int `form:"cpu" json:"cpu" binding:"min=0"`
+	Memory   int `form:"memory" json:"memory" binding:"min=0"` //MB
+	Disk     int `form:"disk" json:"disk" binding:"min=0"`     //GB
+	K8S      int `form:"k8s" json:"k8s" binding:"min=0"`
+	Software int `form:"software" json:"software" binding:"min=0"`
+}
+
+//Quota of an account or a project, set by admin
+type Quota struct {
+	Scope     Scope     `json:"scope"`
+	Name      string    `json:"name"`
+	Limit     Resources `json:"limit"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+//Current usage of an account or a project, limit is empty if no quota set
+type Usage struct {
+	Scope Scope      `json:"scope"`
+	Name  string     `json:"name"`
+	Limit *Resources `json:"limit,omitempty"`
+	Used  Resources  `json:"used"`
+}
+
+//Returned when request would exceed quota
+type ExceededError struct {
+	Scope     Scope
+	Name      string
+	Resource  string
+	Limit     int
+	Used      int
+	Requested int
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%v %v quota exceeded: %v limit %v, used %v, requested %v",
+		e.Scope, e.Name, e.Resource, e.Limit, e.Used, e.Requested)
+}
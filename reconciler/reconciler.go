@@ -0,0 +1,35 @@
+package reconciler
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/JinlongWukong/DevLab/db"
+	"github.com/JinlongWukong/DevLab/manager"
+	"github.com/JinlongWukong/DevLab/workflow"
+)
+
+//Reconciler resumes in-flight workflows once at startup
+type Reconciler struct {
+}
+
+var _ manager.Manager = Reconciler{}
+
+func (r Reconciler) Control(ctx context.Context, wg *sync.WaitGroup) {
+
+	log.Println("Reconciler manager started")
+	defer func() {
+		log.Println("Reconciler manager exited")
+		wg.Done()
+	}()
+
+	//Wait until persisted data loaded
+	select {
+	case <-ctx.Done():
+		return
+	case <-db.Loaded():
+	}
+
+	workflow.Reconcile()
+}
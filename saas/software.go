@@ -39,6 +39,7 @@ func NewSoftware(name string, softwareRequest SoftwareRequest) *Software {
 		Memory:          softwareRequest.Memory,
 		PortMapping:     map[string]string{},
 		AdditionalInfor: map[string]string{},
+		Project:         softwareRequest.Project,
 	}
 
 	newSoftware.SetStatus(SoftwareStatusInit)
@@ -38,6 +38,7 @@ type Software struct {
 	Status          SoftwareStatus    `json:"status"`
 	PortMapping     map[string]string `json:"port_mapping"`
 	AdditionalInfor map[string]string `json:"additional_infor"`
+	Project         string            `json:"project,omitempty"`
 	statusMutex     sync.RWMutex      `json:"-"`
 	sync.Mutex      `json:"-"`
 }
@@ -47,6 +48,8 @@ type SoftwareRequest struct {
 	Version string `form:"version" json:"version" binding:"required"`
 	CPU     uint8  `form:"cpu" json:"cpu" binding:"required,min=1,max=20"`
 	Memory  uint32 `form:"memory" json:"memory" binding:"required,min=10,max=65536"`
+	//project which software belongs to, given by api query parameter
+	Project string `form:"-" json:"-"`
 }
 
 type SoftwareRequestAction struct {
@@ -54,22 +57,3 @@ type SoftwareRequestAction struct {
 	Name    string         `form:"name" json:"name"`
 	Action  SoftwareAction `form:"action" json:"action"`
 }
-
-/*
-{
-    "additional_infor": {
-        "admin_password": "33c426556ee546949703391ce86a9cb6"
-    },
-    "address": "172.19.2.3",
-    "port_mapping": [
-        "50000/tcp -> 0.0.0.0:49187",
-        "8080/tcp -> 0.0.0.0:49188"
-    ]
-}
-*/
-type SoftwareInfo struct {
-	Status          string            `json:"status"`
-	Address         string            `json:"address"`
-	PortMapping     []string          `json:"port_mapping"`
-	AdditionalInfor map[string]string `json:"additional_infor,omitempty"`
-}
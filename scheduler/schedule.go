@@ -7,6 +7,7 @@ import (
 	"sort"
 
 	"github.com/JinlongWukong/DevLab/config"
+	"github.com/JinlongWukong/DevLab/image"
 	"github.com/JinlongWukong/DevLab/node"
 )
 
@@ -23,18 +24,17 @@ func init() {
 	}
 }
 
-//apply for a node
-func Schedule(role node.NodeRole, reqCpu, reqMem, reqDisk int32) *node.Node {
+//apply for a node, nodes named in exclude are skipped, e.g. source node of migration
+//nodes holding image in cache are preferred, empty image means no preference
+func Schedule(role node.NodeRole, imageName string, reqCpu, reqMem, reqDisk int32, exclude ...string) *node.Node {
 	//filter all nodes
 	winerNodes := make([]*node.Node, 0)
 	for n := range node.NodeDB.Iter() {
-		if role != n.Value.Role {
+		if role != n.Value.Role || excluded(n.Value.Name, exclude) {
 			continue
 		} else if n.Value.State != node.NodeStateEnable ||
 			n.Value.Status != node.NodeStatusReady ||
-			(n.Value.CPU*int32(allocationRatio)-n.Value.GetCpuUsed()) < reqCpu ||
-			(n.Value.Memory*int32(allocationRatio)-n.Value.GetMemUsed()) < reqMem ||
-			(n.Value.Disk*int32(allocationRatio)-n.Value.GetDiskUsed()) < reqDisk {
+			HasCapacity(n.Value, reqCpu, reqMem, reqDisk) == false {
 			continue
 		} else {
 			winerNodes = append(winerNodes, n.Value)
@@ -44,6 +44,9 @@ func Schedule(role node.NodeRole, reqCpu, reqMem, reqDisk int32) *node.Node {
 		log.Println("No available node left")
 		return nil
 	}
+	if imageName != "" {
+		winerNodes = preferCached(winerNodes, image.ImageDB.CachedOn(imageName))
+	}
 	//Select one node based on scheduleAlgorithm
 	if scheduleAlgorithm == "random" {
 		return winerNodes[rand.Intn(len(winerNodes))]
@@ -54,6 +57,41 @@ func Schedule(role node.NodeRole, reqCpu, reqMem, reqDisk int32) *node.Node {
 	}
 }
 
+func excluded(name string, exclude []string) bool {
+	for _, e := range exclude {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+//Keep only nodes holding image in cache, all nodes kept if none of them has it
+func preferCached(filtedNodes []*node.Node, cached []string) []*node.Node {
+	cachedSet := make(map[string]bool)
+	for _, name := range cached {
+		cachedSet[name] = true
+	}
+	cachedNodes := make([]*node.Node, 0)
+	for _, n := range filtedNodes {
+		if cachedSet[n.Name] {
+			cachedNodes = append(cachedNodes, n)
+		}
+	}
+	if len(cachedNodes) == 0 {
+		return filtedNodes
+	}
+	return cachedNodes
+}
+
+//Check whether node has enough resources left for request, allocation ratio applied
+func HasCapacity(n *node.Node, reqCpu, reqMem, reqDisk int32) bool {
+
+	return (n.CPU*int32(allocationRatio)-n.GetCpuUsed()) >= reqCpu &&
+		(n.Memory*int32(allocationRatio)-n.GetMemUsed()) >= reqMem &&
+		(n.Disk*int32(allocationRatio)-n.GetDiskUsed()) >= reqDisk
+}
+
 //Select a node which have biggest weight
 //weight=the percent of cpu left*100 + the percent of mem left*100 + the percent of disk left*100
 func weightSelector(filtedNodes []*node.Node) *node.Node {
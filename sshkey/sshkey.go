@@ -0,0 +1,152 @@
+package sshkey
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var KeyDB = KeyMap{Map: make(map[string]*Key)}
+
+type KeyMap struct {
+	Map  map[string]*Key `json:"sshkey"`
+	lock sync.RWMutex    `json:"-"`
+}
+
+type KeyMapItem struct {
+	Key   string
+	Value *Key
+}
+
+func (m *KeyMap) Set(key string, value *Key) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map[key] = value
+
+}
+
+func (m *KeyMap) Get(key string) (value *Key, exists bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	value, exists = m.Map[key]
+	return
+
+}
+
+func (m *KeyMap) Del(key string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.Map, key)
+
+}
+
+// Replace whole map, used by db restore
+func (m *KeyMap) Replace(newMap map[string]*Key) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copy of map and keys made under lock, used by db to persist map
+func (m *KeyMap) Snapshot() map[string]*Key {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copied := make(map[string]*Key, len(m.Map))
+	for k, v := range m.Map {
+		c := *v
+		copied[k] = &c
+	}
+
+	return copied
+}
+
+// Iter iterates over the items in a concurrent map
+// Each item is sent over a channel, so that
+// we can iterate over the map using the builtin range keyword
+func (m *KeyMap) Iter() <-chan KeyMapItem {
+	c := make(chan KeyMapItem)
+
+	f := func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		for k, v := range m.Map {
+			c <- KeyMapItem{k, v}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+//New key from PEM encoded private key, passphrase protected key not supported
+func NewKey(keyRequest KeyRequest) (*Key, error) {
+
+	signer, err := ParsePrivateKey(keyRequest.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		Name:        keyRequest.Name,
+		PrivateKey:  keyRequest.PrivateKey,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+		Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+//Parse PEM encoded private key
+func ParsePrivateKey(privateKey string) (ssh.Signer, error) {
+
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+
+	return signer, nil
+}
+
+//Parse one public key in authorized_keys format, return normalized key(comment kept) and fingerprint
+func ParsePublicKey(publicKey string) (string, string, error) {
+
+	pub, comment, _, rest, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid public key: %v", err)
+	}
+	if strings.TrimSpace(string(rest)) != "" {
+		return "", "", fmt.Errorf("invalid public key: only one key allowed")
+	}
+
+	key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	if comment != "" {
+		key += " " + comment
+	}
+
+	return key, ssh.FingerprintSHA256(pub), nil
+}
+
+//Get private key content by name
+func GetPrivateKey(name string) (string, error) {
+
+	key, exists := KeyDB.Get(name)
+	if exists == false {
+		return "", fmt.Errorf("ssh key %v not found", name)
+	}
+
+	return key.PrivateKey, nil
+}
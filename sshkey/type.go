@@ -0,0 +1,17 @@
+package sshkey
+
+import "time"
+
+//Private key used to login remote host(node), referenced by name
+type Key struct {
+	Name        string    `json:"name"`
+	PrivateKey  string    `json:"privateKey"`
+	PublicKey   string    `json:"publicKey"`
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type KeyRequest struct {
+	Name       string `json:"name" form:"name" binding:"required"`
+	PrivateKey string `json:"privateKey" form:"privateKey" binding:"required"`
+}
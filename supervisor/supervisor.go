@@ -2,7 +2,6 @@ package supervisor
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"strconv"
 	"strings"
@@ -14,7 +13,6 @@ import (
 	"github.com/JinlongWukong/DevLab/deployer"
 	"github.com/JinlongWukong/DevLab/manager"
 	"github.com/JinlongWukong/DevLab/node"
-	"github.com/JinlongWukong/DevLab/utils"
 )
 
 var nodeCheckInterval = "180s"
@@ -86,29 +84,18 @@ func (s Supervisor) Control(ctx context.Context, wg *sync.WaitGroup) {
 					case <-ctx.Done():
 						return
 					default:
-						query := map[string]string{
-							"Ip":   n.IpAddress,
-							"Pass": n.Passwd,
-							"User": n.UserName,
-							"Role": string(n.Role),
-						}
-						log.Printf("Remote http call to check node usage %v", n.Name)
-						var nodeCondition node.NodeCondition
-						url := deployer.GetDeployerBaseUrl() + "/host"
-						err, reponse_data := utils.HttpGetJsonData(url, query)
+						log.Printf("Remote call to check node usage %v", n.Name)
+						nodeCondition, err := deployer.GetClient().GetHostCondition(n.DeployerHost())
 						if err != nil {
-							log.Printf("Remote http call to check node %v usage failed with error -> %v", n.Name, err)
-							if strings.Contains(err.Error(), "unexpected status-code returned") {
+							log.Printf("Remote call to check node %v usage failed with error -> %v", n.Name, err)
+							if strings.Contains(err.Error(), "unexpected status-code returned") ||
+								strings.Contains(err.Error(), "not installed") {
 								//if err occur, set node as unhealth status
 								n.SetStatus(node.NodeStatusUnhealth)
 								db.NotifyToSave()
 							}
 						} else {
-							log.Printf("Remote http call to check node %v successfully", n.Name)
-							if err := json.Unmarshal(reponse_data, &nodeCondition); err != nil {
-								log.Printf("Parse node %v response data failed -> %v", n.Name, err)
-								break
-							}
+							log.Printf("Remote call to check node %v successfully", n.Name)
 							diskUsage, _ := strconv.Atoi(strings.Split(nodeCondition.DiskUsage, "%")[0])
 							//If at least one of below conditions not satisfied, means overload
 							if nodeCondition.CpuLoad > float64(n.CPU)*nodeLimitCPU ||
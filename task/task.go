@@ -0,0 +1,317 @@
+package task
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/config"
+	"github.com/JinlongWukong/DevLab/utils"
+)
+
+var TaskDB = TaskMap{Map: make(map[string]*Task)}
+
+//Finished tasks kept for days, and at most this number of tasks kept, pending/running ones never pruned
+var taskKeepDays, taskKeepMax = 30, 1000
+
+func init() {
+	if config.Workflow.TaskKeepDays > 0 {
+		taskKeepDays = config.Workflow.TaskKeepDays
+	}
+	if config.Workflow.TaskKeepMax > 0 {
+		taskKeepMax = config.Workflow.TaskKeepMax
+	}
+}
+
+type TaskMap struct {
+	Map  map[string]*Task `json:"task"`
+	lock sync.RWMutex     `json:"-"`
+}
+
+type TaskMapItem struct {
+	Key   string
+	Value *Task
+}
+
+func (m *TaskMap) Get(key string) (task *Task, exists bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	task, exists = m.Map[key]
+	return
+
+}
+
+func (m *TaskMap) Del(key string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.Map, key)
+
+}
+
+// Replace whole map, used by db restore
+func (m *TaskMap) Replace(newMap map[string]*Task) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Map = newMap
+}
+
+// Copies of all tasks made under lock of task map, used by db to persist tasks while they are still changing
+func (m *TaskMap) Snapshot() map[string]*Task {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	tasks := make(map[string]*Task, len(m.Map))
+	for k, v := range m.Map {
+		tasks[k] = v.Copy()
+	}
+
+	return tasks
+}
+
+// Iter iterates over the items in a concurrent map
+// Each item is sent over a channel, so that
+// we can iterate over the map using the builtin range keyword
+func (m *TaskMap) Iter() <-chan TaskMapItem {
+	c := make(chan TaskMapItem)
+
+	f := func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		for k, v := range m.Map {
+			c <- TaskMapItem{k, v}
+		}
+		close(c)
+	}
+	go f()
+
+	return c
+}
+
+// List tasks sorted by creation time, newest first
+// Args:
+//   accountName -> only tasks owned by this account returned, all tasks if empty
+func (m *TaskMap) List(accountName string) []*Task {
+
+	tasks := []*Task{}
+	for t := range m.Iter() {
+		if accountName == "" || t.Value.Account == accountName {
+			tasks = append(tasks, t.Value)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt.After(tasks[j].CreatedAt)
+	})
+
+	return tasks
+}
+
+// New a task and register it into task db
+// Args:
+//   kind    -> task kind
+//   account -> owner of task
+//   targets -> objects name this task working on
+// Return:
+//   new task pointer
+func NewTask(kind TaskKind, account string, targets ...string) *Task {
+
+	now := time.Now()
+	newTask := &Task{
+		Kind:      kind,
+		Account:   account,
+		Targets:   targets,
+		Status:    TaskStatusPending,
+		Steps:     []*Step{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	TaskDB.lock.Lock()
+	defer TaskDB.lock.Unlock()
+	for {
+		newTask.Id = strings.ToLower(utils.RandomString(12))
+		if _, exists := TaskDB.Map[newTask.Id]; exists == false {
+			break
+		}
+	}
+	TaskDB.Map[newTask.Id] = newTask
+	log.Printf("Task %v created, kind -> %v, targets -> %v", newTask.Id, kind, targets)
+	TaskDB.prune(now)
+
+	return newTask
+}
+
+// Remove finished tasks expired, then oldest finished ones until number of tasks within limit
+// Caller holds lock of task map
+func (m *TaskMap) prune(now time.Time) {
+
+	finished := []*Task{}
+	for _, t := range m.Map {
+		t.RLock()
+		done := t.Status == TaskStatusSuccess || t.Status == TaskStatusFailed
+		t.RUnlock()
+		if done {
+			finished = append(finished, t)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].CreatedAt.Before(finished[j].CreatedAt)
+	})
+
+	expiry := now.Add(-time.Hour * 24 * time.Duration(taskKeepDays))
+	pruned := 0
+	for _, t := range finished {
+		if t.CreatedAt.After(expiry) && len(m.Map) <= taskKeepMax {
+			break
+		}
+		delete(m.Map, t.Id)
+		pruned++
+	}
+	if pruned > 0 {
+		log.Printf("%v finished tasks pruned", pruned)
+	}
+}
+
+// Copy of task made under task lock, safe to be serialized while task still in progress
+func (t *Task) Copy() *Task {
+
+	t.RLock()
+	defer t.RUnlock()
+
+	steps := make([]*Step, 0, len(t.Steps))
+	for _, step := range t.Steps {
+		s := *step
+		steps = append(steps, &s)
+	}
+
+	return &Task{
+		Id:         t.Id,
+		Kind:       t.Kind,
+		Account:    t.Account,
+		Targets:    append([]string{}, t.Targets...),
+		Status:     t.Status,
+		Steps:      steps,
+		Error:      t.Error,
+		CreatedAt:  t.CreatedAt,
+		UpdatedAt:  t.UpdatedAt,
+		FinishedAt: t.FinishedAt,
+	}
+}
+
+// Start a new step, task will be set to running as well
+func (t *Task) StartStep(name, target string) {
+
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	t.Status = TaskStatusRunning
+	t.UpdatedAt = now
+	t.Steps = append(t.Steps, &Step{
+		Name:      name,
+		Target:    target,
+		Status:    TaskStatusRunning,
+		StartedAt: now,
+	})
+
+}
+
+// End the latest running step with given name and target
+// Args:
+//   err -> nil means step success, otherwise failed
+func (t *Task) EndStep(name, target string, err error) {
+
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	t.UpdatedAt = now
+	for i := len(t.Steps) - 1; i >= 0; i-- {
+		step := t.Steps[i]
+		if step.Name == name && step.Target == target && step.Status == TaskStatusRunning {
+			step.FinishedAt = now
+			if err == nil {
+				step.Status = TaskStatusSuccess
+			} else {
+				step.Status = TaskStatusFailed
+				step.Error = err.Error()
+			}
+			return
+		}
+	}
+
+	log.Printf("Task %v step %v on %v not started, skip", t.Id, name, target)
+}
+
+// Attach a message to the latest step with given name and target
+func (t *Task) StepMessage(name, target, msg string) {
+
+	t.Lock()
+	defer t.Unlock()
+
+	for i := len(t.Steps) - 1; i >= 0; i-- {
+		if t.Steps[i].Name == name && t.Steps[i].Target == target {
+			t.Steps[i].Message = msg
+			return
+		}
+	}
+}
+
+// Finish task, task failed if err given or any step failed
+func (t *Task) Finish(err error) {
+
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	t.UpdatedAt = now
+	t.FinishedAt = now
+
+	failed := []string{}
+	for _, step := range t.Steps {
+		if step.Status == TaskStatusRunning {
+			step.Status = TaskStatusFailed
+			step.Error = "step not finished"
+			step.FinishedAt = now
+		}
+		if step.Status == TaskStatusFailed {
+			if step.Target == "" {
+				failed = append(failed, fmt.Sprintf("%v: %v", step.Name, step.Error))
+			} else {
+				failed = append(failed, fmt.Sprintf("%v %v: %v", step.Name, step.Target, step.Error))
+			}
+		}
+	}
+
+	if err != nil {
+		t.Status = TaskStatusFailed
+		t.Error = err.Error()
+	} else if len(failed) > 0 {
+		t.Status = TaskStatusFailed
+		t.Error = strings.Join(failed, "; ")
+	} else {
+		t.Status = TaskStatusSuccess
+	}
+	log.Printf("Task %v finished with status %v", t.Id, t.Status)
+
+}
+
+// Get task status
+func (t *Task) GetStatus() TaskStatus {
+
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.Status
+
+}
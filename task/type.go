@@ -0,0 +1,64 @@
+package task
+
+import (
+	"sync"
+	"time"
+)
+
+type TaskStatus string
+type TaskKind string
+
+const (
+	TaskStatusPending TaskStatus = "pending"
+	TaskStatusRunning TaskStatus = "running"
+	TaskStatusSuccess TaskStatus = "success"
+	TaskStatusFailed  TaskStatus = "failed"
+
+	TaskKindCreateVm       TaskKind = "createVm"
+	TaskKindCreateK8s      TaskKind = "createK8s"
+	TaskKindCreateSoftware TaskKind = "createSoftware"
+	TaskKindAddNode        TaskKind = "addNode"
+	TaskKindMigrateVm      TaskKind = "migrateVm"
+	TaskKindDrainNode      TaskKind = "drainNode"
+	TaskKindDeployEnv      TaskKind = "deployEnvironment"
+	TaskKindCloneVm        TaskKind = "cloneVm"
+
+	StepSchedule     = "schedule"
+	StepInstantiate  = "instantiate"
+	StepFetchStatus  = "fetchStatus"
+	StepDnat         = "dnat"
+	StepAddons       = "addons"
+	StepBootVm       = "bootVm"
+	StepInstallK8s   = "installK8s"
+	StepInstallSaaS  = "installSoftware"
+	StepInstallNode  = "installNode"
+	StepNotification = "notification"
+	StepMigrate      = "migrate"
+	StepStop         = "stop"
+	StepCreate       = "create"
+	StepExpose       = "expose"
+)
+
+type Step struct {
+	Name       string     `json:"name"`
+	Target     string     `json:"target"`
+	Status     TaskStatus `json:"status"`
+	Message    string     `json:"message,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt time.Time  `json:"finishedAt,omitempty"`
+}
+
+type Task struct {
+	Id           string     `json:"id"`
+	Kind         TaskKind   `json:"kind"`
+	Account      string     `json:"account"`
+	Targets      []string   `json:"targets"`
+	Status       TaskStatus `json:"status"`
+	Steps        []*Step    `json:"steps"`
+	Error        string     `json:"error,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+	FinishedAt   time.Time  `json:"finishedAt,omitempty"`
+	sync.RWMutex `json:"-"`
+}
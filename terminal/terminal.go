@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"time"
 	"unicode/utf8"
 
+	"github.com/JinlongWukong/DevLab/hostkey"
 	"github.com/gorilla/websocket"
 	"golang.org/x/crypto/ssh"
 )
@@ -24,9 +24,15 @@ func (self *wapperWS) Write(buf []byte) (int, error) {
 }
 
 func NewSSHTerminal(username, password, ip string, port uint16) SSHTerminal {
+	return NewSSHTerminalWithKey(username, password, "", ip, port)
+}
+
+// New ssh terminal login by PEM encoded private key, password is used as fallback if given
+func NewSSHTerminalWithKey(username, password, privateKey, ip string, port uint16) SSHTerminal {
 	terminal := SSHTerminal{}
 	terminal.Username = username
 	terminal.Password = password
+	terminal.PrivateKey = privateKey
 	terminal.IpAddress = ip
 	terminal.Port = port
 	return terminal
@@ -35,16 +41,28 @@ func NewSSHTerminal(username, password, ip string, port uint16) SSHTerminal {
 // Dial ssh connection
 func (st *SSHTerminal) Connect() error {
 	authM := make([]ssh.AuthMethod, 0)
-	authM = append(authM, ssh.Password(st.Password))
+	if st.PrivateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(st.PrivateKey))
+		if err != nil {
+			return fmt.Errorf("invalid private key: %v", err)
+		}
+		authM = append(authM, ssh.PublicKeys(signer))
+	}
+	if st.Password != "" || st.PrivateKey == "" {
+		authM = append(authM, ssh.Password(st.Password))
+	}
 	clientConfig := &ssh.ClientConfig{
 		User:    st.Username,
 		Auth:    authM,
 		Timeout: 5 * time.Second,
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
 	}
 	addr := fmt.Sprintf("%s:%d", st.IpAddress, st.Port)
+	// Host key verified against known host keys, name is shown in mismatch error
+	if st.Name == "" {
+		clientConfig.HostKeyCallback = hostkey.Callback(addr)
+	} else {
+		clientConfig.HostKeyCallback = hostkey.Callback(st.Name)
+	}
 	client, err := ssh.Dial("tcp", addr, clientConfig)
 	if err != nil {
 		return err
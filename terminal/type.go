@@ -14,13 +14,15 @@ type ptyRequestParams struct {
 }
 
 type SSHTerminal struct {
-	Username  string `json:"username"`
-	Password  string `json:"password"`
-	IpAddress string `json:"ipaddress"`
-	Port      uint16 `json:"port"`
-	Session   *ssh.Session
-	Client    *ssh.Client
-	channel   ssh.Channel
+	Name       string `json:"name"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	PrivateKey string `json:"privateKey"`
+	IpAddress  string `json:"ipaddress"`
+	Port       uint16 `json:"port"`
+	Session    *ssh.Session
+	Client     *ssh.Client
+	channel    ssh.Channel
 }
 
 type ContainerTerminal struct {
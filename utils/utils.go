@@ -2,16 +2,18 @@ package utils
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
+	"math/big"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 )
 
 func HttpSendJsonData(uri string, method string, data []byte) (error, []byte) {
@@ -94,12 +96,35 @@ func WriteJsonFile(path string, v interface{}) error {
 		return err
 	}
 
-	err = ioutil.WriteFile(path, file, 0644)
+	return WriteFileAtomic(path, file, 0644)
+}
+
+// Write data to a temp file in same directory then rename it to path
+// Path is either old content or new content even if crashed in middle
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 
-	return err
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
 }
 
 // Load Json data from file path
@@ -118,10 +143,15 @@ func ReadJsonFile(path string) ([]byte, error) {
 func RandomString(n int) string {
 	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
+	//crypto random, used for passwords
 	s := make([]rune, n)
-	rand.Seed(time.Now().UnixNano())
+	max := big.NewInt(int64(len(letters)))
 	for i := range s {
-		s[i] = letters[rand.Intn(len(letters))]
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			log.Fatalf("random string generation failed: %v", err)
+		}
+		s[i] = letters[index.Int64()]
 	}
 	return string(s)
 }
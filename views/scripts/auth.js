@@ -10,3 +10,27 @@ function getLoginInfo() {
         token
     };
 }
+
+// access token is short-lived, renew it by refresh token once on 401 and retry the request
+if (typeof axios !== "undefined") {
+    axios.interceptors.response.use(undefined, function (error) {
+        var config = error.config;
+        var refreshToken = window.localStorage.getItem("refresh_token");
+        if (!error.response || error.response.status !== 401 || !refreshToken ||
+            !config || config.retried || config.url.indexOf("/token/refresh") >= 0) {
+            return Promise.reject(error);
+        }
+        config.retried = true;
+        return axios.post(location.origin + "/token/refresh", {refresh_token: refreshToken})
+            .then(function (response) {
+                window.localStorage.setItem("access_token", response.data.access_token);
+                window.localStorage.setItem("refresh_token", response.data.refresh_token);
+                config.headers["Authorization"] = "Bearer " + response.data.access_token;
+                return axios(config);
+            })
+            .catch(function () {
+                window.localStorage.removeItem("refresh_token");
+                return Promise.reject(error);
+            });
+    });
+}
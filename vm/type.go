@@ -10,52 +10,64 @@ const (
 	VmStatusScheduled = "scheduled"
 	VmStatusCreating  = "creating"
 	VmStatusRunning   = "running"
+	VmStatusShutoff   = "shutoff"
+	VmStatusFailed    = "failed"
 	VmStatusDeleting  = "deleting"
 	VmStatusDeleted   = "deleted"
 )
 
-var flavorDetails = map[string]map[string]int32{
-	"small": {
-		"cpu":    2,
-		"memory": 2048,
-		"disk":   30,
-	},
-	"middle": {
-		"cpu":    4,
-		"memory": 4096,
-		"disk":   64,
-	},
-	"large": {
-		"cpu":    6,
-		"memory": 8192,
-		"disk":   80,
-	},
-}
-
 type VncInfo struct {
 	Port string `json:"port"`
 	Pass string `json:"passwd"`
 }
 
 type VirtualMachine struct {
-	Name         string         `json:"name"`
-	Hostname     string         `json:"hostname"`
-	CPU          int32          `json:"cpu"`
-	Memory       int32          `json:"mem"`
-	Disk         int32          `json:"disk"`
-	IpAddress    string         `json:"address"`
-	Status       string         `json:"status"`
-	Vnc          VncInfo        `json:"vnc"`
-	NoVnc        string         `json:"novnc"`
-	Type         string         `json:"type"`
-	Node         string         `json:"node"`
-	NodeAddress  string         `json:"nodeAddress"`
-	Lifetime     time.Duration  `json:"lifeTime"`
-	PortMap      map[int]string `json:"portMap"`
-	RootPass     string         `json:"rootPass"`
-	Addons       []string       `json:"addons"`
+	Name        string         `json:"name"`
+	Hostname    string         `json:"hostname"`
+	CPU         int32          `json:"cpu"`
+	Memory      int32          `json:"mem"`
+	Disk        int32          `json:"disk"`
+	IpAddress   string         `json:"address"`
+	Status      string         `json:"status"`
+	Vnc         VncInfo        `json:"vnc"`
+	NoVnc       string         `json:"novnc"`
+	Type        string         `json:"type"`
+	Node        string         `json:"node"`
+	NodeAddress string         `json:"nodeAddress"`
+	Lifetime    time.Duration  `json:"lifeTime"`
+	PortMap     map[int]string `json:"portMap"`
+	RootPass    string         `json:"rootPass"`
+	Addons      []string       `json:"addons"`
+	Project     string         `json:"project,omitempty"`
+	Snapshots   []*Snapshot    `json:"snapshots,omitempty"`
+	SshKeys     []string       `json:"sshKeys,omitempty"`
+	UserData    string         `json:"userData,omitempty"`
+	MigratingTo string         `json:"migratingTo,omitempty"`
+	//flavor vm created from or resized to, empty if custom size
+	Flavor     string            `json:"flavor,omitempty"`
+	ExtraSpecs map[string]string `json:"extraSpecs,omitempty"`
+	//source vm disk copied from, nil if created from image
+	Clone        *CloneSource `json:"clone,omitempty"`
 	sync.RWMutex `json:"-" gob:"-"`
 	lifeMutex    sync.RWMutex `json:"-"`
+	snapMutex    sync.RWMutex `json:"-"`
+	//number of clones copying disk of this vm
+	cloneRefs int32 `json:"-"`
+}
+
+//Disk of source vm on node is copied, or snapshot of it if given
+type CloneSource struct {
+	VM       string `json:"vm"`
+	Node     string `json:"node"`
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+//Point-in-time snapshot of vm, size(unit MB) charged against node disk
+type Snapshot struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Size        int32     `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
 }
 
 type VmRequest struct {
@@ -69,16 +81,49 @@ type VmRequest struct {
 	Number   int32    `form:"numbers" json:"numbers" binding:"required,min=1,max=5"`
 	Duration int      `form:"duration" json:"duration" binding:"required"`
 	Addons   []string `form:"addons" json:"addons"`
+	//public keys in authorized_keys format, or names of keys in account key library
+	SshKeys []string `form:"sshKeys" json:"sshKeys"`
+	//cloud-init user-data, e.g. #cloud-config or shell script
+	UserData string `form:"userData" json:"userData"`
+	//project which vm belongs to, given by api query parameter
+	Project string `form:"-" json:"-"`
+}
+
+type VmRequestSnapshot struct {
+	//generated from creation time if not given
+	Name        string `form:"name" json:"name"`
+	Description string `form:"description" json:"description"`
+}
+
+//Either flavor or custom size given, unset custom field keeps current value
+type VmRequestResize struct {
+	Flavor string `form:"flavor" json:"flavor"`
+	CPU    int32  `form:"cpu" json:"cpu" binding:"min=0"`
+	Memory int32  `form:"mem" json:"memory" binding:"min=0"`
+	Disk   int32  `form:"disk" json:"disk" binding:"min=0"`
+}
+
+type VmRequestSnapshotRevert struct {
+	Snapshot string `form:"snapshot" json:"snapshot" binding:"required"`
+}
+
+//Target node picked by scheduler if not given, live migration keeps vm running
+type VmRequestMigrate struct {
+	Node string `form:"node" json:"node"`
+	Live bool   `form:"live" json:"live"`
+}
+
+//Clone keeps flavor, type and addons of source vm, disk copied from snapshot if given, otherwise source must be shut off
+type VmRequestClone struct {
+	Snapshot string `form:"snapshot" json:"snapshot"`
+	Hostname string `form:"hostname" json:"hostname"`
+	//same node makes disk copy fast, otherwise any node with capacity
+	SameNode bool `form:"sameNode" json:"sameNode"`
+	//unit day, lifetime left of source vm if not given
+	Duration int `form:"duration" json:"duration" binding:"min=0"`
 }
 
 type VmRequestPortExpose struct {
 	Port     int    `form:"port" json:"port" binding:"required,min=1"`
 	Protocol string `form:"protocol,default=tcp" json:"protocol,default=tcp" binding:"required"`
 }
-
-type VmLiveStatus struct {
-	Name    string `json:"name"`
-	Status  string `json:"status"`
-	Address string `json:"address"`
-	VncPort string `json:"vncPort"`
-}
@@ -1,36 +1,33 @@
 package vm
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/JinlongWukong/DevLab/deployer"
+	"github.com/JinlongWukong/DevLab/flavor"
 	"github.com/JinlongWukong/DevLab/node"
 	"github.com/JinlongWukong/DevLab/utils"
 )
 
-//check parameters, return struct pointer if ok, otherwise return nil
-func NewVirtualMachine(name, flavor, vmType, hostname, rootPass string, cpu, mem, disk int32, Duration time.Duration, addons []string) *VirtualMachine {
-
-	//There is a mapping bt flavor and cpu/memory
-	if flavor != "" {
-		detail, err := GetFlavordetail(flavor)
-		if err == nil {
-			cpu = detail["cpu"]
-			mem = detail["memory"]
-			disk = detail["disk"]
-		} else {
-			log.Println(err)
-		}
+//check parameters, size of flavor used if given, otherwise custom cpu/mem/disk
+func NewVirtualMachine(name string, myFlavor *flavor.Flavor, vmType, hostname, rootPass string, cpu, mem, disk int32, Duration time.Duration, addons []string) (*VirtualMachine, error) {
+
+	flavorName := ""
+	var extraSpecs map[string]string
+	if myFlavor != nil {
+		flavorName = myFlavor.Name
+		cpu, mem, disk = myFlavor.CPU, myFlavor.Memory, myFlavor.Disk
+		extraSpecs = myFlavor.ExtraSpecs
 	}
 
-	if cpu == 0 || mem == 0 || disk == 0 {
+	if cpu <= 0 || mem <= 0 || disk <= 0 {
 		log.Println("Error: one of cpu, mem, disk is zero give")
-		return nil
+		return nil, fmt.Errorf("flavor or cpu, mem, disk required")
 	}
 
 	if hostname == "" {
@@ -46,19 +43,64 @@ func NewVirtualMachine(name, flavor, vmType, hostname, rootPass string, cpu, mem
 		rootPass = utils.RandomString(8)
 	}
 
+	return &VirtualMachine{
+		Name:       name,
+		Hostname:   hostname,
+		CPU:        cpu,
+		Memory:     mem,
+		Disk:       disk,
+		Status:     VmStatusInit,
+		Vnc:        vnc,
+		Type:       vmType,
+		Lifetime:   Duration,
+		PortMap:    map[int]string{},
+		RootPass:   rootPass,
+		Addons:     addons,
+		Flavor:     flavorName,
+		ExtraSpecs: extraSpecs,
+	}, nil
+}
+
+//New vm with same size, flavor, type and addons of source, root/vnc passwords generated
+//Caller holds lock of source vm
+func NewCloneVirtualMachine(name string, source *VirtualMachine, hostname, snapshot string, Duration time.Duration) *VirtualMachine {
+
+	if hostname == "" {
+		hostname = name
+	}
+	var extraSpecs map[string]string
+	if source.ExtraSpecs != nil {
+		extraSpecs = make(map[string]string, len(source.ExtraSpecs))
+		for k, v := range source.ExtraSpecs {
+			extraSpecs[k] = v
+		}
+	}
+
 	return &VirtualMachine{
 		Name:     name,
 		Hostname: hostname,
-		CPU:      cpu,
-		Memory:   mem,
-		Disk:     disk,
+		CPU:      source.CPU,
+		Memory:   source.Memory,
+		Disk:     source.Disk,
 		Status:   VmStatusInit,
-		Vnc:      vnc,
-		Type:     vmType,
-		Lifetime: Duration,
-		PortMap:  map[int]string{},
-		RootPass: rootPass,
-		Addons:   addons,
+		Vnc: VncInfo{
+			Port: "unknown",
+			Pass: utils.RandomString(8),
+		},
+		Type:       source.Type,
+		Lifetime:   Duration,
+		PortMap:    map[int]string{},
+		RootPass:   utils.RandomString(8),
+		Addons:     append([]string{}, source.Addons...),
+		Project:    source.Project,
+		SshKeys:    append([]string{}, source.SshKeys...),
+		Flavor:     source.Flavor,
+		ExtraSpecs: extraSpecs,
+		Clone: &CloneSource{
+			VM:       source.Name,
+			Node:     source.Node,
+			Snapshot: snapshot,
+		},
 	}
 }
 
@@ -75,27 +117,32 @@ func (myvm *VirtualMachine) CreateVirtualMachine() error {
 		return err
 	}
 
-	payload, _ := json.Marshal(map[string]interface{}{
-		"vmName":     myvm.Name,
-		"vmHostname": myvm.Hostname,
-		"vmAction":   "create",
-		"vmMemory":   myvm.Memory,
-		"vmVcpus":    myvm.CPU,
-		"vmDisk":     myvm.Disk,
-		"vmType":     myvm.Type,
-		"vncPass":    myvm.Vnc.Pass,
-		"rootPass":   myvm.RootPass,
-		"hostIp":     mynode.IpAddress,
-		"hostPass":   mynode.Passwd,
-		"hostUser":   mynode.UserName,
-	})
-
-	log.Println("Remote http call to create vm")
-	url := deployer.GetDeployerBaseUrl() + "/vm"
-	err, _ := utils.HttpSendJsonData(url, "POST", payload)
+	spec := deployer.VmSpec{
+		Name:     myvm.Name,
+		Hostname: myvm.Hostname,
+		CPU:      myvm.CPU,
+		Memory:   myvm.Memory,
+		Disk:     myvm.Disk,
+		Type:     myvm.Type,
+		VncPass:  myvm.Vnc.Pass,
+		RootPass: myvm.RootPass,
+		SshKeys:  myvm.SshKeys,
+		UserData: myvm.UserData,
+		//flavor hints
+		ExtraSpecs: myvm.ExtraSpecs,
+	}
+	var err error
+	if myvm.Clone == nil {
+		err = deployer.GetClient().CreateVm(mynode.DeployerHost(), spec)
+	} else if sourceNode := node.GetNodeByName(myvm.Clone.Node); sourceNode == nil {
+		err = fmt.Errorf("Error: Node %v of clone source vm %v not found", myvm.Clone.Node, myvm.Clone.VM)
+	} else {
+		log.Printf("Cloning vm %v from vm %v on Host %v, snapshot -> %v", myvm.Name, myvm.Clone.VM, myvm.Clone.Node, myvm.Clone.Snapshot)
+		err = deployer.GetClient().CloneVm(sourceNode.DeployerHost(), mynode.DeployerHost(), myvm.Clone.VM, myvm.Clone.Snapshot, spec)
+	}
 	if err != nil {
 		log.Println(err)
-		myvm.Status = fmt.Sprint(err)
+		myvm.Status = VmStatusFailed
 		return err
 	} else {
 		myvm.Status = VmStatusRunning
@@ -110,7 +157,7 @@ func (myvm *VirtualMachine) CreateVirtualMachine() error {
 // Return:
 //    nil    -> success
 //    error  -> failed
-func (myvm *VirtualMachine) genericActionVirtualMachine(action string) error {
+func (myvm *VirtualMachine) genericActionVirtualMachine(action deployer.VmAction) error {
 
 	mynode := node.GetNodeByName(myvm.Node)
 	if mynode == nil {
@@ -119,17 +166,7 @@ func (myvm *VirtualMachine) genericActionVirtualMachine(action string) error {
 		return err
 	}
 
-	payload, _ := json.Marshal(map[string]interface{}{
-		"vmName":   myvm.Name,
-		"vmAction": action,
-		"hostIp":   mynode.IpAddress,
-		"hostPass": mynode.Passwd,
-		"hostUser": mynode.UserName,
-	})
-
-	log.Printf("Remote http call to %v vm", action)
-	url := deployer.GetDeployerBaseUrl() + "/vm"
-	err, _ := utils.HttpSendJsonData(url, "POST", payload)
+	err := deployer.GetClient().ActionVm(mynode.DeployerHost(), myvm.Name, action)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -142,28 +179,69 @@ func (myvm *VirtualMachine) DeleteVirtualMachine() error {
 
 	log.Printf("Deleting vm %v on Host %v", myvm.Name, myvm.Node)
 
-	return myvm.genericActionVirtualMachine("delete")
+	return myvm.genericActionVirtualMachine(deployer.VmActionDelete)
 }
 
 func (myvm *VirtualMachine) StartUpVirtualMachine() error {
 
 	log.Printf("Starting vm %v on Host %v", myvm.Name, myvm.Node)
 
-	return myvm.genericActionVirtualMachine("start")
+	return myvm.genericActionVirtualMachine(deployer.VmActionStart)
 }
 
 func (myvm *VirtualMachine) ShutDownVirtualMachine() error {
 
 	log.Printf("Shuting down vm %v on Host %v", myvm.Name, myvm.Node)
 
-	return myvm.genericActionVirtualMachine("shutdown")
+	return myvm.genericActionVirtualMachine(deployer.VmActionShutdown)
 }
 
 func (myvm *VirtualMachine) RebootVirtualMachine() error {
 
 	log.Printf("Rebooting vm %v on Host %v", myvm.Name, myvm.Node)
 
-	return myvm.genericActionVirtualMachine("reboot")
+	return myvm.genericActionVirtualMachine(deployer.VmActionReboot)
+}
+
+//Apply new cpu/memory/disk to vm on node, vm keeps old size if failed
+func (myvm *VirtualMachine) ResizeVirtualMachine(cpu, mem, disk int32) error {
+
+	mynode := node.GetNodeByName(myvm.Node)
+	if mynode == nil {
+		err := fmt.Errorf("Error: Node %v not found", myvm.Node)
+		log.Println(err)
+		return err
+	}
+
+	log.Printf("Resizing vm %v on Host %v to cpu %v, memory %v, disk %v", myvm.Name, myvm.Node, cpu, mem, disk)
+	err := deployer.GetClient().ResizeVm(mynode.DeployerHost(), myvm.Name, cpu, mem, disk)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	myvm.CPU, myvm.Memory, myvm.Disk = cpu, mem, disk
+
+	return nil
+}
+
+//Move vm from hosted node to target node, caller takes care of node and dnat switch
+func (myvm *VirtualMachine) MigrateVirtualMachine(target *node.Node, live bool) error {
+
+	mynode := node.GetNodeByName(myvm.Node)
+	if mynode == nil {
+		err := fmt.Errorf("Error: Node %v not found", myvm.Node)
+		log.Println(err)
+		return err
+	}
+
+	log.Printf("Migrating vm %v from Host %v to %v, live -> %v", myvm.Name, myvm.Node, target.Name, live)
+	err := deployer.GetClient().MigrateVm(mynode.DeployerHost(), target.DeployerHost(), myvm.Name, live)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
 }
 
 // Sync up VM status
@@ -178,22 +256,11 @@ func (myvm *VirtualMachine) GetVirtualMachineLiveStatus() error {
 		return err
 	}
 
-	//vmName=test-1\&hostIp=127.0.0.1\&hostPass=xxxxx\&hostUser=root
-	query := map[string]string{
-		"vmName":   myvm.Name,
-		"hostIp":   mynode.IpAddress,
-		"hostUser": mynode.UserName,
-		"hostPass": mynode.Passwd,
-	}
-
-	var vmStatus VmLiveStatus
-	url := deployer.GetDeployerBaseUrl() + "/vm"
-	err, reponse_data := utils.HttpGetJsonData(url, query)
+	vmStatus, err := deployer.GetClient().GetVmStatus(mynode.DeployerHost(), myvm.Name)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	json.Unmarshal(reponse_data, &vmStatus)
 
 	myvm.Status = vmStatus.Status
 	myvm.IpAddress = vmStatus.Address
@@ -212,26 +279,18 @@ func (myvm *VirtualMachine) ActionDnatRule(port []int, action string) error {
 		return err
 	}
 
-	var rules []map[string]string
+	var rules []deployer.DnatRule
 	for _, p := range port {
-		rules = append(rules, map[string]string{
-			"dport":       strings.Split(myvm.PortMap[p], ":")[0],
-			"destination": strings.Split(myvm.IpAddress, "/")[0] + ":" + strconv.Itoa(p),
-			"state":       action,
-			"protocol":    strings.Split(myvm.PortMap[p], ":")[1],
+		rules = append(rules, deployer.DnatRule{
+			Dport:       strings.Split(myvm.PortMap[p], ":")[0],
+			Destination: strings.Split(myvm.IpAddress, "/")[0] + ":" + strconv.Itoa(p),
+			State:       action,
+			Protocol:    strings.Split(myvm.PortMap[p], ":")[1],
 		})
 	}
 
-	payload, _ := json.Marshal(map[string]interface{}{
-		"rules": rules,
-		"Ip":    mynode.IpAddress,
-		"Pass":  mynode.Passwd,
-		"User":  mynode.UserName,
-	})
-
-	log.Printf("Remote http call to %v dnat rule", action)
-	url := deployer.GetDeployerBaseUrl() + "/host/dnat"
-	err, _ := utils.HttpSendJsonData(url, "POST", payload)
+	log.Printf("Remote call to %v dnat rule", action)
+	err := deployer.GetClient().ActionDnat(mynode.DeployerHost(), rules)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -241,6 +300,17 @@ func (myvm *VirtualMachine) ActionDnatRule(port []int, action string) error {
 
 }
 
+//Get login information of vm through node dnat ssh port
+func (myvm *VirtualMachine) DeployerTarget() deployer.Host {
+
+	return deployer.Host{
+		Ip:   myvm.NodeAddress,
+		Port: strings.Split(myvm.PortMap[22], ":")[0],
+		User: "root",
+		Pass: myvm.RootPass,
+	}
+}
+
 func (myvm *VirtualMachine) InstallAddons() error {
 
 	mynode := node.GetNodeByName(myvm.Node)
@@ -250,16 +320,7 @@ func (myvm *VirtualMachine) InstallAddons() error {
 		return err
 	}
 
-	payload, _ := json.Marshal(map[string]interface{}{
-		"Address":  mynode.IpAddress,
-		"Passwd":   myvm.RootPass,
-		"Username": "root",
-		"Port":     strings.Split(myvm.PortMap[22], ":")[0],
-		"Addons":   myvm.Addons,
-	})
-	log.Printf("Remote http call to install addons %v", myvm.Addons)
-	url := deployer.GetDeployerBaseUrl() + "/vm/addons"
-	err, _ := utils.HttpSendJsonData(url, "POST", payload)
+	err := deployer.GetClient().InstallAddons(myvm.DeployerTarget(), myvm.Addons)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -283,13 +344,138 @@ func (myvm *VirtualMachine) GetLifeTime() time.Duration {
 	return myvm.Lifetime
 }
 
-func GetFlavordetail(flavor string) (map[string]int32, error) {
+//Generic snapshot action(create/revert/delete)
+func (myvm *VirtualMachine) actionSnapshot(snapshot string, action deployer.SnapshotAction) (deployer.SnapshotInfo, error) {
 
-	//There is a mapping bt flavor and cpu/memory
-	detail, exists := flavorDetails[flavor]
-	if exists == true {
-		return detail, nil
+	mynode := node.GetNodeByName(myvm.Node)
+	if mynode == nil {
+		err := fmt.Errorf("Error: Node %v not found", myvm.Node)
+		log.Println(err)
+		return deployer.SnapshotInfo{}, err
+	}
+
+	log.Printf("Snapshot %v of vm %v on Host %v, action %v", snapshot, myvm.Name, myvm.Node, action)
+	info, err := deployer.GetClient().ActionSnapshot(mynode.DeployerHost(), myvm.Name, snapshot, action)
+	if err != nil {
+		log.Println(err)
+		return info, err
+	}
+
+	return info, nil
+}
+
+//Take snapshot of vm, snapshot recorded on vm with size reported by deployer
+func (myvm *VirtualMachine) CreateSnapshot(name, description string) (*Snapshot, error) {
+
+	if _, err := myvm.GetSnapshotByName(name); err == nil {
+		return nil, fmt.Errorf("snapshot %v already existed", name)
+	}
+	info, err := myvm.actionSnapshot(name, deployer.SnapshotActionCreate)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Name:        name,
+		Description: description,
+		Size:        info.Size,
+		CreatedAt:   time.Now(),
 	}
+	myvm.snapMutex.Lock()
+	myvm.Snapshots = append(myvm.Snapshots, snapshot)
+	myvm.snapMutex.Unlock()
+
+	return snapshot, nil
+}
+
+//Revert vm to snapshot
+func (myvm *VirtualMachine) RevertSnapshot(name string) error {
+
+	if _, err := myvm.GetSnapshotByName(name); err != nil {
+		return err
+	}
+	_, err := myvm.actionSnapshot(name, deployer.SnapshotActionRevert)
+
+	return err
+}
+
+//Delete snapshot from node and vm, deleted snapshot returned for disk recycle
+func (myvm *VirtualMachine) DeleteSnapshot(name string) (*Snapshot, error) {
+
+	snapshot, err := myvm.GetSnapshotByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := myvm.actionSnapshot(name, deployer.SnapshotActionDelete); err != nil {
+		return nil, err
+	}
+	myvm.RemoveSnapshot(name)
+
+	return snapshot, nil
+}
+
+//Remove snapshot record only
+func (myvm *VirtualMachine) RemoveSnapshot(name string) {
+
+	myvm.snapMutex.Lock()
+	defer myvm.snapMutex.Unlock()
+
+	for i, snapshot := range myvm.Snapshots {
+		if snapshot.Name == name {
+			myvm.Snapshots = append(myvm.Snapshots[:i], myvm.Snapshots[i+1:]...)
+			return
+		}
+	}
+}
+
+func (myvm *VirtualMachine) GetSnapshotByName(name string) (*Snapshot, error) {
+
+	myvm.snapMutex.RLock()
+	defer myvm.snapMutex.RUnlock()
+
+	for _, snapshot := range myvm.Snapshots {
+		if snapshot.Name == name {
+			return snapshot, nil
+		}
+	}
+
+	return nil, fmt.Errorf("snapshot %v not found", name)
+}
+
+//Copy of snapshot list, oldest first
+func (myvm *VirtualMachine) GetSnapshots() []*Snapshot {
+
+	myvm.snapMutex.RLock()
+	defer myvm.snapMutex.RUnlock()
+
+	return append([]*Snapshot{}, myvm.Snapshots...)
+}
+
+//Total disk size of snapshots, unit MB
+func (myvm *VirtualMachine) GetSnapshotsSize() int32 {
+
+	myvm.snapMutex.RLock()
+	defer myvm.snapMutex.RUnlock()
+
+	var size int32
+	for _, snapshot := range myvm.Snapshots {
+		size += snapshot.Size
+	}
+
+	return size
+}
+
+//Mark vm as source of clone in progress, disk and snapshots must be kept as is until released
+func (myvm *VirtualMachine) HoldCloneSource() {
+	atomic.AddInt32(&myvm.cloneRefs, 1)
+}
+
+//Release vm after disk copied by clone
+func (myvm *VirtualMachine) ReleaseCloneSource() {
+	atomic.AddInt32(&myvm.cloneRefs, -1)
+}
 
-	return nil, fmt.Errorf("flavor not found")
+//Whether any clone still copying disk of vm
+func (myvm *VirtualMachine) IsCloneSource() bool {
+	return atomic.LoadInt32(&myvm.cloneRefs) > 0
 }
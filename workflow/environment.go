@@ -0,0 +1,286 @@
+package workflow
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/blueprint"
+	"github.com/JinlongWukong/DevLab/db"
+	"github.com/JinlongWukong/DevLab/flavor"
+	"github.com/JinlongWukong/DevLab/image"
+	"github.com/JinlongWukong/DevLab/k8s"
+	"github.com/JinlongWukong/DevLab/quota"
+	"github.com/JinlongWukong/DevLab/saas"
+	"github.com/JinlongWukong/DevLab/task"
+	"github.com/JinlongWukong/DevLab/utils"
+	"github.com/JinlongWukong/DevLab/vm"
+)
+
+//Lifetime(unit day) of member vms, environment lifetime applies instead, same as k8s host vm
+const environmentMemberDuration = 365
+
+//Member of environment being deployed, tracked by its own creation task
+type environmentMember struct {
+	name  string
+	task  *task.Task
+	ports []blueprint.PortTemplate
+}
+
+//Deploy blueprint as one environment of account
+//Catalog and quota of all resources are checked before any of them created
+//This is async call, the returned task can be used to track deployment progress
+func DeployBlueprint(myAccount *account.Account, myBlueprint *blueprint.Blueprint, r blueprint.DeployRequest) (*blueprint.Environment, *task.Task, error) {
+
+	requested := quota.Resources{}
+	for _, t := range myBlueprint.VMs {
+		cpu, mem, disk := t.CPU, t.Memory, t.Disk
+		if t.Flavor != "" {
+			myFlavor, err := flavor.Lookup(t.Flavor, string(myAccount.Role), r.Project)
+			if err != nil {
+				return nil, nil, err
+			}
+			cpu, mem, disk = myFlavor.CPU, myFlavor.Memory, myFlavor.Disk
+		}
+		if _, err := image.Validate(t.Type, disk); err != nil {
+			return nil, nil, err
+		}
+		requested.VM += int(t.Number)
+		requested.CPU += int(cpu * t.Number)
+		requested.Memory += int(mem * t.Number)
+		requested.Disk += int(disk * t.Number)
+	}
+	for _, t := range myBlueprint.K8S {
+		hostFlavor, err := k8sHostFlavorOf(t.NumOfWorker)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := image.Validate(k8sHostImage, hostFlavor.Disk); err != nil {
+			return nil, nil, err
+		}
+		requested.K8S++
+		requested.VM++
+		requested.CPU += int(hostFlavor.CPU)
+		requested.Memory += int(hostFlavor.Memory)
+		requested.Disk += int(hostFlavor.Disk)
+	}
+	for _, t := range myBlueprint.Software {
+		requested.Software++
+		requested.CPU += int(t.CPU)
+		requested.Memory += int(t.Memory)
+	}
+
+	//members check quota again on creation, this one avoids half deployed environment
+	quotaLock.Lock()
+	err := quota.CheckAll(myAccount.Name, r.Project, requested)
+	quotaLock.Unlock()
+	if err != nil {
+		log.Printf("Blueprint %v deployment rejected: %v", myBlueprint.Name, err)
+		return nil, nil, err
+	}
+
+	myAccount.Lock()
+	lastIndex := utils.GetLastIndex(myAccount.GetEnvironmentNameList())
+	myEnv := blueprint.NewEnvironment(myAccount.Name+"-env-"+strconv.Itoa(lastIndex+1), myBlueprint.Name, r.Project,
+		time.Hour*24*time.Duration(r.Duration))
+	myTask := task.NewTask(task.TaskKindDeployEnv, myAccount.Name, myEnv.Name)
+	myEnv.Task = myTask.Id
+	myAccount.AppendEnvironment(myEnv)
+	myAccount.Unlock()
+	db.NotifyToSave()
+
+	log.Printf("Environment %v deploying from blueprint %v ...", myEnv.Name, myBlueprint.Name)
+	go func() {
+		changeTaskCount(1)
+		defer changeTaskCount(-1)
+		deployEnvironment(myAccount, myBlueprint, myEnv, myTask)
+	}()
+
+	return myEnv, myTask, nil
+}
+
+//Create members of environment by existing workflows, wait until all of them done, then expose ports of vms
+//Members created before a failure are kept, environment can be torn down as a whole
+func deployEnvironment(myAccount *account.Account, myBlueprint *blueprint.Blueprint, myEnv *blueprint.Environment, myTask *task.Task) {
+
+	defer db.NotifyToSave()
+
+	members := []environmentMember{}
+	var createErr error
+	for _, t := range myBlueprint.Software {
+		memberTask, err := CreateSoftware(myAccount, saas.SoftwareRequest{
+			Kind:    t.Kind,
+			Version: t.Version,
+			CPU:     t.CPU,
+			Memory:  t.Memory,
+			Project: myEnv.Project,
+		})
+		if err != nil {
+			createErr = fmt.Errorf("software %v creation failed: %v", t.Kind, err)
+			break
+		}
+		myEnv.AddSoftware(memberTask.Targets[0])
+		members = append(members, environmentMember{name: memberTask.Targets[0], task: memberTask})
+	}
+	for _, t := range myBlueprint.K8S {
+		if createErr != nil {
+			break
+		}
+		memberTask, err := CreateK8S(myAccount, k8s.K8sRequest{
+			Version:          t.Version,
+			NumOfContronller: t.NumOfContronller,
+			NumOfWorker:      t.NumOfWorker,
+			Project:          myEnv.Project,
+		})
+		if err != nil {
+			createErr = fmt.Errorf("k8s %v creation failed: %v", t.Version, err)
+			break
+		}
+		myEnv.AddK8S(memberTask.Targets[0])
+		members = append(members, environmentMember{name: memberTask.Targets[0], task: memberTask})
+	}
+	for _, t := range myBlueprint.VMs {
+		if createErr != nil {
+			break
+		}
+		vmGroup, memberTask, err := CreateVMs(myAccount, vm.VmRequest{
+			Hostname: t.Name,
+			Type:     t.Type,
+			Flavor:   t.Flavor,
+			CPU:      t.CPU,
+			Memory:   t.Memory,
+			Disk:     t.Disk,
+			Number:   t.Number,
+			Duration: environmentMemberDuration,
+			Addons:   t.Addons,
+			SshKeys:  t.SshKeys,
+			Project:  myEnv.Project,
+		})
+		if err != nil {
+			createErr = fmt.Errorf("vm %v creation failed: %v", t.Name, err)
+			break
+		}
+		for _, myVm := range vmGroup {
+			myEnv.AddVMs(myVm.Name)
+			members = append(members, environmentMember{name: myVm.Name, task: memberTask, ports: t.Ports})
+		}
+	}
+	db.NotifyToSave()
+
+	for _, m := range members {
+		myTask.StartStep(task.StepCreate, m.name)
+		myTask.StepMessage(task.StepCreate, m.name, "created by task "+m.task.Id)
+	}
+	if createErr != nil {
+		log.Printf("Environment %v deployment failed: %v", myEnv.Name, createErr)
+	}
+
+	//member tasks share creation setting of vm status
+	for _, m := range members {
+		for m.task.GetStatus() == task.TaskStatusPending || m.task.GetStatus() == task.TaskStatusRunning {
+			time.Sleep(time.Second * time.Duration(vmStatusInterval))
+		}
+		var err error
+		if m.task.GetStatus() == task.TaskStatusFailed {
+			err = fmt.Errorf("task %v failed: %v", m.task.Id, m.task.Error)
+		}
+		myTask.EndStep(task.StepCreate, m.name, err)
+	}
+
+	//ports exposed once vm is running, only vms created successfully
+	for _, m := range members {
+		if len(m.ports) == 0 || m.task.GetStatus() != task.TaskStatusSuccess {
+			continue
+		}
+		myVm, err := myAccount.GetVmByName(m.name)
+		if err != nil {
+			continue
+		}
+		myTask.StartStep(task.StepExpose, m.name)
+		exposed := []string{}
+		var exposeErr error
+		for _, p := range m.ports {
+			if err := ExposePort(myAccount, myVm, p.Port, p.Protocol); err != nil {
+				exposeErr = fmt.Errorf("port %v/%v: %v", p.Port, p.Protocol, err)
+				break
+			}
+			exposed = append(exposed, fmt.Sprintf("%v->%v", p.Port, myVm.PortMap[p.Port]))
+		}
+		myTask.StepMessage(task.StepExpose, m.name, strings.Join(exposed, ", "))
+		myTask.EndStep(task.StepExpose, m.name, exposeErr)
+	}
+
+	myTask.Finish(createErr)
+	if myTask.GetStatus() == task.TaskStatusSuccess {
+		myEnv.SetStatus(blueprint.EnvironmentStatusRunning, nil)
+		log.Printf("Environment %v deployed successfully", myEnv.Name)
+		myAccount.SendNotification(fmt.Sprintf("Your environment %v deployed from blueprint %v is ready", myEnv.Name, myBlueprint.Name))
+	} else {
+		myEnv.SetStatus(blueprint.EnvironmentStatusFailed, fmt.Errorf("%v", myTask.Error))
+		log.Printf("Environment %v deployment failed", myEnv.Name)
+		myAccount.SendNotification(fmt.Sprintf("Your environment %v deployed from blueprint %v failed, check task %v", myEnv.Name, myBlueprint.Name, myTask.Id))
+	}
+}
+
+//Tear down environment, all members deleted, members already deleted separately are skipped
+//Environment is kept in failed status if any member can't be deleted, deletion can be retried
+func DeleteEnvironment(myAccount *account.Account, name string) error {
+
+	changeTaskCount(1)
+	defer changeTaskCount(-1)
+	defer db.NotifyToSave()
+
+	myEnv, err := myAccount.GetEnvironmentByName(name)
+	if err != nil {
+		return fmt.Errorf("environment not found")
+	}
+	if myEnv.GetStatus() == blueprint.EnvironmentStatusDeploying {
+		return fmt.Errorf("Environment %v still deploying, delete it after deployment done", name)
+	}
+	myEnv.SetStatus(blueprint.EnvironmentStatusDeleting, nil)
+	db.NotifyToSave()
+
+	vms, k8sList, softwareList := myEnv.Members()
+	failed := []string{}
+	for _, n := range softwareList {
+		if _, err := myAccount.GetSoftwareByName(n); err != nil {
+			continue
+		}
+		if err := DeleteSoftware(myAccount, n); err != nil {
+			failed = append(failed, fmt.Sprintf("software %v: %v", n, err))
+		}
+	}
+	for _, n := range k8sList {
+		if _, err := myAccount.GetK8sByName(n); err != nil {
+			continue
+		}
+		if err := DeleteK8S(myAccount, n); err != nil {
+			failed = append(failed, fmt.Sprintf("k8s %v: %v", n, err))
+		}
+	}
+	for _, n := range vms {
+		myVm, err := myAccount.GetVmByName(n)
+		if err != nil {
+			continue
+		}
+		if err := ActionVM(myAccount, myVm, "delete"); err != nil {
+			failed = append(failed, fmt.Sprintf("vm %v: %v", n, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		err := fmt.Errorf("environment %v teardown failed: %v", name, strings.Join(failed, "; "))
+		log.Println(err)
+		myEnv.SetStatus(blueprint.EnvironmentStatusFailed, err)
+		return err
+	}
+	if err := myAccount.RemoveEnvironmentByName(name); err != nil {
+		return err
+	}
+
+	log.Printf("Environment %v torn down successfully", name)
+	return nil
+}
@@ -0,0 +1,26 @@
+package workflow
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/JinlongWukong/DevLab/config"
+	"github.com/JinlongWukong/DevLab/deployer"
+)
+
+//Tests run in package directory, config.ini is loaded from repository root
+//One fake deployer shared by all tests, since nodes added by a test stay in node db
+func TestMain(m *testing.M) {
+
+	if err := os.Chdir(".."); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.LoadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	deployer.SetClient(deployer.NewFakeClient())
+	vmStatusInterval = 0
+
+	os.Exit(m.Run())
+}
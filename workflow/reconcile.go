@@ -0,0 +1,310 @@
+package workflow
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/blueprint"
+	"github.com/JinlongWukong/DevLab/db"
+	"github.com/JinlongWukong/DevLab/deployer"
+	"github.com/JinlongWukong/DevLab/k8s"
+	"github.com/JinlongWukong/DevLab/node"
+	"github.com/JinlongWukong/DevLab/saas"
+	"github.com/JinlongWukong/DevLab/task"
+	"github.com/JinlongWukong/DevLab/vm"
+)
+
+//Reconcile persisted objects after controller restart
+//Workflow goroutines are lost when controller exits, objects left in transient status
+//will be resumed, retried or marked as failed according to persisted status
+func Reconcile() {
+
+	log.Println("Reconcile in-flight workflows start")
+	defer db.NotifyToSave()
+
+	//Tasks of last run can't be tracked any more
+	for t := range task.TaskDB.Iter() {
+		status := t.Value.GetStatus()
+		if status == task.TaskStatusPending || status == task.TaskStatusRunning {
+			log.Printf("Task %v left in %v, marked as failed", t.Value.Id, status)
+			t.Value.Finish(fmt.Errorf("interrupted by controller restart"))
+		}
+	}
+
+	reconcileNodes()
+
+	accounts := []*account.Account{}
+	for ac := range account.AccountDB.Iter() {
+		accounts = append(accounts, ac.Value)
+	}
+	for _, myAccount := range accounts {
+		reconcileVMs(myAccount)
+		reconcileK8S(myAccount)
+		reconcileSoftware(myAccount)
+		reconcileEnvironments(myAccount)
+	}
+
+	log.Println("Reconcile in-flight workflows done")
+}
+
+//Node installation will be retried, wait until done since workloads may hosted on it
+func reconcileNodes() {
+
+	nodes := []*node.Node{}
+	for n := range node.NodeDB.Iter() {
+		nodes = append(nodes, n.Value)
+	}
+
+	var wg sync.WaitGroup
+	for _, myNode := range nodes {
+		switch myNode.GetStatus() {
+		case node.NodeStatusInit, node.NodeStatusInstalling:
+			log.Printf("Node %v left in %v, retry installation", myNode.Name, myNode.GetStatus())
+			myTask := task.NewTask(task.TaskKindAddNode, "", myNode.Name)
+			wg.Add(1)
+			go func(myNode *node.Node) {
+				changeTaskCount(1)
+				defer changeTaskCount(-1)
+				defer wg.Done()
+				installNode(myNode, myTask)
+			}(myNode)
+		}
+	}
+	wg.Wait()
+}
+
+//VM status:
+//  init      -> never scheduled, marked as failed
+//  scheduled -> resumed from instantiation on reserved node
+//  creating  -> resumed from fetching status if vm existed on node, otherwise instantiation retried
+//  running   -> resumed from dnat if ssh port not exposed yet
+//  deleting  -> deletion retried
+//VM left in migration stays on source node, resources charged on target released
+func reconcileVMs(myAccount *account.Account) {
+
+	vmSlice := []*vm.VirtualMachine{}
+	for item := range myAccount.Iter() {
+		vmSlice = append(vmSlice, item)
+	}
+
+	resumed := []*vm.VirtualMachine{}
+	for _, myVm := range vmSlice {
+		if myVm.MigratingTo != "" {
+			reconcileMigration(myVm)
+		}
+		switch myVm.Status {
+		case vm.VmStatusInit:
+			log.Printf("VM %v never scheduled, marked as failed", myVm.Name)
+			myVm.Status = vm.VmStatusFailed
+		case vm.VmStatusScheduled, vm.VmStatusCreating:
+			resumed = append(resumed, myVm)
+		case vm.VmStatusRunning:
+			if _, exists := myVm.PortMap[22]; exists == false {
+				resumed = append(resumed, myVm)
+			}
+		case vm.VmStatusDeleting:
+			log.Printf("VM %v left in deleting, retry deletion", myVm.Name)
+			go func(myVm *vm.VirtualMachine) {
+				if err := ActionVM(myAccount, myVm, "delete"); err != nil {
+					log.Printf("Retry delete vm %v failed with error -> %v", myVm.Name, err)
+				}
+			}(myVm)
+		}
+	}
+	if len(resumed) == 0 {
+		return
+	}
+
+	names := []string{}
+	for _, myVm := range resumed {
+		names = append(names, myVm.Name)
+	}
+	myTask := task.NewTask(task.TaskKindCreateVm, myAccount.Name, names...)
+
+	go func() {
+		changeTaskCount(1)
+		defer changeTaskCount(-1)
+
+		var wg, addonsWg sync.WaitGroup
+		for _, myVm := range resumed {
+			wg.Add(1)
+			go func(myVm *vm.VirtualMachine) {
+				defer wg.Done()
+				resumeVM(myAccount, myVm, myTask, &addonsWg)
+			}(myVm)
+		}
+		wg.Wait()
+		addonsWg.Wait()
+		myTask.Finish(nil)
+		db.NotifyToSave()
+	}()
+}
+
+//Release target node charged by interrupted migration, vm status synced from source node
+//VM already moved by deployer can't be found on source, it is marked as failed
+func reconcileMigration(myVm *vm.VirtualMachine) {
+
+	log.Printf("VM %v left in migration to node %v, release target resources", myVm.Name, myVm.MigratingTo)
+	if target := node.GetNodeByName(myVm.MigratingTo); target != nil {
+		target.ChangeCpuUsed(-myVm.CPU)
+		target.ChangeMemUsed(-myVm.Memory)
+		target.ChangeDiskUsed(-myVm.Disk * 1024)
+	}
+	myVm.MigratingTo = ""
+
+	if err := myVm.GetVirtualMachineLiveStatus(); err != nil {
+		log.Printf("VM %v not found on node %v after interrupted migration, marked as failed: %v", myVm.Name, myVm.Node, err)
+		myVm.Status = vm.VmStatusFailed
+	}
+}
+
+//Resume single vm creation, reserved node resources released if vm can't be instantiated
+func resumeVM(myAccount *account.Account, myVm *vm.VirtualMachine, myTask *task.Task, addonsWg *sync.WaitGroup) {
+
+	selectNode := node.GetNodeByName(myVm.Node)
+	if selectNode == nil {
+		log.Printf("VM %v hosted node %v not found, marked as failed", myVm.Name, myVm.Node)
+		myTask.StartStep(task.StepInstantiate, myVm.Name)
+		myTask.EndStep(task.StepInstantiate, myVm.Name, fmt.Errorf("node %v not found", myVm.Node))
+		myVm.Lock()
+		myVm.Node = ""
+		myVm.PortMap = make(map[int]string)
+		myVm.Status = vm.VmStatusFailed
+		myVm.Unlock()
+		return
+	}
+
+	instantiate := myVm.Status == vm.VmStatusScheduled
+	if myVm.Status == vm.VmStatusCreating {
+		vmStatus, err := deployer.GetClient().GetVmStatus(selectNode.DeployerHost(), myVm.Name)
+		instantiate = err != nil || vmStatus.Status == "" || vmStatus.Status == vm.VmStatusDeleted
+	}
+
+	if instantiate {
+		log.Printf("VM %v resume from instantiation on node %v", myVm.Name, selectNode.Name)
+		myVm.Lock()
+		myTask.StartStep(task.StepInstantiate, myVm.Name)
+		err := myVm.CreateVirtualMachine()
+		myTask.EndStep(task.StepInstantiate, myVm.Name, err)
+		if err != nil {
+			log.Printf("VM %v instantiation retry failed, release resources on node %v", myVm.Name, selectNode.Name)
+			releaseVmResources(selectNode, myVm)
+			myVm.Node = ""
+			myVm.Status = vm.VmStatusFailed
+			myVm.Unlock()
+			myAccount.SendNotification(fmt.Sprintf("Your VM %v creation failed with error -> %v", myVm.Name, err))
+			return
+		}
+		myVm.Unlock()
+	} else {
+		log.Printf("VM %v resume from fetching status on node %v", myVm.Name, selectNode.Name)
+	}
+
+	provisionVM(myAccount, myVm, selectNode, myTask, addonsWg, false)
+}
+
+//Release node resources reserved by vm, include cpu/mem/disk and ports
+func releaseVmResources(selectNode *node.Node, myVm *vm.VirtualMachine) {
+
+	for _, info := range myVm.PortMap {
+		p, _ := strconv.Atoi(strings.Split(info, ":")[0])
+		selectNode.ReleasePort(p)
+	}
+	myVm.PortMap = make(map[int]string)
+
+	selectNode.ChangeCpuUsed(-myVm.CPU)
+	selectNode.ChangeMemUsed(-myVm.Memory)
+	selectNode.ChangeDiskUsed(-myVm.Disk * 1024)
+}
+
+//K8S status:
+//  init/bootingvm/installing -> resumed, bound host vm reused
+//  deleting                  -> deletion retried
+func reconcileK8S(myAccount *account.Account) {
+
+	k8sSlice := []*k8s.K8S{}
+	for item := range myAccount.IterK8S() {
+		k8sSlice = append(k8sSlice, item)
+	}
+
+	for _, myK8s := range k8sSlice {
+		switch myK8s.GetStatus() {
+		case k8s.K8sStatusInit, k8s.K8sStatusBootingVm, k8s.K8sStatusInstalling:
+			log.Printf("K8S %v left in %v, resume provisioning", myK8s.Name, myK8s.GetStatus())
+			myTask := task.NewTask(task.TaskKindCreateK8s, myAccount.Name, myK8s.Name)
+			go func(myK8s *k8s.K8S) {
+				changeTaskCount(1)
+				defer changeTaskCount(-1)
+				provisionK8S(myAccount, myK8s, myTask)
+			}(myK8s)
+		case k8s.K8sStatusDeleting:
+			log.Printf("K8S %v left in deleting, retry deletion", myK8s.Name)
+			go func(myK8s *k8s.K8S) {
+				if err := DeleteK8S(myAccount, myK8s.Name); err != nil {
+					log.Printf("Retry delete k8s %v failed with error -> %v", myK8s.Name, err)
+				}
+			}(myK8s)
+		}
+	}
+}
+
+//Software status:
+//  init/scheduled/installing -> resumed, existing container adopted
+//  deleting                  -> deletion retried
+func reconcileSoftware(myAccount *account.Account) {
+
+	softwareSlice := []*saas.Software{}
+	for item := range myAccount.IterSoftware() {
+		softwareSlice = append(softwareSlice, item)
+	}
+
+	for _, mySoftware := range softwareSlice {
+		switch mySoftware.GetStatus() {
+		case saas.SoftwareStatusInit, saas.SoftwareStatusScheduled, saas.SoftwareStatusInstalling:
+			log.Printf("Software %v left in %v, resume provisioning", mySoftware.Name, mySoftware.GetStatus())
+			myTask := task.NewTask(task.TaskKindCreateSoftware, myAccount.Name, mySoftware.Name)
+			go func(mySoftware *saas.Software) {
+				changeTaskCount(1)
+				defer changeTaskCount(-1)
+				provisionSoftware(myAccount, mySoftware, myTask)
+			}(mySoftware)
+		case saas.SoftwareStatusDeleting:
+			log.Printf("Software %v left in deleting, retry deletion", mySoftware.Name)
+			go func(mySoftware *saas.Software) {
+				if err := DeleteSoftware(myAccount, mySoftware.Name); err != nil {
+					log.Printf("Retry delete software %v failed with error -> %v", mySoftware.Name, err)
+				}
+			}(mySoftware)
+		}
+	}
+}
+
+//Environment status:
+//  deploying -> marked as failed, members already created are kept and resumed by themselves
+//  deleting  -> teardown retried
+func reconcileEnvironments(myAccount *account.Account) {
+
+	envs := []*blueprint.Environment{}
+	for item := range myAccount.IterEnvironment() {
+		envs = append(envs, item)
+	}
+
+	for _, myEnv := range envs {
+		switch myEnv.GetStatus() {
+		case blueprint.EnvironmentStatusDeploying:
+			log.Printf("Environment %v left in deploying, marked as failed", myEnv.Name)
+			myEnv.SetStatus(blueprint.EnvironmentStatusFailed, fmt.Errorf("interrupted by controller restart"))
+		case blueprint.EnvironmentStatusDeleting:
+			log.Printf("Environment %v left in deleting, retry teardown", myEnv.Name)
+			go func(myEnv *blueprint.Environment) {
+				if err := DeleteEnvironment(myAccount, myEnv.Name); err != nil {
+					log.Printf("Retry delete environment %v failed with error -> %v", myEnv.Name, err)
+				}
+			}(myEnv)
+		}
+	}
+}
@@ -1,15 +1,16 @@
 package workflow
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
 	"sync/atomic"
 
+	"github.com/JinlongWukong/DevLab/deployer"
 	"github.com/JinlongWukong/DevLab/node"
 	"github.com/JinlongWukong/DevLab/saas"
+	"github.com/JinlongWukong/DevLab/vm"
 )
 
 var taskCount int64
@@ -42,44 +43,56 @@ func GetVncPort(vp string) (string, error) {
 	}
 }
 
-func readContainerStatus(mySoftware *saas.Software, reponse_data []byte) error {
-	var softwareInfo saas.SoftwareInfo
-	if err := json.Unmarshal(reponse_data, &softwareInfo); err == nil {
-		mySoftware.Address = softwareInfo.Address
-		for k, v := range softwareInfo.AdditionalInfor {
-			mySoftware.AdditionalInfor[k] = v
-		}
-		switch softwareInfo.Status {
-		case "running":
-			mySoftware.SetStatus(saas.SoftwareStatusRunning)
-		case "stopped":
-			mySoftware.SetStatus(saas.SoftwareStatusStopped)
-		case "deleted":
-			mySoftware.SetStatus(saas.SoftwareStatusNotFound)
-		case "unknown":
-			mySoftware.SetStatus(saas.SoftwareStatusUnknown)
-		default:
-			mySoftware.SetStatus(saas.SoftwareStatusError)
-		}
-		mySoftware.PortMapping = map[string]string{}
-		softwareNode := node.GetNodeByName(mySoftware.Node)
-		if softwareNode == nil {
-			return fmt.Errorf("Error: software %v hosted node %v not found", mySoftware.Name, mySoftware.Node)
-		}
-		for _, v := range softwareInfo.PortMapping {
-			format1 := strings.Split(v, "->")
-			format2 := strings.Split(v, ":")
-			if len(format2) != 2 || len(format1) != 2 {
-				log.Printf("invalid format port mapping found %v, skip", v)
-				break
-			}
-			left := format1[0]
-			right := format2[1]
-			mySoftware.PortMapping[strings.Trim(left, " ")] = softwareNode.IpAddress + ":" + right
+//Generate novnc url of vm hosted on node, empty if novnc not used or vnc port unknown
+func noVncUrl(myVm *vm.VirtualMachine, nodeAddress string) string {
+	if noVncUse == false || myVm.Vnc.Port == "" {
+		return ""
+	}
+
+	vncPort, err := GetVncPort(myVm.Vnc.Port)
+	if err != nil {
+		log.Printf("parse vnc port error: %v %v", myVm.Vnc.Port, err.Error())
+		return ""
+	}
+
+	return noVncProtocol + "://" + noVncHost + ":" + strconv.Itoa(noVncPort) +
+		"/vnc.html?password=" + myVm.Vnc.Pass +
+		"&path=vnc/" + nodeAddress + "/" + vncPort +
+		"&autoconnect=true&resize=scale&reconnect=true&show_dot=true"
+}
+
+func readContainerStatus(mySoftware *saas.Software, softwareInfo deployer.ContainerInfo) error {
+	mySoftware.Address = softwareInfo.Address
+	for k, v := range softwareInfo.AdditionalInfor {
+		mySoftware.AdditionalInfor[k] = v
+	}
+	switch softwareInfo.Status {
+	case "running":
+		mySoftware.SetStatus(saas.SoftwareStatusRunning)
+	case "stopped":
+		mySoftware.SetStatus(saas.SoftwareStatusStopped)
+	case "deleted":
+		mySoftware.SetStatus(saas.SoftwareStatusNotFound)
+	case "unknown":
+		mySoftware.SetStatus(saas.SoftwareStatusUnknown)
+	default:
+		mySoftware.SetStatus(saas.SoftwareStatusError)
+	}
+	mySoftware.PortMapping = map[string]string{}
+	softwareNode := node.GetNodeByName(mySoftware.Node)
+	if softwareNode == nil {
+		return fmt.Errorf("Error: software %v hosted node %v not found", mySoftware.Name, mySoftware.Node)
+	}
+	for _, v := range softwareInfo.PortMapping {
+		format1 := strings.Split(v, "->")
+		format2 := strings.Split(v, ":")
+		if len(format2) != 2 || len(format1) != 2 {
+			log.Printf("invalid format port mapping found %v, skip", v)
+			break
 		}
-	} else {
-		log.Printf("Failed to unmarshal software %v status information", mySoftware.Name)
-		return err
+		left := format1[0]
+		right := format2[1]
+		mySoftware.PortMapping[strings.Trim(left, " ")] = softwareNode.IpAddress + ":" + right
 	}
 
 	return nil
@@ -1,23 +1,31 @@
 package workflow
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"net"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/blueprint"
 	"github.com/JinlongWukong/DevLab/config"
 	"github.com/JinlongWukong/DevLab/db"
 	"github.com/JinlongWukong/DevLab/deployer"
+	"github.com/JinlongWukong/DevLab/flavor"
+	"github.com/JinlongWukong/DevLab/hostkey"
+	"github.com/JinlongWukong/DevLab/image"
 	"github.com/JinlongWukong/DevLab/k8s"
 	"github.com/JinlongWukong/DevLab/node"
+	"github.com/JinlongWukong/DevLab/quota"
 	"github.com/JinlongWukong/DevLab/saas"
 	"github.com/JinlongWukong/DevLab/scheduler"
+	"github.com/JinlongWukong/DevLab/sshkey"
+	"github.com/JinlongWukong/DevLab/task"
 	"github.com/JinlongWukong/DevLab/utils"
 	"github.com/JinlongWukong/DevLab/vm"
 )
@@ -25,6 +33,13 @@ import (
 var scheduleLock sync.Mutex
 var newNodeLock sync.Mutex
 
+//nodes being drained, one drain allowed per node at a time
+var drainingNodes = make(map[string]bool)
+var drainLock sync.Mutex
+
+//serialize quota check and resource creation, accounts of same project share quota
+var quotaLock sync.Mutex
+
 // VM live status retry times and interval(unit seconds) setting, 2mins
 var vmStatusRetry, vmStatusInterval = 50, 6
 
@@ -33,6 +48,20 @@ var noVncHost, noVncProtocol = "", ""
 var noVncPort int
 var noVncUse bool //flag of use noVnc or not
 
+//Image of vm hosting k8s cluster, must be registered in image catalog
+const k8sHostImage = "centos7"
+
+//Flavors of vm hosting k8s cluster, must be registered in flavor catalog
+var k8sHostFlavor, k8sLargeHostFlavor = "middle", "large"
+var k8sLargeWorkers = 5
+
+//cloud-init user-data size limit, same as most public clouds
+const vmUserDataMaxSize = 16 * 1024
+
+//Maximum snapshots per vm, snapshot name is passed to deployer so restricted
+var vmMaxSnapshots = 5
+var snapshotNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
 // initialize configuration
 func init() {
 	if config.Workflow.VmStatusRetry > 0 {
@@ -49,10 +78,25 @@ func init() {
 		noVncPort = config.Workflow.NoVncPort
 		noVncUse = true
 	}
+	if config.Workflow.VmMaxSnapshots > 0 {
+		vmMaxSnapshots = config.Workflow.VmMaxSnapshots
+	}
+	if config.Workflow.K8sHostFlavor != "" {
+		k8sHostFlavor = config.Workflow.K8sHostFlavor
+	}
+	if config.Workflow.K8sLargeHostFlavor != "" {
+		k8sLargeHostFlavor = config.Workflow.K8sLargeHostFlavor
+	}
+	if config.Workflow.K8sLargeWorkers > 0 {
+		k8sLargeWorkers = config.Workflow.K8sLargeWorkers
+	} else if config.Workflow.K8sLargeWorkers < 0 {
+		log.Printf("K8s large workers %v not valid, %v used", config.Workflow.K8sLargeWorkers, k8sLargeWorkers)
+	}
 }
 
 // Create VMs
-func CreateVMs(myAccount *account.Account, vmRequest vm.VmRequest) ([]*vm.VirtualMachine, error) {
+//This is async call, the returned task can be used to track creation progress
+func CreateVMs(myAccount *account.Account, vmRequest vm.VmRequest) ([]*vm.VirtualMachine, *task.Task, error) {
 
 	myAccount.Lock()
 	defer myAccount.Unlock()
@@ -61,9 +105,29 @@ func CreateVMs(myAccount *account.Account, vmRequest vm.VmRequest) ([]*vm.Virtua
 	//Get the last index as the index of new virtual machine
 	lastIndex := utils.GetLastIndex(myAccount.GetVmNameList())
 
+	//ssh keys and user-data are shared by all new vms
+	sshKeys, err := resolveSshKeys(myAccount, vmRequest.SshKeys)
+	if err != nil {
+		log.Printf("VM creation rejected: %v", err)
+		return nil, nil, err
+	}
+	if len(vmRequest.UserData) > vmUserDataMaxSize {
+		return nil, nil, fmt.Errorf("user-data larger than %v bytes", vmUserDataMaxSize)
+	}
+
+	//flavor must be visible to account within project, otherwise custom size used
+	var myFlavor *flavor.Flavor
+	if vmRequest.Flavor != "" {
+		if myFlavor, err = flavor.Lookup(vmRequest.Flavor, string(myAccount.Role), vmRequest.Project); err != nil {
+			log.Printf("VM creation rejected: %v", err)
+			return nil, nil, err
+		}
+	}
+
 	// New VM instance
 	log.Printf("VM creation starting... total numbers: %v", vmRequest.Number)
 	var newVmGroup []*vm.VirtualMachine
+	var newVmNames []string
 	hostname := vmRequest.Hostname
 	for i := lastIndex + 1; i <= lastIndex+int(vmRequest.Number); i++ {
 
@@ -72,9 +136,9 @@ func CreateVMs(myAccount *account.Account, vmRequest vm.VmRequest) ([]*vm.Virtua
 			hostname = vmRequest.Hostname + "-" + strconv.Itoa(i-lastIndex)
 		}
 
-		newVm := vm.NewVirtualMachine(
+		newVm, err := vm.NewVirtualMachine(
 			myAccount.Name+"-"+strconv.Itoa(i),
-			vmRequest.Flavor,
+			myFlavor,
 			vmRequest.Type,
 			hostname,
 			vmRequest.RootPass,
@@ -84,15 +148,43 @@ func CreateVMs(myAccount *account.Account, vmRequest vm.VmRequest) ([]*vm.Virtua
 			time.Hour*24*time.Duration(vmRequest.Duration),
 			vmRequest.Addons,
 		)
-		if newVm != nil {
-			myAccount.AppendVM(newVm)
-			newVmGroup = append(newVmGroup, newVm)
-		} else {
-			log.Println("Error: Input paramters not valid")
-			return nil, fmt.Errorf("Input paramters not valid")
+		if err != nil {
+			log.Printf("Error: Input paramters not valid: %v", err)
+			return nil, nil, err
 		}
+		newVm.Project = vmRequest.Project
+		newVm.SshKeys = sshKeys
+		newVm.UserData = vmRequest.UserData
+		newVmGroup = append(newVmGroup, newVm)
+		newVmNames = append(newVmNames, newVm.Name)
+	}
+
+	//image must be registered in catalog, vms of one request share same size
+	if _, err := image.Validate(vmRequest.Type, newVmGroup[0].Disk); err != nil {
+		log.Printf("VM creation rejected: %v", err)
+		return nil, nil, err
+	}
+
+	//check quota of account and project before scheduling
+	requested := quota.Resources{VM: len(newVmGroup)}
+	for _, newVm := range newVmGroup {
+		requested.CPU += int(newVm.CPU)
+		requested.Memory += int(newVm.Memory)
+		requested.Disk += int(newVm.Disk)
+	}
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	if err := quota.CheckAll(myAccount.Name, vmRequest.Project, requested); err != nil {
+		log.Printf("VM creation rejected: %v", err)
+		return nil, nil, err
+	}
+
+	for _, newVm := range newVmGroup {
+		myAccount.AppendVM(newVm)
 	}
 
+	myTask := task.NewTask(task.TaskKindCreateVm, myAccount.Name, newVmNames...)
+
 	go func() {
 		changeTaskCount(1)
 		defer changeTaskCount(-1)
@@ -102,11 +194,18 @@ func CreateVMs(myAccount *account.Account, vmRequest vm.VmRequest) ([]*vm.Virtua
 		reqMem := newVmGroup[0].Memory * vmRequest.Number
 		reqDisk := newVmGroup[0].Disk * 1024 * vmRequest.Number
 
+		myTask.StartStep(task.StepSchedule, "")
 		scheduleLock.Lock()
-		selectNode := scheduler.Schedule(node.NodeRoleCompute, reqCpu, reqMem, reqDisk)
+		selectNode := scheduler.Schedule(node.NodeRoleCompute, vmRequest.Type, reqCpu, reqMem, reqDisk)
 		if selectNode == nil {
 			log.Println("Error: No valid node selected, VM creation exit")
 			scheduleLock.Unlock()
+			for _, newVm := range newVmGroup {
+				newVm.Status = vm.VmStatusFailed
+			}
+			myTask.EndStep(task.StepSchedule, "", fmt.Errorf("no valid node selected"))
+			myTask.Finish(nil)
+			db.NotifyToSave()
 			return
 		}
 		log.Printf("node selected -> %v", selectNode.Name)
@@ -120,212 +219,913 @@ func CreateVMs(myAccount *account.Account, vmRequest vm.VmRequest) ([]*vm.Virtua
 			newVm.NodeAddress = selectNode.IpAddress
 			newVm.Status = vm.VmStatusScheduled
 		}
+		myTask.StepMessage(task.StepSchedule, "", "node selected -> "+selectNode.Name)
+		myTask.EndStep(task.StepSchedule, "", nil)
 
 		db.NotifyToSave()
 
-		//Create VMs in parallel
-		var wg sync.WaitGroup
+		//Create VMs in parallel, addons installation is tracked separately since vm lock not required
+		var wg, addonsWg sync.WaitGroup
 		for _, newVm := range newVmGroup {
 			wg.Add(1)
 			go func(myVm *vm.VirtualMachine) {
-
 				defer wg.Done()
-				myVm.Lock()
-				defer myVm.Unlock()
-				defer db.NotifyToSave()
+				provisionVM(myAccount, myVm, selectNode, myTask, &addonsWg, true)
+			}(newVm)
+		}
+		wg.Wait()
+		log.Println("VM creation done")
+		addonsWg.Wait()
+		myTask.Finish(nil)
+		db.NotifyToSave()
+	}()
 
-				//task1: VM instantiation
-				log.Printf("VM %v instantiation start", myVm.Name)
-				err := myVm.CreateVirtualMachine()
-				if err == nil {
-					log.Printf("VM %v instantiation success", myVm.Name)
-				} else {
-					log.Printf("VM %v instantiation fail", myVm.Name)
-					return
-				}
+	return newVmGroup, myTask, nil
+}
+
+//Resolve ssh keys of vm request, each one is either a public key or name of key in account key library
+//Duplicated keys are injected once
+func resolveSshKeys(myAccount *account.Account, keys []string) ([]string, error) {
+
+	resolved := []string{}
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		var key, fingerprint string
+		if libraryKey, err := myAccount.GetPublicKeyByName(k); err == nil {
+			key, fingerprint = libraryKey.Key, libraryKey.Fingerprint
+		} else if key, fingerprint, err = sshkey.ParsePublicKey(k); err != nil {
+			return nil, fmt.Errorf("ssh key %v is neither a public key nor a key in library", k)
+		}
+		if seen[fingerprint] == false {
+			seen[fingerprint] = true
+			resolved = append(resolved, key)
+		}
+	}
+
+	return resolved, nil
+}
+
+//Provision a scheduled VM on selected node: instantiation, fetch status, dnat, addons
+//Args:
+//   instantiate -> false means vm already created on node, start from fetching status
+//   addonsWg    -> addons installation is async, caller can wait on it
+func provisionVM(myAccount *account.Account, myVm *vm.VirtualMachine, selectNode *node.Node,
+	myTask *task.Task, addonsWg *sync.WaitGroup, instantiate bool) {
+
+	myVm.Lock()
+	defer myVm.Unlock()
+	defer db.NotifyToSave()
+
+	//task1: VM instantiation
+	if instantiate {
+		log.Printf("VM %v instantiation start", myVm.Name)
+		myTask.StartStep(task.StepInstantiate, myVm.Name)
+		err := myVm.CreateVirtualMachine()
+		myTask.EndStep(task.StepInstantiate, myVm.Name, err)
+		if err == nil {
+			log.Printf("VM %v instantiation success", myVm.Name)
+			//clone disk is a full copy, image not cached by it
+			if myVm.Clone == nil {
+				image.ImageDB.MarkCached(myVm.Type, selectNode.Name)
+			}
+		} else {
+			log.Printf("VM %v instantiation fail", myVm.Name)
+			return
+		}
+	}
 
-				//task2: Get VM Info
-				retry := 1
-				for retry <= vmStatusRetry {
-					if err := myVm.GetVirtualMachineLiveStatus(); err == nil {
-						// Generate novnc url
-						if noVncUse && myVm.NoVnc == "" && myVm.Vnc.Port != "" {
-							if vncPort, err := GetVncPort(myVm.Vnc.Port); err == nil {
-								myVm.NoVnc = noVncProtocol + "://" + noVncHost + ":" + strconv.Itoa(noVncPort) +
-									"/vnc.html?password=" + myVm.Vnc.Pass +
-									"&path=vnc/" + selectNode.IpAddress + "/" + vncPort +
-									"&autoconnect=true&resize=scale&reconnect=true&show_dot=true"
-							} else {
-								log.Printf("parse vnc port error: %v %v", myVm.Vnc.Port, err.Error())
-							}
-						}
-						// Tell if ipaddress fetched or not
-						if myVm.Status != "" && myVm.IpAddress != "" {
-							log.Printf("Get VM -> %v info: status -> %v, address -> %v", myVm.Name, myVm.Status, myVm.IpAddress)
-							break
-						}
+	//task2: Get VM Info
+	myTask.StartStep(task.StepFetchStatus, myVm.Name)
+	retry := 1
+	for retry <= vmStatusRetry {
+		if err := myVm.GetVirtualMachineLiveStatus(); err == nil {
+			// Generate novnc url
+			if myVm.NoVnc == "" {
+				myVm.NoVnc = noVncUrl(myVm, selectNode.IpAddress)
+			}
+			// Tell if ipaddress fetched or not
+			if myVm.Status != "" && myVm.IpAddress != "" {
+				log.Printf("Get VM -> %v info: status -> %v, address -> %v", myVm.Name, myVm.Status, myVm.IpAddress)
+				break
+			}
+		}
+		log.Println("VM get live status failed or empty, will try again")
+		time.Sleep(time.Second * time.Duration(vmStatusInterval))
+		retry++
+	}
+	if retry > vmStatusRetry {
+		log.Println("VM get status timeout, exited")
+		myVm.Status = vm.VmStatusFailed
+		myTask.EndStep(task.StepFetchStatus, myVm.Name, fmt.Errorf("get vm status timeout"))
+		return
+	}
+	myTask.EndStep(task.StepFetchStatus, myVm.Name, nil)
+
+	myAccount.SendNotification(fmt.Sprintf("Your VM %v is running \n\n"+
+		"root passwd -> %v\n"+
+		"vnc passwd -> %v\n"+
+		"vnc endpoint -> %v%v", myVm.Name, myVm.RootPass, myVm.Vnc.Pass, selectNode.IpAddress, myVm.Vnc.Port))
+
+	//task3: Setup DNAT, port may be reserved already if resumed
+	myTask.StartStep(task.StepDnat, myVm.Name)
+	if _, exists := myVm.PortMap[22]; exists == false {
+		sshPort := selectNode.ReservePort(strings.Split(myVm.IpAddress, "/")[0] + ":22")
+		if sshPort == 0 {
+			log.Printf("No port reserved on node %v", selectNode.Name)
+			myTask.EndStep(task.StepDnat, myVm.Name, fmt.Errorf("no port reserved on node %v", selectNode.Name))
+			return
+		} else {
+			myVm.PortMap[22] = strconv.Itoa(sshPort) + ":tcp"
+			log.Printf("port -> %v reserved on node for vm %v", sshPort, myVm.Name)
+		}
+	}
+	sshPort := strings.Split(myVm.PortMap[22], ":")[0]
+	err := myVm.ActionDnatRule([]int{22}, "present")
+	if err != nil {
+		log.Println(err)
+		myVm.Status = vm.VmStatusFailed
+		myTask.EndStep(task.StepDnat, myVm.Name, err)
+		return
+	}
+	log.Printf("DNAT setup success for vm %v, port mapping -> %v:%v", myVm.Name, 22, myVm.PortMap[22])
+	myTask.StepMessage(task.StepDnat, myVm.Name, "ssh port -> "+sshPort)
+	myTask.EndStep(task.StepDnat, myVm.Name, nil)
+	myAccount.SendNotification(fmt.Sprintf("Your VM %v is ready to login using ssh %v -p %v ", myVm.Name, selectNode.IpAddress, sshPort))
+
+	//task4: Install addons, clone has them on disk copied from source
+	if len(myVm.Addons) != 0 && myVm.Clone == nil {
+		//addons install retry times
+		const ADDONSRETRY = 3
+		addonsWg.Add(1)
+		myTask.StartStep(task.StepAddons, myVm.Name)
+		go func(myVm *vm.VirtualMachine) {
+			defer addonsWg.Done()
+			retry := 1
+			for retry <= ADDONSRETRY {
+				err := myVm.InstallAddons()
+				if err != nil {
+					err_msg := fmt.Sprintf("Addons %v on vm %v installation failed with error -> %v", myVm.Addons, myVm.Name, err.Error())
+					log.Println(err_msg)
+					log.Printf("addons install retry %v", retry)
+					if retry >= ADDONSRETRY {
+						myAccount.SendNotification(err_msg)
+						myTask.EndStep(task.StepAddons, myVm.Name, err)
 					}
-					log.Println("VM get live status failed or empty, will try again")
-					time.Sleep(time.Second * time.Duration(vmStatusInterval))
-					retry++
-				}
-				if retry > vmStatusRetry {
-					log.Println("VM get status timeout, exited")
-					return
+				} else {
+					success_msg := fmt.Sprintf("Congratulations! addons %v on vm %v installation succeed", myVm.Addons, myVm.Name)
+					log.Println(success_msg)
+					myAccount.SendNotification(success_msg)
+					myTask.EndStep(task.StepAddons, myVm.Name, nil)
+					break
 				}
+				retry++
+			}
+			return
+		}(myVm)
+	}
+}
 
-				myAccount.SendNotification(fmt.Sprintf("Your VM %v is running \n\n"+
-					"root passwd -> %v\n"+
-					"vnc passwd -> %v\n"+
-					"vnc endpoint -> %v%v", myVm.Name, myVm.RootPass, myVm.Vnc.Pass, selectNode.IpAddress, myVm.Vnc.Port))
+// Take specify action on VM(start/delete/shutdown/reboot)
+func ActionVM(myAccount *account.Account, myVM *vm.VirtualMachine, action string) error {
+	changeTaskCount(1)
+	defer changeTaskCount(-1)
 
-				//task3: Setup DNAT
-				sshPort := selectNode.ReservePort(strings.Split(myVm.IpAddress, "/")[0] + ":22")
-				if sshPort == 0 {
-					log.Printf("No port reserved on node %v", selectNode.Name)
-					return
-				} else {
-					myVm.PortMap[22] = strconv.Itoa(sshPort) + ":tcp"
-					log.Printf("port -> %v reserved on node for vm %v", sshPort, myVm.Name)
+	myVM.Lock()
+	defer myVM.Unlock()
+
+	defer db.NotifyToSave()
+
+	//disk being copied by clone
+	if action != "shutdown" && myVM.IsCloneSource() {
+		return fmt.Errorf("VM %v is being cloned, %v it after clone done", myVM.Name, action)
+	}
+
+	var action_err error
+	switch action {
+	case "start":
+		//VM status check
+		if myVM.Status == vm.VmStatusDeleted || myVM.Status == vm.VmStatusDeleting {
+			return fmt.Errorf("VM in deleting or deleted")
+		}
+		action_err = myVM.StartUpVirtualMachine()
+	case "shutdown":
+		//VM status check
+		if myVM.Status == vm.VmStatusDeleted || myVM.Status == vm.VmStatusDeleting {
+			return fmt.Errorf("VM in deleting or deleted")
+		}
+		action_err = myVM.ShutDownVirtualMachine()
+	case "reboot":
+		//VM status check
+		if myVM.Status == vm.VmStatusDeleted || myVM.Status == vm.VmStatusDeleting {
+			return fmt.Errorf("VM in deleting or deleted")
+		}
+		action_err = myVM.RebootVirtualMachine()
+	case "delete":
+		previousStatus := myVM.Status
+		myVM.Status = vm.VmStatusDeleting
+		selectNode := node.GetNodeByName(myVM.Node)
+		if selectNode != nil {
+			//Delete snapshots first, vm with snapshots can't be deleted
+			//vm untouched yet if snapshot can't be deleted, so previous status restored
+			for _, snapshot := range myVM.GetSnapshots() {
+				if _, err := myVM.DeleteSnapshot(snapshot.Name); err != nil {
+					log.Printf("Delete snapshot %v of vm %v failed", snapshot.Name, myVM.Name)
+					myVM.Status = previousStatus
+					return err
 				}
-				err = myVm.ActionDnatRule([]int{22}, "present")
+				selectNode.ChangeDiskUsed(-snapshot.Size)
+			}
+
+			//Delete VM from node
+			action_err = myVM.DeleteVirtualMachine()
+			if action_err != nil {
+				log.Printf("Delete vm %v failed", myVM.Name)
+				return action_err
+			}
+
+			//Clear dnat rules
+			keys := make([]int, 0, len(myVM.PortMap))
+			for k := range myVM.PortMap {
+				keys = append(keys, k)
+			}
+			if len(keys) > 0 {
+				err := myVM.ActionDnatRule(keys, "absent")
 				if err != nil {
-					log.Println(err)
-					myVm.Status = fmt.Sprint(err)
-					return
-				}
-				log.Printf("DNAT setup success for vm %v, port mapping -> %v:%v", myVm.Name, 22, myVm.PortMap[22])
-				myAccount.SendNotification(fmt.Sprintf("Your VM %v is ready to login using ssh %v -p %v ", myVm.Name, selectNode.IpAddress, sshPort))
-
-				//task4: Install addons
-				if len(myVm.Addons) != 0 {
-					//addons install retry times
-					const ADDONSRETRY = 3
-					go func(myVm *vm.VirtualMachine) {
-						retry := 1
-						for retry <= ADDONSRETRY {
-							err = myVm.InstallAddons()
-							if err != nil {
-								err_msg := fmt.Sprintf("Addons %v on vm %v installation failed with error -> %v", myVm.Addons, myVm.Name, err.Error())
-								log.Println(err_msg)
-								log.Printf("addons install retry %v", retry)
-								if retry >= ADDONSRETRY {
-									myAccount.SendNotification(err_msg)
-								}
-							} else {
-								success_msg := fmt.Sprintf("Congratulations! addons %v on vm %v installation succeed", myVm.Addons, myVm.Name)
-								log.Println(success_msg)
-								myAccount.SendNotification(success_msg)
-								break
-							}
-							retry++
-						}
-						return
-					}(myVm)
+					log.Printf("Clear dnat for vm %v on host %v failed with error %v", myVM.Name, selectNode.Name, err)
+					return err
+				} else {
+					log.Printf("Clear dnat for vm %v on host %v success", myVM.Name, selectNode.Name)
+					for _, info := range myVM.PortMap {
+						t := strings.Split(info, ":")
+						p, _ := strconv.Atoi(t[0])
+						selectNode.ReleasePort(p)
+					}
+					myVM.PortMap = make(map[int]string)
 				}
-			}(newVm)
+			}
 
+			//Recycle resouces to node
+			log.Println("Recycle node resources")
+			selectNode.ChangeCpuUsed(-myVM.CPU)
+			selectNode.ChangeMemUsed(-myVM.Memory)
+			selectNode.ChangeDiskUsed(-myVM.Disk * 1024)
+			myVM.Node = ""
 		}
-		wg.Wait()
-		log.Println("VM creation done")
+
+		//Remove from account
+		if err := myAccount.RemoveVmByName(myVM.Name); err != nil {
+			log.Println(err)
+			return err
+		} else {
+			myVM.Status = vm.VmStatusDeleted
+		}
+
+		return nil
+	}
+
+	// Post action, fetch latest vm status
+	switch action {
+	case "start", "shutdown", "reboot":
+		go func() {
+			time.Sleep(time.Second * 10)
+			if err := myVM.GetVirtualMachineLiveStatus(); err != nil {
+				log.Printf("sync up vm -> %v status after action -> %v, failed -> %v", myVM.Name, action, err)
+			}
+		}()
+	}
+
+	return action_err
+}
+
+//Resize VM cpu/memory/disk on hosted node, disk can only grow
+//Running VM is shut down for cpu/memory change, then started again
+func ResizeVM(myAccount *account.Account, myVM *vm.VirtualMachine, r vm.VmRequestResize) error {
+	changeTaskCount(1)
+	defer changeTaskCount(-1)
+
+	myVM.Lock()
+	defer myVM.Unlock()
+
+	//VM status check
+	if myVM.Status != vm.VmStatusRunning && myVM.Status != vm.VmStatusShutoff {
+		return fmt.Errorf("VM in %v status can't be resized", myVM.Status)
+	}
+	if myVM.IsCloneSource() {
+		return fmt.Errorf("VM %v is being cloned, resize it after clone done", myVM.Name)
+	}
+
+	//flavor preferred, otherwise custom size, unset field keeps current value
+	//extra specs are applied at creation, so flavor with different ones can't be resized to
+	cpu, mem, disk := myVM.CPU, myVM.Memory, myVM.Disk
+	if r.Flavor != "" {
+		myFlavor, err := flavor.Lookup(r.Flavor, string(myAccount.Role), myVM.Project)
+		if err != nil {
+			return err
+		}
+		if sameExtraSpecs(myFlavor.ExtraSpecs, myVM.ExtraSpecs) == false {
+			return &flavor.InvalidError{Name: r.Flavor, Reason: "has different extra specs from vm, can't be resized to"}
+		}
+		cpu, mem, disk = myFlavor.CPU, myFlavor.Memory, myFlavor.Disk
+	} else {
+		if r.CPU > 0 {
+			cpu = r.CPU
+		}
+		if r.Memory > 0 {
+			mem = r.Memory
+		}
+		if r.Disk > 0 {
+			disk = r.Disk
+		}
+	}
+	if disk < myVM.Disk {
+		return fmt.Errorf("VM disk can't be shrunk from %vG to %vG", myVM.Disk, disk)
+	}
+	if cpu == myVM.CPU && mem == myVM.Memory && disk == myVM.Disk {
+		return fmt.Errorf("VM %v already has cpu %v, memory %v, disk %vG", myVM.Name, cpu, mem, disk)
+	}
+	if disk != myVM.Disk && len(myVM.GetSnapshots()) > 0 {
+		return fmt.Errorf("VM %v has snapshots, delete them before resizing disk", myVM.Name)
+	}
+
+	myNode := node.GetNodeByName(myVM.Node)
+	if myNode == nil {
+		return fmt.Errorf("Error: vm %v hosted node %v not found", myVM.Name, myVM.Node)
+	}
+
+	deltaCpu, deltaMem, deltaDisk := cpu-myVM.CPU, mem-myVM.Memory, disk-myVM.Disk
+
+	//only growth checked against quota, held until vm size updated
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	requested := quota.Resources{CPU: int(deltaCpu), Memory: int(deltaMem), Disk: int(deltaDisk)}
+	if err := quota.CheckAll(myAccount.Name, myVM.Project, requested); err != nil {
+		log.Printf("VM %v resize rejected: %v", myVM.Name, err)
+		return err
+	}
+
+	//VM stays on current node, check whether node can hold growth
+	grow := func(delta int32) int32 {
+		if delta < 0 {
+			return 0
+		}
+		return delta
+	}
+	scheduleLock.Lock()
+	if scheduler.HasCapacity(myNode, grow(deltaCpu), grow(deltaMem), grow(deltaDisk*1024)) == false {
+		scheduleLock.Unlock()
+		log.Printf("VM %v resize rejected, node %v has no capacity left", myVM.Name, myNode.Name)
+		return fmt.Errorf("node %v has no capacity left for VM %v resize", myNode.Name, myVM.Name)
+	}
+	myNode.ChangeCpuUsed(deltaCpu)
+	myNode.ChangeMemUsed(deltaMem)
+	myNode.ChangeDiskUsed(deltaDisk * 1024)
+	scheduleLock.Unlock()
+
+	defer db.NotifyToSave()
+
+	release := func() {
+		myNode.ChangeCpuUsed(-deltaCpu)
+		myNode.ChangeMemUsed(-deltaMem)
+		myNode.ChangeDiskUsed(-deltaDisk * 1024)
+	}
+
+	//cpu/memory can't be changed while vm running
+	restart := false
+	if deltaCpu != 0 || deltaMem != 0 {
+		if err := myVM.GetVirtualMachineLiveStatus(); err != nil {
+			release()
+			return err
+		}
+		if myVM.Status == vm.VmStatusRunning {
+			if err := shutdownVMAndWait(myVM); err != nil {
+				log.Printf("Shut down vm %v before resize failed: %v", myVM.Name, err)
+				release()
+				return err
+			}
+			restart = true
+		}
+	}
+
+	resizeErr := myVM.ResizeVirtualMachine(cpu, mem, disk)
+	if resizeErr != nil {
+		log.Printf("Resize vm %v failed, node resources released", myVM.Name)
+		release()
+	} else {
+		log.Printf("VM %v resized to cpu %v, memory %v, disk %vG", myVM.Name, cpu, mem, disk)
+		myVM.Flavor = r.Flavor
+	}
+
+	if restart {
+		if err := myVM.StartUpVirtualMachine(); err != nil {
+			log.Printf("Start vm %v after resize failed: %v", myVM.Name, err)
+			if resizeErr == nil {
+				resizeErr = err
+			}
+		}
+		go func() {
+			time.Sleep(time.Second * 10)
+			if err := myVM.GetVirtualMachineLiveStatus(); err != nil {
+				log.Printf("sync up vm -> %v status after resize, failed -> %v", myVM.Name, err)
+			}
+		}()
+	}
+
+	return resizeErr
+}
+
+//Check whether extra specs of flavor same as vm's, nil and empty are same
+func sameExtraSpecs(a, b map[string]string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if value, exists := b[k]; exists == false || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+//Shut down VM and wait until it is off, retry setting same as creation
+func shutdownVMAndWait(myVM *vm.VirtualMachine) error {
+
+	if err := myVM.ShutDownVirtualMachine(); err != nil {
+		return err
+	}
+	for retry := 0; retry < vmStatusRetry; retry++ {
+		if err := myVM.GetVirtualMachineLiveStatus(); err == nil && myVM.Status == vm.VmStatusShutoff {
+			return nil
+		}
+		time.Sleep(time.Second * time.Duration(vmStatusInterval))
+	}
+
+	return fmt.Errorf("VM %v not shut off after %v retries", myVM.Name, vmStatusRetry)
+}
+
+//Migrate VM to another compute node, target picked by scheduler if not given
+//This is async call, the returned task can be used to track migration progress
+func MigrateVM(myAccount *account.Account, myVM *vm.VirtualMachine, r vm.VmRequestMigrate) (*task.Task, error) {
+
+	myVM.RLock()
+	err := checkMigratable(myVM, r.Live)
+	hostedNode := myVM.Node
+	myVM.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if r.Node != "" {
+		if node.GetNodeByName(r.Node) == nil {
+			return nil, fmt.Errorf("node %v not found", r.Node)
+		} else if r.Node == hostedNode {
+			return nil, fmt.Errorf("VM already hosted on node %v", r.Node)
+		}
+	}
+
+	myTask := task.NewTask(task.TaskKindMigrateVm, myAccount.Name, myVM.Name)
+	go func() {
+		changeTaskCount(1)
+		defer changeTaskCount(-1)
+
+		err := migrateVM(myAccount, myVM, r.Node, r.Live, myTask)
+		if err != nil {
+			log.Printf("VM %v migration failed: %v", myVM.Name, err)
+		}
+		myTask.Finish(err)
+		db.NotifyToSave()
+	}()
+
+	return myTask, nil
+}
+
+//VM must be running or shut off, live migration needs it running
+//Snapshots are kept in vm disk on node, they can't be moved along
+func checkMigratable(myVM *vm.VirtualMachine, live bool) error {
+
+	if myVM.Status != vm.VmStatusRunning && myVM.Status != vm.VmStatusShutoff {
+		return fmt.Errorf("VM in %v status can't be migrated", myVM.Status)
+	}
+	if live && myVM.Status != vm.VmStatusRunning {
+		return fmt.Errorf("VM %v is not running, use cold migration", myVM.Name)
+	}
+	if myVM.IsCloneSource() {
+		return fmt.Errorf("VM %v is being cloned, migrate it after clone done", myVM.Name)
+	}
+	if len(myVM.GetSnapshots()) > 0 {
+		return fmt.Errorf("VM %v has snapshots, delete them before migration", myVM.Name)
+	}
+
+	return nil
+}
+
+//Check whether given node is able to host migrated vm
+func checkMigrationTarget(source, target *node.Node, reqCpu, reqMem, reqDisk int32) error {
+
+	switch {
+	case target.Name == source.Name:
+		return fmt.Errorf("VM already hosted on node %v", target.Name)
+	case target.Role != node.NodeRoleCompute:
+		return fmt.Errorf("node %v is not a compute node", target.Name)
+	case target.GetState() != node.NodeStateEnable || target.GetStatus() != node.NodeStatusReady:
+		return fmt.Errorf("node %v is not enabled or not ready", target.Name)
+	case scheduler.HasCapacity(target, reqCpu, reqMem, reqDisk) == false:
+		return fmt.Errorf("node %v has no capacity left", target.Name)
+	}
+
+	return nil
+}
+
+//Move VM to target node: schedule, migrate, fetch new address, re-create dnat on target
+//Cold migration shuts vm down and boots it on target, vm shut off before is shut off again
+func migrateVM(myAccount *account.Account, myVM *vm.VirtualMachine, targetName string, live bool, myTask *task.Task) error {
+
+	myVM.Lock()
+	defer myVM.Unlock()
+	defer db.NotifyToSave()
+
+	//task1: select target node, resources charged on target before moving
+	myTask.StartStep(task.StepSchedule, myVM.Name)
+	if err := checkMigratable(myVM, live); err != nil {
+		myTask.EndStep(task.StepSchedule, myVM.Name, err)
+		return err
+	}
+	source := node.GetNodeByName(myVM.Node)
+	if source == nil {
+		err := fmt.Errorf("Error: vm %v hosted node %v not found", myVM.Name, myVM.Node)
+		myTask.EndStep(task.StepSchedule, myVM.Name, err)
+		return err
+	}
+	reqCpu, reqMem, reqDisk := myVM.CPU, myVM.Memory, myVM.Disk*1024
+
+	scheduleLock.Lock()
+	var target *node.Node
+	if targetName == "" {
+		if target = scheduler.Schedule(node.NodeRoleCompute, myVM.Type, reqCpu, reqMem, reqDisk, source.Name); target == nil {
+			scheduleLock.Unlock()
+			err := fmt.Errorf("no valid node selected")
+			myTask.EndStep(task.StepSchedule, myVM.Name, err)
+			return err
+		}
+	} else {
+		if target = node.GetNodeByName(targetName); target == nil {
+			scheduleLock.Unlock()
+			err := fmt.Errorf("node %v not found", targetName)
+			myTask.EndStep(task.StepSchedule, myVM.Name, err)
+			return err
+		}
+		if err := checkMigrationTarget(source, target, reqCpu, reqMem, reqDisk); err != nil {
+			scheduleLock.Unlock()
+			myTask.EndStep(task.StepSchedule, myVM.Name, err)
+			return err
+		}
+	}
+	target.ChangeCpuUsed(reqCpu)
+	target.ChangeMemUsed(reqMem)
+	target.ChangeDiskUsed(reqDisk)
+	scheduleLock.Unlock()
+	//persisted, so target charge can be released by reconcile if controller restarted in the middle
+	myVM.MigratingTo = target.Name
+	db.NotifyToSave()
+	log.Printf("node selected -> %v, vm %v will be migrated from %v", target.Name, myVM.Name, source.Name)
+	myTask.StepMessage(task.StepSchedule, myVM.Name, "node selected -> "+target.Name)
+	myTask.EndStep(task.StepSchedule, myVM.Name, nil)
+
+	//task2: move vm to target
+	myTask.StartStep(task.StepMigrate, myVM.Name)
+	releaseTarget := func() {
+		target.ChangeCpuUsed(-reqCpu)
+		target.ChangeMemUsed(-reqMem)
+		target.ChangeDiskUsed(-reqDisk)
+		myVM.MigratingTo = ""
+	}
+	if err := myVM.GetVirtualMachineLiveStatus(); err != nil {
+		releaseTarget()
+		myTask.EndStep(task.StepMigrate, myVM.Name, err)
+		return err
+	}
+	wasRunning := myVM.Status == vm.VmStatusRunning
+	if live && wasRunning == false {
+		releaseTarget()
+		err := fmt.Errorf("VM %v is not running, use cold migration", myVM.Name)
+		myTask.EndStep(task.StepMigrate, myVM.Name, err)
+		return err
+	}
+	if live == false && wasRunning {
+		if err := shutdownVMAndWait(myVM); err != nil {
+			releaseTarget()
+			myTask.EndStep(task.StepMigrate, myVM.Name, err)
+			return err
+		}
+	}
+	if err := myVM.MigrateVirtualMachine(target, live); err != nil {
+		releaseTarget()
+		if live == false && wasRunning {
+			if err := myVM.StartUpVirtualMachine(); err != nil {
+				log.Printf("Start vm %v on node %v after failed migration failed: %v", myVM.Name, source.Name, err)
+			}
+		}
+		myTask.EndStep(task.StepMigrate, myVM.Name, err)
+		return err
+	}
+
+	//Clear dnat rules and recycle resources on source node
+	ports := make([]int, 0, len(myVM.PortMap))
+	for p := range myVM.PortMap {
+		ports = append(ports, p)
+	}
+	sort.Ints(ports)
+	if len(ports) > 0 {
+		if err := myVM.ActionDnatRule(ports, "absent"); err != nil {
+			log.Printf("Clear dnat for vm %v on host %v failed with error %v", myVM.Name, source.Name, err)
+		}
+		for _, info := range myVM.PortMap {
+			p, _ := strconv.Atoi(strings.Split(info, ":")[0])
+			source.ReleasePort(p)
+		}
+	}
+	source.ChangeCpuUsed(-reqCpu)
+	source.ChangeMemUsed(-reqMem)
+	source.ChangeDiskUsed(-reqDisk)
+
+	myVM.Node = target.Name
+	myVM.NodeAddress = target.IpAddress
+	myVM.MigratingTo = ""
+	myVM.IpAddress = ""
+	myVM.NoVnc = ""
+	log.Printf("VM %v migrated from node %v to %v", myVM.Name, source.Name, target.Name)
+	myTask.EndStep(task.StepMigrate, myVM.Name, nil)
+
+	//task3: fetch new address, vm booted on target after cold migration
+	myTask.StartStep(task.StepFetchStatus, myVM.Name)
+	if live == false {
+		if err := myVM.StartUpVirtualMachine(); err != nil {
+			myVM.Status = vm.VmStatusFailed
+			myTask.EndStep(task.StepFetchStatus, myVM.Name, err)
+			return err
+		}
+	}
+	retry := 1
+	for retry <= vmStatusRetry {
+		if err := myVM.GetVirtualMachineLiveStatus(); err == nil &&
+			myVM.Status == vm.VmStatusRunning && myVM.IpAddress != "" {
+			break
+		}
+		log.Println("VM get live status failed or empty, will try again")
+		time.Sleep(time.Second * time.Duration(vmStatusInterval))
+		retry++
+	}
+	if retry > vmStatusRetry {
+		err := fmt.Errorf("get vm status timeout")
+		myVM.Status = vm.VmStatusFailed
+		myTask.EndStep(task.StepFetchStatus, myVM.Name, err)
+		return err
+	}
+	myVM.NoVnc = noVncUrl(myVM, target.IpAddress)
+	myTask.EndStep(task.StepFetchStatus, myVM.Name, nil)
+
+	//task4: expose same vm ports through ports of target node
+	myTask.StartStep(task.StepDnat, myVM.Name)
+	//all ports reserved before port map switched, source ports already released
+	portMap := make(map[int]string)
+	for _, p := range ports {
+		protocol := strings.Split(myVM.PortMap[p], ":")[1]
+		newPort := target.ReservePort(strings.Split(myVM.IpAddress, "/")[0] + ":" + strconv.Itoa(p))
+		if newPort == 0 {
+			for _, info := range portMap {
+				reserved, _ := strconv.Atoi(strings.Split(info, ":")[0])
+				target.ReleasePort(reserved)
+			}
+			myVM.PortMap = make(map[int]string)
+			err := fmt.Errorf("no port reserved on node %v", target.Name)
+			myVM.Status = vm.VmStatusFailed
+			myTask.EndStep(task.StepDnat, myVM.Name, err)
+			return err
+		}
+		portMap[p] = strconv.Itoa(newPort) + ":" + protocol
+	}
+	myVM.PortMap = portMap
+	if len(ports) > 0 {
+		if err := myVM.ActionDnatRule(ports, "present"); err != nil {
+			myVM.Status = vm.VmStatusFailed
+			myTask.EndStep(task.StepDnat, myVM.Name, err)
+			return err
+		}
+	}
+	log.Printf("DNAT setup success for vm %v on node %v, port mapping -> %v", myVM.Name, target.Name, myVM.PortMap)
+	myTask.EndStep(task.StepDnat, myVM.Name, nil)
+
+	if wasRunning == false {
+		if err := shutdownVMAndWait(myVM); err != nil {
+			log.Printf("Shut down vm %v after migration failed: %v", myVM.Name, err)
+		}
+	}
+
+	msg := fmt.Sprintf("Your VM %v has been migrated to node %v", myVM.Name, target.Name)
+	if sshPort, exists := myVM.PortMap[22]; exists {
+		msg += fmt.Sprintf(", login using ssh %v -p %v", target.IpAddress, strings.Split(sshPort, ":")[0])
+	}
+	myAccount.SendNotification(msg)
+
+	return nil
+}
+
+//Clone VM into a new vm of given account in same project as source, disk copied from snapshot or shut off source vm
+//Account may differ from source owner(e.g. project member), new vm is charged against its quota
+//Target is source node if same node requested, otherwise picked by scheduler
+//This is async call, the returned task can be used to track clone progress
+func CloneVM(myAccount *account.Account, myVM *vm.VirtualMachine, r vm.VmRequestClone) (*vm.VirtualMachine, *task.Task, error) {
+
+	myAccount.Lock()
+	defer myAccount.Unlock()
+	defer db.NotifyToSave()
+
+	myVM.RLock()
+	if myVM.Status != vm.VmStatusRunning && myVM.Status != vm.VmStatusShutoff {
+		myVM.RUnlock()
+		return nil, nil, fmt.Errorf("VM in %v status can't be cloned", myVM.Status)
+	}
+	if r.Snapshot != "" {
+		if _, err := myVM.GetSnapshotByName(r.Snapshot); err != nil {
+			myVM.RUnlock()
+			return nil, nil, err
+		}
+	} else if myVM.Status != vm.VmStatusShutoff {
+		myVM.RUnlock()
+		return nil, nil, fmt.Errorf("VM %v is running, shut it down or clone from a snapshot", myVM.Name)
+	}
+	lifetime := myVM.GetLifeTime()
+	if r.Duration > 0 {
+		lifetime = time.Hour * 24 * time.Duration(r.Duration)
+	}
+	lastIndex := utils.GetLastIndex(myAccount.GetVmNameList())
+	newVm := vm.NewCloneVirtualMachine(myAccount.Name+"-"+strconv.Itoa(lastIndex+1), myVM, r.Hostname, r.Snapshot, lifetime)
+	//source held under its lock, so no action changing disk can slip in between check and copy
+	myVM.HoldCloneSource()
+	myVM.RUnlock()
+
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	requested := quota.Resources{VM: 1, CPU: int(newVm.CPU), Memory: int(newVm.Memory), Disk: int(newVm.Disk)}
+	if err := quota.CheckAll(myAccount.Name, newVm.Project, requested); err != nil {
+		myVM.ReleaseCloneSource()
+		log.Printf("VM clone rejected: %v", err)
+		return nil, nil, err
+	}
+
+	myAccount.AppendVM(newVm)
+	myTask := task.NewTask(task.TaskKindCloneVm, myAccount.Name, newVm.Name)
+	log.Printf("VM %v cloning from vm %v, snapshot -> %v, same node -> %v", newVm.Name, myVM.Name, r.Snapshot, r.SameNode)
+
+	go func() {
+		changeTaskCount(1)
+		defer changeTaskCount(-1)
+		defer myVM.ReleaseCloneSource()
+
+		reqCpu, reqMem, reqDisk := newVm.CPU, newVm.Memory, newVm.Disk*1024
+
+		myTask.StartStep(task.StepSchedule, newVm.Name)
+		scheduleLock.Lock()
+		var selectNode *node.Node
+		var err error
+		if r.SameNode {
+			selectNode = node.GetNodeByName(newVm.Clone.Node)
+			switch {
+			case selectNode == nil:
+				err = fmt.Errorf("node %v not found", newVm.Clone.Node)
+			case selectNode.GetState() != node.NodeStateEnable || selectNode.GetStatus() != node.NodeStatusReady:
+				err = fmt.Errorf("node %v is not enabled or not ready", selectNode.Name)
+			case scheduler.HasCapacity(selectNode, reqCpu, reqMem, reqDisk) == false:
+				err = fmt.Errorf("node %v has no capacity left", selectNode.Name)
+			}
+		} else if selectNode = scheduler.Schedule(node.NodeRoleCompute, newVm.Type, reqCpu, reqMem, reqDisk); selectNode == nil {
+			err = fmt.Errorf("no valid node selected")
+		}
+		if err != nil {
+			scheduleLock.Unlock()
+			log.Printf("Error: VM %v clone exit: %v", newVm.Name, err)
+			newVm.Status = vm.VmStatusFailed
+			myTask.EndStep(task.StepSchedule, newVm.Name, err)
+			myTask.Finish(nil)
+			db.NotifyToSave()
+			return
+		}
+		log.Printf("node selected -> %v", selectNode.Name)
+		selectNode.ChangeCpuUsed(reqCpu)
+		selectNode.ChangeMemUsed(reqMem)
+		selectNode.ChangeDiskUsed(reqDisk)
+		scheduleLock.Unlock()
+
+		newVm.Node = selectNode.Name
+		newVm.NodeAddress = selectNode.IpAddress
+		newVm.Status = vm.VmStatusScheduled
+		myTask.StepMessage(task.StepSchedule, newVm.Name, "node selected -> "+selectNode.Name)
+		myTask.EndStep(task.StepSchedule, newVm.Name, nil)
+		db.NotifyToSave()
+
+		var addonsWg sync.WaitGroup
+		provisionVM(myAccount, newVm, selectNode, myTask, &addonsWg, true)
+		log.Printf("VM %v clone done", newVm.Name)
+		myTask.Finish(nil)
+		db.NotifyToSave()
 	}()
 
-	return newVmGroup, nil
+	return newVm, myTask, nil
+}
+
+//Take a snapshot of VM, snapshot disk is charged against hosted node
+func CreateVMSnapshot(myVM *vm.VirtualMachine, r vm.VmRequestSnapshot) (*vm.Snapshot, error) {
+	changeTaskCount(1)
+	defer changeTaskCount(-1)
+
+	myVM.Lock()
+	defer myVM.Unlock()
+
+	//VM status check
+	if myVM.Status == vm.VmStatusDeleted || myVM.Status == vm.VmStatusDeleting {
+		return nil, fmt.Errorf("VM in deleting or deleted")
+	}
+	//disk being copied by clone
+	if myVM.IsCloneSource() {
+		return nil, fmt.Errorf("VM %v is being cloned, snapshot it after clone done", myVM.Name)
+	}
+	if len(myVM.GetSnapshots()) >= vmMaxSnapshots {
+		return nil, fmt.Errorf("VM %v already has %v snapshots, delete one first", myVM.Name, vmMaxSnapshots)
+	}
+	if r.Name == "" {
+		r.Name = time.Now().Format("20060102-150405")
+	}
+	if snapshotNameRegexp.MatchString(r.Name) == false {
+		return nil, fmt.Errorf("snapshot name %v not valid", r.Name)
+	}
+
+	myNode := node.GetNodeByName(myVM.Node)
+	if myNode == nil {
+		return nil, fmt.Errorf("Error: vm %v hosted node %v not found", myVM.Name, myVM.Node)
+	}
+	//snapshot size known only after taken, it can grow up to whole vm disk
+	if scheduler.HasCapacity(myNode, 0, 0, myVM.Disk*1024) == false {
+		return nil, fmt.Errorf("node %v has no disk left for snapshot of vm %v", myNode.Name, myVM.Name)
+	}
+
+	defer db.NotifyToSave()
+
+	snapshot, err := myVM.CreateSnapshot(r.Name, r.Description)
+	if err != nil {
+		return nil, err
+	}
+	myNode.ChangeDiskUsed(snapshot.Size)
+	log.Printf("Snapshot %v of vm %v created, %vMB disk charged on node %v", snapshot.Name, myVM.Name, snapshot.Size, myNode.Name)
+
+	return snapshot, nil
 }
 
-// Take specify action on VM(start/delete/shutdown/reboot)
-func ActionVM(myAccount *account.Account, myVM *vm.VirtualMachine, action string) error {
+//Take specify action on VM snapshot(revert/delete)
+func ActionVMSnapshot(myVM *vm.VirtualMachine, name, action string) error {
 	changeTaskCount(1)
 	defer changeTaskCount(-1)
 
 	myVM.Lock()
 	defer myVM.Unlock()
 
+	//VM status check
+	if myVM.Status == vm.VmStatusDeleted || myVM.Status == vm.VmStatusDeleting {
+		return fmt.Errorf("VM in deleting or deleted")
+	}
+	if myVM.IsCloneSource() {
+		return fmt.Errorf("VM %v is being cloned, %v snapshot after clone done", myVM.Name, action)
+	}
+
 	defer db.NotifyToSave()
 
-	var action_err error
 	switch action {
-	case "start":
-		//VM status check
-		if myVM.Status == vm.VmStatusDeleted || myVM.Status == vm.VmStatusDeleting {
-			return fmt.Errorf("VM in deleting or deleted")
-		}
-		action_err = myVM.StartUpVirtualMachine()
-	case "shutdown":
-		//VM status check
-		if myVM.Status == vm.VmStatusDeleted || myVM.Status == vm.VmStatusDeleting {
-			return fmt.Errorf("VM in deleting or deleted")
-		}
-		action_err = myVM.ShutDownVirtualMachine()
-	case "reboot":
-		//VM status check
-		if myVM.Status == vm.VmStatusDeleted || myVM.Status == vm.VmStatusDeleting {
-			return fmt.Errorf("VM in deleting or deleted")
-		}
-		action_err = myVM.RebootVirtualMachine()
-	case "delete":
-		myVM.Status = vm.VmStatusDeleting
-		selectNode := node.GetNodeByName(myVM.Node)
-		if selectNode != nil {
-			//Delete VM from node
-			action_err = myVM.DeleteVirtualMachine()
-			if action_err != nil {
-				log.Printf("Delete vm %v failed", myVM.Name)
-				return action_err
-			}
-
-			//Clear dnat rules
-			keys := make([]int, 0, len(myVM.PortMap))
-			for k := range myVM.PortMap {
-				keys = append(keys, k)
-			}
-			if len(keys) > 0 {
-				err := myVM.ActionDnatRule(keys, "absent")
-				if err != nil {
-					log.Printf("Clear dnat for vm %v on host %v failed with error %v", myVM.Name, selectNode.Name, err)
-					return err
-				} else {
-					log.Printf("Clear dnat for vm %v on host %v success", myVM.Name, selectNode.Name)
-					for _, info := range myVM.PortMap {
-						t := strings.Split(info, ":")
-						p, _ := strconv.Atoi(t[0])
-						selectNode.ReleasePort(p)
-					}
-					myVM.PortMap = make(map[int]string)
-				}
-			}
-
-			//Recycle resouces to node
-			log.Println("Recycle node resources")
-			selectNode.ChangeCpuUsed(-myVM.CPU)
-			selectNode.ChangeMemUsed(-myVM.Memory)
-			selectNode.ChangeDiskUsed(-myVM.Disk * 1024)
-			myVM.Node = ""
-		}
-
-		//Remove from account
-		if err := myAccount.RemoveVmByName(myVM.Name); err != nil {
-			log.Println(err)
+	case "revert":
+		if err := myVM.RevertSnapshot(name); err != nil {
 			return err
-		} else {
-			myVM.Status = vm.VmStatusDeleted
 		}
-
-		return nil
-	}
-
-	// Post action, fetch latest vm status
-	switch action {
-	case "start", "shutdown", "reboot":
 		go func() {
 			time.Sleep(time.Second * 10)
 			if err := myVM.GetVirtualMachineLiveStatus(); err != nil {
-				log.Printf("sync up vm -> %v status after action -> %v, failed -> %v", myVM.Name, action, err)
+				log.Printf("sync up vm -> %v status after snapshot revert, failed -> %v", myVM.Name, err)
 			}
 		}()
+	case "delete":
+		snapshot, err := myVM.DeleteSnapshot(name)
+		if err != nil {
+			return err
+		}
+		if myNode := node.GetNodeByName(myVM.Node); myNode != nil {
+			myNode.ChangeDiskUsed(-snapshot.Size)
+		}
+	default:
+		return fmt.Errorf("snapshot action %v not supported", action)
 	}
 
-	return action_err
+	return nil
 }
 
 func ExtendVMLifetime(myVM *vm.VirtualMachine, period time.Duration) error {
@@ -388,58 +1188,71 @@ func ExposePort(myAccount *account.Account, myVM *vm.VirtualMachine, port int, p
 
 // Add a new node
 // this is a async call, will update node status after get reponse from remote deployer
-func AddNode(nodeRequest node.NodeRequest) error {
+func AddNode(nodeRequest node.NodeRequest) (*task.Task, error) {
 
 	_, exists := node.NodeDB.Get(nodeRequest.Name)
 	if exists == true {
-		return fmt.Errorf("node %v already added", nodeRequest.Name)
+		return nil, fmt.Errorf("node %v already added", nodeRequest.Name)
+	}
+	if err := nodeRequest.CheckCredential(); err != nil {
+		return nil, err
+	}
+	//host key fingerprint provided by admin, otherwise trusted on first use
+	if nodeRequest.HostKeyFingerprint != "" {
+		if _, err := hostkey.Pin(hostkey.HostKeyRequest{
+			Address:     nodeRequest.IpAddress,
+			Name:        "node " + nodeRequest.Name,
+			Fingerprint: nodeRequest.HostKeyFingerprint,
+		}); err != nil {
+			return nil, err
+		}
 	}
 
 	newNodeLock.Lock()
 	myNode := node.NewNode(nodeRequest)
+	if myNode == nil {
+		newNodeLock.Unlock()
+		return nil, fmt.Errorf("node request not valid or no subnet left")
+	}
 	node.NodeDB.Set(myNode.Name, myNode)
 	newNodeLock.Unlock()
 	db.NotifyToSave()
 
+	myTask := task.NewTask(task.TaskKindAddNode, "", myNode.Name)
+
 	go func() {
 		changeTaskCount(1)
 		defer changeTaskCount(-1)
-		defer db.NotifyToSave()
+		installNode(myNode, myTask)
+	}()
 
-		//Install node
-		myNode.SetStatus(node.NodeStatusInstalling)
-		db.NotifyToSave()
-		payload, _ := json.Marshal(map[string]interface{}{
-			"Ip":     myNode.IpAddress,
-			"Pass":   myNode.Passwd,
-			"User":   myNode.UserName,
-			"Role":   myNode.Role,
-			"Action": "install",
-			"Subnet": myNode.Subnet,
-		})
+	return myTask, nil
+}
 
-		log.Println("Remote http call to install node")
-		var nodeInfo node.NodeInfo
-		url := deployer.GetDeployerBaseUrl() + "/host"
-		err, reponse_data := utils.HttpSendJsonData(url, "POST", payload)
+//Install node by remote deployer, node resources updated if success
+func installNode(myNode *node.Node, myTask *task.Task) {
 
-		if err != nil {
-			log.Printf("Install node  %v failed with error -> %v %v", myNode.Name, err, string(reponse_data))
-			myNode.SetStatus(node.NodeStatusInstallFailed)
-			return
-		} else {
-			log.Printf("Install node %v successfully", myNode.Name)
-			json.Unmarshal(reponse_data, &nodeInfo)
-			myNode.CPU = nodeInfo.CPU
-			myNode.Memory = nodeInfo.Memory
-			myNode.Disk = nodeInfo.Disk
-			myNode.OSType = nodeInfo.OSType
-			log.Printf("Created node %v info: cpu -> %v, memory -> %v, disk -> %v, os type -> %v", myNode.Name, myNode.CPU, myNode.Memory, myNode.Disk, myNode.OSType)
-			myNode.SetStatus(node.NodeStatusInstalled)
-		}
-	}()
+	defer db.NotifyToSave()
 
-	return nil
+	myNode.SetStatus(node.NodeStatusInstalling)
+	myTask.StartStep(task.StepInstallNode, myNode.Name)
+	db.NotifyToSave()
+
+	nodeInfo, err := deployer.GetClient().InstallHost(myNode.DeployerHost(), myNode.Subnet)
+	myTask.EndStep(task.StepInstallNode, myNode.Name, err)
+	if err != nil {
+		log.Printf("Install node  %v failed with error -> %v", myNode.Name, err)
+		myNode.SetStatus(node.NodeStatusInstallFailed)
+	} else {
+		log.Printf("Install node %v successfully", myNode.Name)
+		myNode.CPU = nodeInfo.CPU
+		myNode.Memory = nodeInfo.Memory
+		myNode.Disk = nodeInfo.Disk
+		myNode.OSType = nodeInfo.OSType
+		log.Printf("Created node %v info: cpu -> %v, memory -> %v, disk -> %v, os type -> %v", myNode.Name, myNode.CPU, myNode.Memory, myNode.Disk, myNode.OSType)
+		myNode.SetStatus(node.NodeStatusInstalled)
+	}
+	myTask.Finish(nil)
 }
 
 // Take specify action on Node(remove/reboot)
@@ -459,6 +1272,8 @@ func ActionNode(name string, action node.NodeAction) error {
 			return fmt.Errorf("Still have vm hosted on node %v, can't be removed", name)
 		}
 		node.NodeDB.Del(name)
+		hostkey.ForgetHost(myNode.IpAddress)
+		image.ImageDB.ForgetNode(name)
 		log.Printf("node %v removed", name)
 	case node.NodeActionReboot:
 		if err := myNode.RebootNode(); err != nil {
@@ -466,11 +1281,23 @@ func ActionNode(name string, action node.NodeAction) error {
 			return err
 		}
 	case node.NodeActionEnable:
+		if myNode.GetState() == node.NodeStateMaintenance {
+			return fmt.Errorf("node %v is in maintenance, uncordon it after maintenance done", name)
+		}
 		myNode.SetState(node.NodeStateEnable)
 		log.Printf("Set node %v state to %v", name, node.NodeStateEnable)
 	case node.NodeActionDisable:
 		myNode.SetState(node.NodeStateDisable)
 		log.Printf("Set node %v state to %v", name, node.NodeStateDisable)
+	case node.NodeActionUncordon:
+		if myNode.GetState() != node.NodeStateMaintenance {
+			return fmt.Errorf("node %v is not in maintenance", name)
+		}
+		if isDraining(name) {
+			return fmt.Errorf("node %v still draining", name)
+		}
+		myNode.SetState(node.NodeStateEnable)
+		log.Printf("Set node %v state to %v, maintenance done", name, node.NodeStateEnable)
 	default:
 		return fmt.Errorf("action %v not supported", action)
 	}
@@ -478,8 +1305,120 @@ func ActionNode(name string, action node.NodeAction) error {
 	return nil
 }
 
+//Drain node for maintenance, node stops accepting new vm/software at once
+//VMs are migrated to other nodes one by one, or shut down if stop requested
+//Containers can't be migrated, they are stopped
+//This is async call, the returned task can be used to track drain progress
+func DrainNode(name string, r node.NodeRequestDrain) (*task.Task, error) {
+
+	myNode, exists := node.NodeDB.Get(name)
+	if exists == false {
+		return nil, fmt.Errorf("node not existed")
+	}
+	drainLock.Lock()
+	if drainingNodes[name] {
+		drainLock.Unlock()
+		return nil, fmt.Errorf("node %v already draining", name)
+	}
+	drainingNodes[name] = true
+	drainLock.Unlock()
+
+	myNode.SetState(node.NodeStateMaintenance)
+	log.Printf("Set node %v state to %v, draining", name, node.NodeStateMaintenance)
+	db.NotifyToSave()
+
+	//workloads hosted on node, with their owners
+	type hostedVm struct {
+		owner *account.Account
+		vm    *vm.VirtualMachine
+	}
+	type hostedSoftware struct {
+		owner    *account.Account
+		software *saas.Software
+	}
+	vms := []hostedVm{}
+	softwares := []hostedSoftware{}
+	accounts := []*account.Account{}
+	for ac := range account.AccountDB.Iter() {
+		accounts = append(accounts, ac.Value)
+	}
+	for _, myAccount := range accounts {
+		for myVm := range myAccount.Iter() {
+			myVm.RLock()
+			hosted := myVm.Node == name
+			myVm.RUnlock()
+			if hosted {
+				vms = append(vms, hostedVm{myAccount, myVm})
+			}
+		}
+		for mySoftware := range myAccount.IterSoftware() {
+			if mySoftware.Node == name && mySoftware.Backend == "container" {
+				softwares = append(softwares, hostedSoftware{myAccount, mySoftware})
+			}
+		}
+	}
+
+	myTask := task.NewTask(task.TaskKindDrainNode, "", name)
+	go func() {
+		changeTaskCount(1)
+		defer changeTaskCount(-1)
+		defer func() {
+			drainLock.Lock()
+			delete(drainingNodes, name)
+			drainLock.Unlock()
+		}()
+
+		for _, h := range vms {
+			if r.Stop {
+				myTask.StartStep(task.StepStop, h.vm.Name)
+				err := ActionVM(h.owner, h.vm, "shutdown")
+				myTask.EndStep(task.StepStop, h.vm.Name, err)
+				if err == nil {
+					h.owner.SendNotification(fmt.Sprintf("Your VM %v has been shut down for maintenance of node %v", h.vm.Name, name))
+				}
+				continue
+			}
+
+			h.vm.RLock()
+			live := r.Live && h.vm.Status == vm.VmStatusRunning
+			h.vm.RUnlock()
+			if err := migrateVM(h.owner, h.vm, "", live, myTask); err != nil {
+				log.Printf("Drain node %v, vm %v migration failed: %v", name, h.vm.Name, err)
+			}
+		}
+
+		for _, h := range softwares {
+			if h.software.GetStatus() != saas.SoftwareStatusRunning {
+				continue
+			}
+			myTask.StartStep(task.StepStop, h.software.Name)
+			err := ActionSoftware(h.owner, h.software.Name, saas.SoftwareActionStop)
+			myTask.EndStep(task.StepStop, h.software.Name, err)
+			if err == nil {
+				h.owner.SendNotification(fmt.Sprintf("Your software %v has been stopped for maintenance of node %v", h.software.Name, name))
+			}
+		}
+
+		myTask.Finish(nil)
+		log.Printf("Drain node %v done, task -> %v", name, myTask.Id)
+		db.NotifyToSave()
+	}()
+
+	return myTask, nil
+}
+
+//Whether node drain still in progress
+func isDraining(name string) bool {
+
+	drainLock.Lock()
+	defer drainLock.Unlock()
+
+	return drainingNodes[name]
+}
+
 //Create k8s cluster
-func CreateK8S(myAccount *account.Account, k8sRequest k8s.K8sRequest) error {
+//This is async call, the returned task can be used to track creation progress
+func CreateK8S(myAccount *account.Account, k8sRequest k8s.K8sRequest) (*task.Task, error) {
 
 	myAccount.Lock()
 	defer myAccount.Unlock()
@@ -489,97 +1428,184 @@ func CreateK8S(myAccount *account.Account, k8sRequest k8s.K8sRequest) error {
 	lastIndex := utils.GetLastIndex(myAccount.GetK8sNameList())
 
 	newK8s := k8s.NewK8s(myAccount.Name+"-k8s-"+strconv.Itoa(lastIndex+1), k8sRequest)
-	if newK8s != nil {
-		myAccount.AppendK8S(newK8s)
-	} else {
-		return fmt.Errorf("Input paramters not valid")
+	if newK8s == nil {
+		return nil, fmt.Errorf("Input paramters not valid")
+	}
+
+	//check quota before scheduling, host vm is counted as well
+	hostFlavor, err := k8sHostFlavorOf(newK8s.NumOfWorker)
+	if err != nil {
+		log.Printf("K8S creation rejected: %v", err)
+		return nil, err
+	}
+	requested := quota.Resources{
+		K8S:    1,
+		VM:     1,
+		CPU:    int(hostFlavor.CPU),
+		Memory: int(hostFlavor.Memory),
+		Disk:   int(hostFlavor.Disk),
+	}
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	if err := quota.CheckAll(myAccount.Name, k8sRequest.Project, requested); err != nil {
+		log.Printf("K8S creation rejected: %v", err)
+		return nil, err
 	}
+	myAccount.AppendK8S(newK8s)
+
+	myTask := task.NewTask(task.TaskKindCreateK8s, myAccount.Name, newK8s.Name)
 
 	log.Printf("K8S cluster %v creating ...", newK8s.Name)
 	go func() {
 		changeTaskCount(1)
 		defer changeTaskCount(-1)
-		defer db.NotifyToSave()
+		provisionK8S(myAccount, newK8s, myTask)
+	}()
+
+	return myTask, nil
+}
+
+//Flavor of vm hosting k8s cluster, configured flavor must exist in catalog
+func k8sHostFlavorOf(numOfWorker uint16) (*flavor.Flavor, error) {
+
+	name := k8sHostFlavor
+	if int(numOfWorker) > k8sLargeWorkers {
+		name = k8sLargeHostFlavor
+	}
+	hostFlavor, exists := flavor.FlavorDB.Get(name)
+	if exists == false {
+		return nil, &flavor.InvalidError{Name: name, Reason: "of k8s host vm not found in catalog"}
+	}
+
+	return hostFlavor, nil
+}
+
+//Catalog flavors used by k8s host vm or blueprints, they can't be deleted
+func FlavorDependents(name string) []string {
 
-		//task1: VM instantiation
-		newK8s.SetStatus(k8s.K8sStatusBootingVm)
+	dependents := []string{}
+	if name == k8sHostFlavor || name == k8sLargeHostFlavor {
+		dependents = append(dependents, "k8s host vm")
+	}
+	for b := range blueprint.BlueprintDB.Iter() {
+		for _, t := range b.Value.VMs {
+			if t.Flavor == name {
+				dependents = append(dependents, "blueprint "+b.Value.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(dependents)
+
+	return dependents
+}
+
+//Provision k8s cluster: boot host vm, then install k8s on it
+//Host vm will be reused if already bound, e.g. resumed after controller restart
+func provisionK8S(myAccount *account.Account, myK8s *k8s.K8S, myTask *task.Task) {
 
-		var flavor = "middle"
-		if newK8s.NumOfWorker > 5 {
-			flavor = "large"
+	defer db.NotifyToSave()
+	defer myTask.Finish(nil)
+
+	//task1: VM instantiation
+	myK8s.SetStatus(k8s.K8sStatusBootingVm)
+	myTask.StartStep(task.StepBootVm, myK8s.Name)
+
+	var hostVm *vm.VirtualMachine
+	if myK8s.HostVm != "" {
+		hostVm, _ = myAccount.GetVmByName(myK8s.HostVm)
+	}
+	if hostVm == nil {
+		hostFlavor, err := k8sHostFlavorOf(myK8s.NumOfWorker)
+		if err != nil {
+			log.Printf("k8s vm creation failed: %v", err)
+			myK8s.SetStatus(k8s.K8sStatusBootVmFailed)
+			myTask.EndStep(task.StepBootVm, myK8s.Name, err)
+			return
 		}
 		vmRequest := vm.VmRequest{
-			Hostname: newK8s.Name,
-			Type:     "centos7",
-			Flavor:   flavor,
+			Hostname: myK8s.Name,
+			Type:     k8sHostImage,
+			Flavor:   hostFlavor.Name,
 			Number:   1,
-			Duration: int(newK8s.Lifetime),
+			Duration: int(myK8s.Lifetime),
+			Project:  myK8s.Project,
 		}
-		vmGroup, err := CreateVMs(myAccount, vmRequest)
+		vmGroup, vmTask, err := CreateVMs(myAccount, vmRequest)
 		if err != nil || len(vmGroup) != 1 {
 			log.Println("k8s vm creation failed")
+			myK8s.SetStatus(k8s.K8sStatusBootVmFailed)
+			myTask.EndStep(task.StepBootVm, myK8s.Name, fmt.Errorf("k8s vm creation failed"))
 			return
 		}
 
-		hostVm := vmGroup[0]
+		hostVm = vmGroup[0]
 		//binding vm and k8s
-		newK8s.HostVm = hostVm.Name
+		myK8s.HostVm = hostVm.Name
+		myTask.StepMessage(task.StepBootVm, myK8s.Name, "vm "+hostVm.Name+" created by task "+vmTask.Id)
 		//make sure vm is active before k8s installation
+		for vmTask.GetStatus() == task.TaskStatusPending || vmTask.GetStatus() == task.TaskStatusRunning {
+			log.Println("k8s vm creation not finished, will check again")
+			time.Sleep(time.Second * time.Duration(vmStatusInterval))
+		}
+		if vmTask.GetStatus() == task.TaskStatusFailed {
+			hostVm.Status = vm.VmStatusFailed
+		}
+	} else {
+		myTask.StepMessage(task.StepBootVm, myK8s.Name, "reuse vm "+hostVm.Name)
+		//vm may still in provisioning, wait until it is reachable by ssh
 		retry := 1
 		for retry <= vmStatusRetry {
-			_, _, err = net.ParseCIDR(hostVm.IpAddress)
-			if err == nil {
+			if _, exists := hostVm.PortMap[22]; exists && hostVm.Status == vm.VmStatusRunning {
+				break
+			}
+			if hostVm.Status == vm.VmStatusFailed || hostVm.Status == vm.VmStatusDeleting || hostVm.Status == vm.VmStatusDeleted {
 				break
 			}
-			log.Println("k8s vm ipaddress not assigned, will try again")
+			log.Printf("k8s %v host vm %v not ready, will check again", myK8s.Name, hostVm.Name)
 			time.Sleep(time.Second * time.Duration(vmStatusInterval))
 			retry++
 		}
-		if retry > vmStatusRetry {
-			newK8s.SetStatus(k8s.K8sStatusBootVmFailed)
-			log.Println("k8s vm get ipaddress timeout, exited")
-			return
-		}
-
-		//task2: K8S installation
-		newK8s.SetStatus(k8s.K8sStatusInstalling)
-
-		hostNode := node.GetNodeByName(hostVm.Node)
-		if hostNode == nil {
-			log.Printf("Error: vm %v hosted node %v not found", hostVm.Name, hostVm.Node)
-			return
-		}
-		payload, _ := json.Marshal(map[string]interface{}{
-			"Ip":         hostNode.IpAddress,
-			"Port":       strings.Split(hostVm.PortMap[22], ":")[0],
-			"Pass":       hostVm.RootPass,
-			"User":       "root",
-			"Controller": newK8s.NumOfContronller,
-			"Worker":     newK8s.NumOfWorker,
-		})
-
-		//install k8s take long time,so better to notify db to save before activity
-		db.NotifyToSave()
+	}
+	if _, _, err := net.ParseCIDR(hostVm.IpAddress); err != nil || hostVm.Status != vm.VmStatusRunning {
+		myK8s.SetStatus(k8s.K8sStatusBootVmFailed)
+		log.Println("k8s vm creation failed, exited")
+		myTask.EndStep(task.StepBootVm, myK8s.Name, fmt.Errorf("vm %v creation failed", hostVm.Name))
+		return
+	}
+	myTask.EndStep(task.StepBootVm, myK8s.Name, nil)
+
+	//task2: K8S installation
+	myK8s.SetStatus(k8s.K8sStatusInstalling)
+	myTask.StartStep(task.StepInstallK8s, myK8s.Name)
+
+	hostNode := node.GetNodeByName(hostVm.Node)
+	if hostNode == nil {
+		err := fmt.Errorf("vm %v hosted node %v not found", hostVm.Name, hostVm.Node)
+		log.Printf("Error: %v", err)
+		myK8s.SetStatus(k8s.K8sStatusInstallFailed)
+		myTask.EndStep(task.StepInstallK8s, myK8s.Name, err)
+		return
+	}
 
-		log.Println("Remote http call to install k8s cluster")
-		url := deployer.GetDeployerBaseUrl() + "/k8s"
-		err, reponse_data := utils.HttpSendJsonData(url, "POST", payload)
-		if err != nil {
-			newK8s.SetStatus(k8s.K8sStatusInstallFailed)
-			err_msg := fmt.Sprintf("k8s cluster %v installation failed with error -> %v %v", newK8s.Name, err, string(reponse_data))
-			log.Printf(err_msg)
-			myAccount.SendNotification(err_msg)
-			return
-		} else {
-			newK8s.SetStatus(k8s.K8sStatusRunning)
-			log.Printf("k8s cluster %v installation successfully", newK8s.Name)
-		}
+	//install k8s take long time,so better to notify db to save before activity
+	db.NotifyToSave()
 
-		//task3, send notification
-		myAccount.SendNotification(fmt.Sprintf("Your k8s cluster %v is ready to use, Please login vm %v to access cluster", newK8s.Name, hostVm.Name))
-	}()
+	err := deployer.GetClient().InstallK8s(hostVm.DeployerTarget(), myK8s.NumOfContronller, myK8s.NumOfWorker)
+	myTask.EndStep(task.StepInstallK8s, myK8s.Name, err)
+	if err != nil {
+		myK8s.SetStatus(k8s.K8sStatusInstallFailed)
+		err_msg := fmt.Sprintf("k8s cluster %v installation failed with error -> %v", myK8s.Name, err)
+		log.Printf(err_msg)
+		myAccount.SendNotification(err_msg)
+		return
+	} else {
+		myK8s.SetStatus(k8s.K8sStatusRunning)
+		log.Printf("k8s cluster %v installation successfully", myK8s.Name)
+	}
 
-	return nil
+	//task3, send notification
+	myAccount.SendNotification(fmt.Sprintf("Your k8s cluster %v is ready to use, Please login vm %v to access cluster", myK8s.Name, hostVm.Name))
 }
 
 //Delete k8s cluster
@@ -616,7 +1642,8 @@ func DeleteK8S(myaccount *account.Account, name string) error {
 }
 
 //Create software
-func CreateSoftware(myAccount *account.Account, softwareRequest saas.SoftwareRequest) error {
+//This is async call, the returned task can be used to track creation progress
+func CreateSoftware(myAccount *account.Account, softwareRequest saas.SoftwareRequest) (*task.Task, error) {
 	myAccount.Lock()
 	defer myAccount.Unlock()
 	defer db.NotifyToSave()
@@ -625,81 +1652,134 @@ func CreateSoftware(myAccount *account.Account, softwareRequest saas.SoftwareReq
 	lastIndex := utils.GetLastIndex(myAccount.GetSoftwareNameList())
 
 	newSoftware := saas.NewSoftware(myAccount.Name+"-"+softwareRequest.Kind+"-"+strconv.Itoa(lastIndex+1), softwareRequest)
-	if newSoftware != nil {
-		myAccount.AppendSoftware(newSoftware)
-	} else {
-		return fmt.Errorf("Software request may wrong, create new software failed")
+	if newSoftware == nil {
+		return nil, fmt.Errorf("Software request may wrong, create new software failed")
+	}
+
+	//check quota before scheduling
+	requested := quota.Resources{
+		Software: 1,
+		CPU:      int(newSoftware.CPU),
+		Memory:   int(newSoftware.Memory),
 	}
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	if err := quota.CheckAll(myAccount.Name, softwareRequest.Project, requested); err != nil {
+		log.Printf("Software creation rejected: %v", err)
+		return nil, err
+	}
+	myAccount.AppendSoftware(newSoftware)
+
+	myTask := task.NewTask(task.TaskKindCreateSoftware, myAccount.Name, newSoftware.Name)
 
 	log.Printf("Software %v creating ...", newSoftware.Name)
 	go func() {
 		changeTaskCount(1)
 		defer changeTaskCount(-1)
-		newSoftware.Lock()
-		defer newSoftware.Unlock()
-		defer db.NotifyToSave()
+		provisionSoftware(myAccount, newSoftware, myTask)
+	}()
+
+	return myTask, nil
+}
+
+//Provision software: schedule a node then install it
+//Scheduling skipped if node already assigned, installed container will be adopted instead of creating again
+func provisionSoftware(myAccount *account.Account, mySoftware *saas.Software, myTask *task.Task) {
+
+	mySoftware.Lock()
+	defer mySoftware.Unlock()
+	defer db.NotifyToSave()
+	defer myTask.Finish(nil)
 
-		//task1: Software installation
-		if newSoftware.Backend == "container" {
+	//task1: Software installation
+	if mySoftware.Backend == "container" {
 
+		var selectNode *node.Node
+		resumed := mySoftware.Node != ""
+		if resumed {
+			selectNode = node.GetNodeByName(mySoftware.Node)
+			if selectNode == nil {
+				err := fmt.Errorf("software %v hosted node %v not found", mySoftware.Name, mySoftware.Node)
+				log.Printf("Error: %v", err)
+				mySoftware.Node = ""
+				mySoftware.SetStatus(saas.SoftwareStatusInstallFailed)
+				myTask.StartStep(task.StepInstallSaaS, mySoftware.Name)
+				myTask.EndStep(task.StepInstallSaaS, mySoftware.Name, err)
+				return
+			}
+		} else {
 			//call scheduler to select a node
-			reqCpu := newSoftware.CPU
-			reqMem := newSoftware.Memory
+			reqCpu := mySoftware.CPU
+			reqMem := mySoftware.Memory
+			myTask.StartStep(task.StepSchedule, mySoftware.Name)
 			scheduleLock.Lock()
-			selectNode := scheduler.Schedule(node.NodeRoleContainer, int32(reqCpu), int32(reqMem), 0)
+			selectNode = scheduler.Schedule(node.NodeRoleContainer, "", int32(reqCpu), int32(reqMem), 0)
 			if selectNode == nil {
-				err_msg := fmt.Sprintf("Error: No valid node selected, software %v creation exit", newSoftware.Name)
+				err_msg := fmt.Sprintf("Error: No valid node selected, software %v creation exit", mySoftware.Name)
 				log.Printf(err_msg)
 				myAccount.SendNotification(err_msg)
 				scheduleLock.Unlock()
+				mySoftware.SetStatus(saas.SoftwareStatusInstallFailed)
+				myTask.EndStep(task.StepSchedule, mySoftware.Name, fmt.Errorf("no valid node selected"))
 				return
 			}
-			log.Printf("node selected -> %v for software %v", selectNode.Name, newSoftware.Name)
+			log.Printf("node selected -> %v for software %v", selectNode.Name, mySoftware.Name)
 			selectNode.ChangeCpuUsed(int32(reqCpu))
 			selectNode.ChangeMemUsed(int32(reqMem))
 			selectNode.ChangeDiskUsed(0)
 			scheduleLock.Unlock()
 
-			newSoftware.Node = selectNode.Name
-			newSoftware.SetStatus(saas.SoftwareStatusScheduled)
-			newSoftware.SetStatus(saas.SoftwareStatusInstalling)
-			payload, _ := json.Marshal(map[string]interface{}{
-				"Ip":       selectNode.IpAddress,
-				"Pass":     selectNode.Passwd,
-				"User":     selectNode.UserName,
-				"Name":     newSoftware.Name,
-				"Software": newSoftware.Kind,
-				"Version":  newSoftware.Version,
-				"Cpu":      newSoftware.CPU,
-				"Memory":   strconv.Itoa(int(newSoftware.Memory)) + "m",
-			})
-
-			log.Printf("Remote http call to install software %v", newSoftware.Name)
-			url := deployer.GetDeployerBaseUrl() + "/container"
-			err, reponse_data := utils.HttpSendJsonData(url, "POST", payload)
-			if err != nil {
-				newSoftware.SetStatus(saas.SoftwareStatusInstallFailed)
-				err_msg := fmt.Sprintf("software %v installation failed with error -> %v %v", newSoftware.Name, err, string(reponse_data))
-				log.Printf(err_msg)
-				myAccount.SendNotification(err_msg)
+			mySoftware.Node = selectNode.Name
+			mySoftware.SetStatus(saas.SoftwareStatusScheduled)
+			myTask.StepMessage(task.StepSchedule, mySoftware.Name, "node selected -> "+selectNode.Name)
+			myTask.EndStep(task.StepSchedule, mySoftware.Name, nil)
+			db.NotifyToSave()
+		}
+
+		mySoftware.SetStatus(saas.SoftwareStatusInstalling)
+		myTask.StartStep(task.StepInstallSaaS, mySoftware.Name)
+		if resumed {
+			//container may be created already before interrupted
+			containerInfo, err := deployer.GetClient().ActionContainer(selectNode.DeployerHost(), mySoftware.Name, mySoftware.Kind, string(saas.SoftwareActionGet))
+			if err == nil && containerInfo.Status != "" && containerInfo.Status != "deleted" {
+				log.Printf("software %v already installed on node %v", mySoftware.Name, selectNode.Name)
+				readContainerStatus(mySoftware, containerInfo)
+				myTask.StepMessage(task.StepInstallSaaS, mySoftware.Name, "adopt existing container")
+				myTask.EndStep(task.StepInstallSaaS, mySoftware.Name, nil)
+				myAccount.SendNotification(fmt.Sprintf("Your software %v is created", mySoftware.Name))
 				return
-			} else {
-				log.Printf("software %v installation successfully", newSoftware.Name)
-				readContainerStatus(newSoftware, reponse_data)
 			}
-		} else {
-			newSoftware.SetStatus(saas.SoftwareStatusError)
-			err_msg := fmt.Sprintf("%v backend not support", newSoftware.Backend)
+		}
+		containerInfo, err := deployer.GetClient().CreateContainer(selectNode.DeployerHost(), deployer.ContainerSpec{
+			Name:     mySoftware.Name,
+			Software: mySoftware.Kind,
+			Version:  mySoftware.Version,
+			CPU:      mySoftware.CPU,
+			Memory:   mySoftware.Memory,
+		})
+		myTask.EndStep(task.StepInstallSaaS, mySoftware.Name, err)
+		if err != nil {
+			mySoftware.SetStatus(saas.SoftwareStatusInstallFailed)
+			err_msg := fmt.Sprintf("software %v installation failed with error -> %v", mySoftware.Name, err)
 			log.Printf(err_msg)
 			myAccount.SendNotification(err_msg)
 			return
+		} else {
+			log.Printf("software %v installation successfully", mySoftware.Name)
+			readContainerStatus(mySoftware, containerInfo)
 		}
+	} else {
+		mySoftware.SetStatus(saas.SoftwareStatusError)
+		err_msg := fmt.Sprintf("%v backend not support", mySoftware.Backend)
+		log.Printf(err_msg)
+		myAccount.SendNotification(err_msg)
+		myTask.StartStep(task.StepInstallSaaS, mySoftware.Name)
+		myTask.EndStep(task.StepInstallSaaS, mySoftware.Name, fmt.Errorf(err_msg))
+		return
+	}
 
-		//task2, send notification
-		myAccount.SendNotification(fmt.Sprintf("Your software %v is created", newSoftware.Name))
-	}()
-
-	return nil
+	//task2, send notification
+	myAccount.SendNotification(fmt.Sprintf("Your software %v is created", mySoftware.Name))
 }
 
 func ActionSoftware(myAccount *account.Account, name string, action saas.SoftwareAction) error {
@@ -722,28 +1802,17 @@ func ActionSoftware(myAccount *account.Account, name string, action saas.Softwar
 	}
 
 	if mySoftware.Backend == "container" {
-		payload, _ := json.Marshal(map[string]interface{}{
-			"Ip":       selectNode.IpAddress,
-			"Pass":     selectNode.Passwd,
-			"User":     selectNode.UserName,
-			"Name":     mySoftware.Name,
-			"Software": mySoftware.Kind,
-			"Action":   action,
-		})
-		url := deployer.GetDeployerBaseUrl() + "/container/action"
-
-		log.Printf("Remote http call to %v software %v", action, name)
-		err, reponse_data := utils.HttpSendJsonData(url, "POST", payload)
+		containerInfo, err := deployer.GetClient().ActionContainer(selectNode.DeployerHost(), mySoftware.Name, mySoftware.Kind, string(action))
 		if err != nil {
 			mySoftware.SetStatus(saas.SoftwareStatusError)
-			log.Printf("Remote http call to %v software %v failed with error: %v %v", action, name, err, string(reponse_data))
-			myAccount.SendNotification(fmt.Sprintf("%v your software %v failed with error: %v %v", action, mySoftware.Name, err, string(reponse_data)))
+			log.Printf("Remote call to %v software %v failed with error: %v", action, name, err)
+			myAccount.SendNotification(fmt.Sprintf("%v your software %v failed with error: %v", action, mySoftware.Name, err))
 			return err
 		}
 
 		switch action {
 		case saas.SoftwareActionStart, saas.SoftwareActionRestart, saas.SoftwareActionGet:
-			readContainerStatus(mySoftware, reponse_data)
+			readContainerStatus(mySoftware, containerInfo)
 		case saas.SoftwareActionStop:
 			mySoftware.Address = ""
 			mySoftware.PortMapping = nil
@@ -779,22 +1848,11 @@ func DeleteSoftware(myAccount *account.Account, name string) error {
 		//Recycle node resouces
 		selectNode := node.GetNodeByName(mySoftware.Node)
 		if selectNode != nil {
-			payload, _ := json.Marshal(map[string]interface{}{
-				"Ip":       selectNode.IpAddress,
-				"Pass":     selectNode.Passwd,
-				"User":     selectNode.UserName,
-				"Name":     mySoftware.Name,
-				"Software": mySoftware.Kind,
-				"Action":   action,
-			})
-			url := deployer.GetDeployerBaseUrl() + "/container/action"
-
-			log.Printf("Remote http call to %v software %v", action, name)
-			err, reponse_data := utils.HttpSendJsonData(url, "POST", payload)
+			_, err := deployer.GetClient().ActionContainer(selectNode.DeployerHost(), mySoftware.Name, mySoftware.Kind, string(action))
 			if err != nil {
 				mySoftware.SetStatus(saas.SoftwareStatusError)
-				log.Printf("Remote http call to %v software %v failed with error: %v %v", action, name, err, string(reponse_data))
-				myAccount.SendNotification(fmt.Sprintf("%v your software %v failed with error: %v %v", action, mySoftware.Name, err, string(reponse_data)))
+				log.Printf("Remote call to %v software %v failed with error: %v", action, name, err)
+				myAccount.SendNotification(fmt.Sprintf("%v your software %v failed with error: %v", action, mySoftware.Name, err))
 				return err
 			}
 
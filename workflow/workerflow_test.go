@@ -0,0 +1,208 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JinlongWukong/DevLab/account"
+	"github.com/JinlongWukong/DevLab/deployer"
+	"github.com/JinlongWukong/DevLab/node"
+	"github.com/JinlongWukong/DevLab/quota"
+	"github.com/JinlongWukong/DevLab/task"
+	"github.com/JinlongWukong/DevLab/vm"
+)
+
+//Wait until task finished, fail test if timeout
+func waitTask(t *testing.T, myTask *task.Task) task.TaskStatus {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		status := myTask.GetStatus()
+		if status == task.TaskStatusSuccess || status == task.TaskStatusFailed {
+			return status
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("task %v not finished in time", myTask.Id)
+	return ""
+}
+
+//Install a compute node on fake deployer, ready for scheduling
+func addFakeNode(t *testing.T, name, ip string) *node.Node {
+	t.Helper()
+
+	myTask, err := AddNode(node.NodeRequest{
+		Name:      name,
+		User:      "root",
+		Passwd:    "secret",
+		IpAddress: ip,
+		Role:      node.NodeRoleCompute,
+	})
+	if err != nil {
+		t.Fatalf("add node failed: %v", err)
+	}
+	if status := waitTask(t, myTask); status != task.TaskStatusSuccess {
+		t.Fatalf("add node task %v", status)
+	}
+	myNode := node.GetNodeByName(name)
+	myNode.SetStatus(node.NodeStatusReady)
+	return myNode
+}
+
+func TestCreateAndDeleteVM(t *testing.T) {
+
+	fake := deployer.GetClient().(*deployer.FakeClient)
+
+	myNode := addFakeNode(t, "compute-1", "10.0.0.1")
+
+	if err := account.AccountDB.Add(account.AccountRequest{Name: "alice", Role: account.RoleGuest}); err != nil {
+		t.Fatalf("add account failed: %v", err)
+	}
+	myAccount, _ := account.AccountDB.Get("alice")
+
+	vms, myTask, err := CreateVMs(myAccount, vm.VmRequest{
+		Type:     "centos7",
+		CPU:      2,
+		Memory:   2048,
+		Disk:     20,
+		Number:   2,
+		Duration: 1,
+	})
+	if err != nil {
+		t.Fatalf("create vms failed: %v", err)
+	}
+	if status := waitTask(t, myTask); status != task.TaskStatusSuccess {
+		t.Fatalf("create vms task %v", status)
+	}
+
+	for _, myVm := range vms {
+		if myVm.Status != vm.VmStatusRunning {
+			t.Errorf("vm %v status %v, expected %v", myVm.Name, myVm.Status, vm.VmStatusRunning)
+		}
+		if myVm.Node != myNode.Name {
+			t.Errorf("vm %v on node %q, expected %q", myVm.Name, myVm.Node, myNode.Name)
+		}
+		if myVm.IpAddress == "" {
+			t.Errorf("vm %v has no address", myVm.Name)
+		}
+		if _, exists := myVm.PortMap[22]; exists == false {
+			t.Errorf("vm %v ssh port not exposed", myVm.Name)
+		}
+	}
+	if names := fake.GetVmNames(myNode.IpAddress); len(names) != 2 {
+		t.Errorf("fake deployer has vms %v, expected 2", names)
+	}
+	if myNode.CpuUsed != 4 || myNode.MemUsed != 4096 || myNode.DiskUsed != 40*1024 {
+		t.Errorf("node used cpu %v mem %v disk %v, expected 4 4096 %v",
+			myNode.CpuUsed, myNode.MemUsed, myNode.DiskUsed, 40*1024)
+	}
+
+	for _, myVm := range vms {
+		if err := ActionVM(myAccount, myVm, "delete"); err != nil {
+			t.Fatalf("delete vm %v failed: %v", myVm.Name, err)
+		}
+		if myVm.Status != vm.VmStatusDeleted {
+			t.Errorf("vm %v status %v after delete", myVm.Name, myVm.Status)
+		}
+	}
+	if names := fake.GetVmNames(myNode.IpAddress); len(names) != 0 {
+		t.Errorf("vms %v still exist on fake deployer", names)
+	}
+	if myAccount.GetNumbersOfVm() != 0 {
+		t.Errorf("account still has %v vms", myAccount.GetNumbersOfVm())
+	}
+	if myNode.CpuUsed != 0 || myNode.MemUsed != 0 || myNode.DiskUsed != 0 {
+		t.Errorf("node resources not recycled, cpu %v mem %v disk %v",
+			myNode.CpuUsed, myNode.MemUsed, myNode.DiskUsed)
+	}
+}
+
+func TestCreateVMSnapshotGuards(t *testing.T) {
+
+	addFakeNode(t, "compute-2", "10.0.0.2")
+	if err := account.AccountDB.Add(account.AccountRequest{Name: "bob", Role: account.RoleGuest}); err != nil {
+		t.Fatalf("add account failed: %v", err)
+	}
+	myAccount, _ := account.AccountDB.Get("bob")
+
+	vms, myTask, err := CreateVMs(myAccount, vm.VmRequest{Type: "centos7", CPU: 1, Memory: 1024, Disk: 20, Number: 1, Duration: 1})
+	if err != nil {
+		t.Fatalf("create vm failed: %v", err)
+	}
+	if status := waitTask(t, myTask); status != task.TaskStatusSuccess {
+		t.Fatalf("create vm task %v", status)
+	}
+	myVm := vms[0]
+	myNode := node.GetNodeByName(myVm.Node)
+
+	//disk being copied by clone
+	myVm.HoldCloneSource()
+	if _, err := CreateVMSnapshot(myVm, vm.VmRequestSnapshot{Name: "s1"}); err == nil {
+		t.Errorf("snapshot taken while vm being cloned")
+	}
+	myVm.ReleaseCloneSource()
+
+	//no room on node for snapshot growing up to vm disk
+	myNode.ChangeDiskUsed(myNode.Disk * 10)
+	if _, err := CreateVMSnapshot(myVm, vm.VmRequestSnapshot{Name: "s1"}); err == nil {
+		t.Errorf("snapshot taken without disk left on node")
+	}
+	myNode.ChangeDiskUsed(-myNode.Disk * 10)
+
+	snapshot, err := CreateVMSnapshot(myVm, vm.VmRequestSnapshot{Name: "s1"})
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	if myNode.GetDiskUsed() != myVm.Disk*1024+snapshot.Size {
+		t.Errorf("node disk used %v, expected %v", myNode.GetDiskUsed(), myVm.Disk*1024+snapshot.Size)
+	}
+}
+
+func TestCloneVMIntoCallerAccount(t *testing.T) {
+
+	for _, name := range []string{"erin", "frank"} {
+		if err := account.AccountDB.Add(account.AccountRequest{Name: name, Role: account.RoleGuest}); err != nil {
+			t.Fatalf("add account failed: %v", err)
+		}
+	}
+	owner, _ := account.AccountDB.Get("erin")
+	caller, _ := account.AccountDB.Get("frank")
+
+	vms, myTask, err := CreateVMs(owner, vm.VmRequest{Type: "centos7", CPU: 1, Memory: 1024, Disk: 20, Number: 1, Duration: 1})
+	if err != nil {
+		t.Fatalf("create vm failed: %v", err)
+	}
+	if status := waitTask(t, myTask); status != task.TaskStatusSuccess {
+		t.Fatalf("create vm task %v", status)
+	}
+	source := vms[0]
+	if err := ActionVM(owner, source, "shutdown"); err != nil {
+		t.Fatalf("shutdown vm failed: %v", err)
+	}
+	//status normally refreshed by reconciler
+	source.Lock()
+	source.Status = vm.VmStatusShutoff
+	source.Unlock()
+
+	//clone charged against quota of caller, not of source owner
+	quota.SetQuota(quota.ScopeAccount, caller.Name, quota.Resources{VM: 1})
+	defer quota.QuotaDB.Del(quota.Key(quota.ScopeAccount, caller.Name))
+
+	newVm, myTask, err := CloneVM(caller, source, vm.VmRequestClone{})
+	if err != nil {
+		t.Fatalf("clone vm failed: %v", err)
+	}
+	if status := waitTask(t, myTask); status != task.TaskStatusSuccess {
+		t.Fatalf("clone vm task %v", status)
+	}
+	if _, err := caller.GetVmByName(newVm.Name); err != nil {
+		t.Errorf("clone %v not in caller account", newVm.Name)
+	}
+	if owner.GetNumbersOfVm() != 1 {
+		t.Errorf("source owner has %v vms, expected 1", owner.GetNumbersOfVm())
+	}
+
+	if _, _, err := CloneVM(caller, source, vm.VmRequestClone{}); err == nil {
+		t.Errorf("clone accepted beyond vm quota of caller")
+	}
+}